@@ -0,0 +1,74 @@
+package http
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// change A mutation recorded in the change log, Seq is assigned in commit order starting from 1
+type change struct {
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	Key       string    `json:"key"`
+	Actor     string    `json:"actor"`
+}
+
+// changeLog Bounded log of the latest mutations, fed with the audit records of the storage
+type changeLog struct {
+	mu      sync.Mutex
+	seq     uint64
+	size    int
+	changes []change
+}
+
+func newChangeLog(size int) *changeLog {
+	return &changeLog{
+		size:    size,
+		changes: make([]change, 0, size),
+	}
+}
+
+// changeLog.Write Records an audit record as the next change, evicting the oldest one when full
+func (l *changeLog) Write(p []byte) (int, error) {
+	var c change
+	if err := json.Unmarshal(p, &c); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	c.Seq = l.seq
+
+	if len(l.changes) == l.size {
+		copy(l.changes, l.changes[1:])
+		l.changes = l.changes[:l.size-1]
+	}
+
+	l.changes = append(l.changes, c)
+
+	return len(p), nil
+}
+
+// changeLog.since Returns the changes after seq and the latest seq, ok is false when some were already evicted
+func (l *changeLog) since(seq uint64) (changes []change, latest uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.seq {
+		seq = l.seq
+	}
+
+	missing := int(l.seq - seq)
+	if missing > len(l.changes) {
+		return nil, l.seq, false
+	}
+
+	changes = make([]change, missing)
+	copy(changes, l.changes[len(l.changes)-missing:])
+
+	return changes, l.seq, true
+}
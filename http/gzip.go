@@ -0,0 +1,65 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip Returns whether the Accept-Encoding of the request takes gzip, by name or as `*`, with a non zero quality
+func acceptsGzip(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(accept, ";")
+		if coding := strings.ToLower(strings.TrimSpace(params[0])); coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		accepted := true
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				quality, err := strconv.ParseFloat(q[2:], 64)
+				accepted = err == nil && quality > 0
+			}
+		}
+
+		if accepted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeListing Writes a JSON listing, gzip encoded when it is at least the GzipListings threshold
+// and the client accepts it
+func (s *Server) writeListing(value []byte, w http.ResponseWriter, req *http.Request) {
+	if s.gzipListings <= 0 {
+		s.streamToWriter(value, w)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(value) < s.gzipListings || !acceptsGzip(req) {
+		s.streamToWriter(value, w)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(value); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error compressing listing: %s", err)
+		s.streamToWriter(value, w)
+		return
+	}
+
+	if err := zw.Close(); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error compressing listing: %s", err)
+		s.streamToWriter(value, w)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	s.streamToWriter(buf.Bytes(), w)
+}
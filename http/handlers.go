@@ -2,132 +2,2309 @@ package http
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/aspacca/keyvaluestorage/storage"
 	"github.com/gorilla/mux"
 	"io"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
-func healthHandler(w http.ResponseWriter, req *http.Request) {
+// template rendering is bounded to templateTimeout and templateMaxOutput bytes
+const (
+	templateTimeout   = time.Second
+	templateMaxOutput = 1 << 20
+)
+
+var errValueTooLarge = errors.New("value too large to buffer")
+
+var errBatchTooLarge = errors.New("batch too large")
+
+var errEncoding = errors.New("encoding must be raw or base64")
+
+// healthHandler Answers OK, or 503 with the reason while the active storage is degraded
+func (s *Server) healthHandler(w http.ResponseWriter, req *http.Request) {
+	if health := s.getStorage().Health(); health.Degraded {
+		s.logger.WithField("Component", "HTTP").Warnf("Storage degraded: %s", health.Reason)
+		http.Error(w, "DEGRADED: "+health.Reason, http.StatusServiceUnavailable)
+		return
+	}
+
 	fmt.Fprint(w, "OK")
 }
 
+type capabilities struct {
+	Provider   string            `json:"provider"`
+	Operations []string          `json:"operations"`
+	Limits     capabilitiesLimit `json:"limits"`
+}
+
+type capabilitiesLimit struct {
+	MaxListKeys  int   `json:"max_list_keys"`
+	TruncateList bool  `json:"truncate_list"`
+	MinTTL       int64 `json:"min_ttl"`
+
+	MaxPatternWildcards int `json:"max_pattern_wildcards"`
+	MaxResponseBytes    int `json:"max_response_bytes"`
+	MaxBatchSize        int `json:"max_batch_size"`
+	MaxBufferedValue    int `json:"max_buffered_value"`
+}
+
+// operations Returns the operations the server answers with the active storage, as listed by /capabilities
+func (s *Server) operations() []string {
+	strg := s.getStorage()
+
+	operations := []string{"get", "put", "delete", "delete_all", "count_pattern", "expire_pattern", "mexists", "push", "pop", "ttl", "range", "form_put", "refresh_if_ttl_below", "base64", "if_version_match", "if_match", "mget", "mget_framed", "equals", "increment", "bulk_put"}
+	if !s.disableList {
+		operations = append(operations, "pattern", "pagination", "keys_only")
+	}
+
+	if s.changes != nil {
+		operations = append(operations, "changes")
+	}
+
+	if _, ok := strg.(storage.URLSigner); ok && s.signedURLExpiry > 0 {
+		operations = append(operations, "signed_url_redirect")
+	}
+
+	if s.idempotency != nil {
+		operations = append(operations, "idempotency_key")
+	}
+
+	rejected := map[string]bool{}
+	for _, operation := range storage.Rejected(strg) {
+		rejected[operation] = true
+	}
+
+	supported := operations[:0]
+	for _, operation := range operations {
+		if !rejected[operation] {
+			supported = append(supported, operation)
+		}
+	}
+
+	return supported
+}
+
+func (s *Server) capabilitiesHandler(w http.ResponseWriter, req *http.Request) {
+	value, err := json.Marshal(capabilities{
+		Provider:   s.getStorage().Type(),
+		Operations: s.operations(),
+		Limits: capabilitiesLimit{
+			MaxListKeys:  s.maxListKeys,
+			TruncateList: s.truncateList,
+			MinTTL:       int64(s.minTTL / time.Second),
+
+			MaxPatternWildcards: s.maxPatternWildcards,
+			MaxResponseBytes:    s.maxResponseBytes,
+			MaxBatchSize:        s.maxBatchSize,
+			MaxBufferedValue:    s.maxBufferedValue,
+		},
+	})
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling capabilities: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter(value, w)
+}
+
+// trailingSlashHandler Resolves `/keys/{id}/` as `/keys/{id}`, stripping the slash or redirecting with 308
+func (s *Server) trailingSlashHandler(w http.ResponseWriter, req *http.Request) {
+	u := *req.URL
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	if s.redirectTrailingSlash {
+		http.Redirect(w, req, u.String(), http.StatusPermanentRedirect)
+		return
+	}
+
+	req.URL = &u
+	s.router.ServeHTTP(w, req)
+}
+
 func (s *Server) notFoundHandler(w http.ResponseWriter, req *http.Request) {
 	s.logger.WithField("Component", "HTTP").Debugf("Requested URL not found: %s", req.RequestURI)
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
 
-func (s *Server) putHandler(w http.ResponseWriter, req *http.Request) {
-	var expiration time.Duration
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if len(s.adminToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			s.logger.WithField("Component", "HTTP").Debugf("Unauthorized admin request: %s", req.RequestURI)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
 
-	vars := mux.Vars(req)
-	key := vars["id"]
-	value, err := ioutil.ReadAll(req.Body)
+		h(w, req)
+	}
+}
+
+// basicAuth Runs h for requests out of /keys and /blobs, or authenticated with the BasicAuth credentials,
+// answering 401 otherwise
+func (s *Server) basicAuth(h http.Handler) http.Handler {
+	// hashes compare in constant time whatever the length of the credentials
+	user := sha256.Sum256([]byte(s.authUser))
+	pass := sha256.Sum256([]byte(s.authPass))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, "/keys") && !strings.HasPrefix(req.URL.Path, "/blobs") {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		username, password, _ := req.BasicAuth()
+		givenUser := sha256.Sum256([]byte(username))
+		givenPass := sha256.Sum256([]byte(password))
+
+		if subtle.ConstantTimeCompare(givenUser[:], user[:])&subtle.ConstantTimeCompare(givenPass[:], pass[:]) != 1 {
+			s.logger.WithField("Component", "HTTP").Debugf("Unauthorized request: %s", req.RequestURI)
+			w.Header().Set("WWW-Authenticate", `Basic realm="keyvaluestorage", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (s *Server) providerHandler(w http.ResponseWriter, req *http.Request) {
+	provider := req.FormValue("provider")
+	basedir := req.FormValue("basedir")
+
+	strg, err := storage.NewStorage(provider, basedir, s.storageOptions...)
 	if err != nil {
-		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %s", err)
+		s.logger.WithField("Component", "HTTP").Errorf("Error creating storage provider (%s): %s", provider, err)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
+	old := s.swapStorage(strg)
+	old.Flush()
+
+	s.logger.WithField("Component", "HTTP").Infof("Swapped storage provider from %s to %s", old.Type(), strg.Type())
+
+	s.writeSuccess(w)
+}
+
+// readBody Returns the request body, failing when shorter than the declared Content-Length
+func readBody(req *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ContentLength >= 0 && int64(len(body)) != req.ContentLength {
+		return nil, fmt.Errorf("body length %d does not match Content-Length %d", len(body), req.ContentLength)
+	}
+
+	return body, nil
+}
+
+// decodeValue Returns the value carried by body, decoded from base64 with `encoding=base64`
+func decodeValue(req *http.Request, body []byte) ([]byte, error) {
+	switch req.FormValue("encoding") {
+	case "", "raw":
+		return body, nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+	}
+
+	return nil, errEncoding
+}
+
+// parseVersion Returns the version required via `If-Version-Match` and whether one was
+func parseVersion(req *http.Request) (int64, bool, error) {
+	ifVersionMatch := req.Header.Get("If-Version-Match")
+	if len(ifVersionMatch) == 0 {
+		return 0, false, nil
+	}
+
+	version, err := strconv.ParseInt(ifVersionMatch, 10, 64)
+	if err != nil || version < 0 {
+		return 0, false, fmt.Errorf("If-Version-Match must be a version, 0 for a missing key")
+	}
+
+	return version, true, nil
+}
+
+// parseMetadata Returns the metadata to store along the value, the `Content-Disposition` header
+// or an attachment one for the `filename` query parameter
+func parseMetadata(req *http.Request) (storage.Metadata, error) {
+	disposition := req.Header.Get("Content-Disposition")
+	if filename := req.URL.Query().Get("filename"); len(filename) > 0 {
+		if len(disposition) > 0 {
+			return nil, fmt.Errorf("Content-Disposition and filename are exclusive")
+		}
+
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+		if len(disposition) == 0 {
+			return nil, fmt.Errorf("filename is not valid")
+		}
+	}
+
+	if len(disposition) == 0 {
+		return nil, nil
+	}
+
+	if _, _, err := mime.ParseMediaType(disposition); err != nil {
+		return nil, fmt.Errorf("Content-Disposition is not valid: %s", err)
+	}
+
+	return storage.Metadata{"Content-Disposition": disposition}, nil
+}
+
+// withContentType Returns metadata along the `Content-Type` of the request, if any, served back by GET
+func withContentType(metadata storage.Metadata, req *http.Request) (storage.Metadata, error) {
+	contentType := req.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		return metadata, nil
+	}
+
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return nil, fmt.Errorf("Content-Type is not valid: %s", err)
+	}
+
+	if metadata == nil {
+		metadata = storage.Metadata{}
+	}
+
+	metadata["Content-Type"] = contentType
+
+	return metadata, nil
+}
+
+// valueContentType Returns the content type written along the metadata of a value, application/octet-stream
+// if none was stored
+func valueContentType(w http.ResponseWriter) string {
+	if contentType := w.Header().Get("Content-Type"); len(contentType) > 0 {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+// writeMetadata Sets the metadata stored along the value of key as response headers
+func writeMetadata(strg storage.Storage, key string, w http.ResponseWriter) error {
+	metadata, err := strg.GetMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range metadata {
+		w.Header().Set(name, value)
+	}
+
+	return nil
+}
+
+// writeExpireIn Sets X-Expire-In to the seconds left before a key expires, rounded up, -1 if it never does,
+// omitted if the TTL cannot be read as the key just expired
+func (s *Server) writeExpireIn(strg storage.Storage, key string, w http.ResponseWriter) {
+	ttl, err := strg.TTL(key)
+	if err != nil {
+		if !strg.IsNotExist(err) {
+			s.logger.WithField("Component", "HTTP").Debugf("Error getting TTL of key (%s): %s", key, err)
+		}
+
+		return
+	}
+
+	seconds := int64(-1)
+	if ttl >= 0 {
+		seconds = int64(math.Ceil(ttl.Seconds()))
+	}
+
+	w.Header().Set("X-Expire-In", strconv.FormatInt(seconds, 10))
+}
+
+// parseExpiration Returns the expiration requested via `expire_in` (seconds) or `expire_at`
+// (unix seconds or RFC 3339, in the future), -1 if none
+func parseExpiration(req *http.Request) (time.Duration, error) {
 	expireIn := req.FormValue("expire_in")
+	if expireAt := req.FormValue("expire_at"); len(expireAt) > 0 {
+		if len(expireIn) > 0 {
+			return 0, fmt.Errorf("expire_in and expire_at are exclusive")
+		}
+
+		return parseExpireAt(expireAt)
+	}
 
-	if len(expireIn) > 0 {
-		expirationDuration, err := strconv.Atoi(expireIn)
+	if len(expireIn) == 0 {
+		return time.Duration(-1), nil
+	}
 
-		if err != nil {
-			s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", expireIn, err)
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-			return
+	expirationDuration, err := strconv.Atoi(expireIn)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(time.Duration(expirationDuration) * time.Second), nil
+}
+
+// parsePage Returns the limit and offset of a paged listing, 0 when not given, or error if not non negative integers
+func parsePage(req *http.Request) (int, int, error) {
+	page := [2]int{}
+	for i, name := range []string{"limit", "offset"} {
+		value := req.FormValue(name)
+		if len(value) == 0 {
+			continue
 		}
 
-		expiration = time.Duration(time.Duration(expirationDuration) * time.Second)
-	} else {
-		expiration = time.Duration(-1)
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("%s must be a non negative integer", name)
+		}
+
+		page[i] = n
 	}
 
-	if err := s.storage.Put(key, string(value), expiration); err != nil {
-		s.logger.WithField("Component", "HTTP").Errorf("Error putting new key (%s): %s", key, err)
+	return page[0], page[1], nil
+}
+
+// parseExpireAt Returns the expiration until expireAt, unix seconds or RFC 3339, error if not in the future
+func parseExpireAt(expireAt string) (time.Duration, error) {
+	at, err := time.Parse(time.RFC3339, expireAt)
+	if err != nil {
+		seconds, serr := strconv.ParseInt(expireAt, 10, 64)
+		if serr != nil {
+			return 0, fmt.Errorf("expire_at must be unix seconds or RFC 3339: %s", err)
+		}
+
+		at = time.Unix(seconds, 0)
+	}
+
+	expiration := time.Until(at)
+	if expiration <= 0 {
+		return 0, fmt.Errorf("expire_at must be in the future")
+	}
+
+	return expiration, nil
+}
+
+// checkTTL Returns error if expiration is below the configured minimum TTL
+func (s *Server) checkTTL(expiration time.Duration) error {
+	if s.minTTL <= 0 {
+		return nil
+	}
+
+	if expiration < 0 {
+		return fmt.Errorf("expire_in is required, minimum is %d seconds", int64(s.minTTL/time.Second))
+	}
+
+	if expiration < s.minTTL {
+		return fmt.Errorf("expire_in below minimum of %d seconds", int64(s.minTTL/time.Second))
+	}
+
+	return nil
+}
+
+type heldLock struct {
+	Key     string  `json:"key"`
+	HeldFor float64 `json:"held_for"`
+}
+
+func (s *Server) locksHandler(w http.ResponseWriter, req *http.Request) {
+	held := s.getStorage().HeldLocks()
+
+	locks := make([]heldLock, 0, len(held))
+	for key, heldFor := range held {
+		locks = append(locks, heldLock{Key: key, HeldFor: heldFor.Seconds()})
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].HeldFor > locks[j].HeldFor
+	})
+
+	value, err := json.Marshal(locks)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling held locks: %s", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	s.streamToWriter(value, w)
 }
 
-func (s *Server) deleteHandler(w http.ResponseWriter, req *http.Request) {
-	var err error
+type storageHealth struct {
+	Provider   string                            `json:"provider"`
+	Health     storage.Health                    `json:"health"`
+	Operations map[string]storage.OperationStats `json:"operations"`
+}
 
-	vars := mux.Vars(req)
-	key := vars["id"]
+func (s *Server) storageHealthHandler(w http.ResponseWriter, req *http.Request) {
+	health := storageHealth{
+		Provider:   s.getStorage().Type(),
+		Health:     s.getStorage().Health(),
+		Operations: s.storageStats.Snapshot(),
+	}
 
-	if len(key) == 0 {
-		err = s.storage.DeleteAll()
-	} else {
-		err = s.storage.Delete(key)
+	value, err := json.Marshal(health)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling storage health: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
-	if s.storage.IsNotExist(err) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	s.streamToWriter(value, w)
+}
+
+// purgeExpiredHandler Deletes every expired entry right away, answering how many were
+func (s *Server) purgeExpiredHandler(w http.ResponseWriter, req *http.Request) {
+	strg := s.requestStorage(req)
+	purged, err := strg.PurgeExpired()
+	if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	} else if err != nil {
-		s.logger.WithField("Component", "HTTP").Errorf("Error deleting key (%s): %s", key, err)
+		s.logger.WithField("Component", "HTTP").Errorf("Error purging expired keys: %s", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	s.streamToWriter([]byte(fmt.Sprintf(`{"purged":%d}`, len(purged))), w)
 }
 
-func (s *Server) headHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	key := vars["id"]
+// initHandler Saves `key` with `value` only while no key starting with `prefix` exists, the whole storage when missing,
+// answering 409 otherwise
+func (s *Server) initHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.FormValue("key")
+	prefix := req.FormValue("prefix")
+	if len(key) == 0 {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
 
-	_, err := s.storage.Get(key)
-	if s.storage.IsNotExist(err) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	// the key being one of those checked, creating it is all it takes to make the prefix not empty
+	if !strings.HasPrefix(key, prefix) {
+		http.Error(w, fmt.Sprintf("key %s does not start with prefix %s", key, prefix), http.StatusBadRequest)
+		return
+	}
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	created, err := strg.PutIfEmpty(prefix, key, req.FormValue("value"), expiration)
+	if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	} else if err != nil {
-		s.logger.WithField("Component", "HTTP").Errorf("Error hitting key (%s): %s", key, err)
+		s.logger.WithField("Component", "HTTP").Errorf("Error initializing key (%s): %s", key, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-}
 
-func (s *Server) getHandler(w http.ResponseWriter, req *http.Request) {
-	var r io.Reader
-	var value []byte
-	var err error
+	if !created {
+		http.Error(w, fmt.Sprintf("keys starting with %q already exist", prefix), http.StatusConflict)
+		return
+	}
 
-	vars := mux.Vars(req)
-	key := vars["id"]
-	filter := req.FormValue("filter")
+	s.writeSuccess(w)
+}
 
-	if len(key) == 0 {
-		if len(filter) == 0 {
-			filter = "*"
-		}
+// putIfMatch Saves value by key with timeout only if the ETag of its current value is one of ifMatch, `*` matching any,
+// answering 412 otherwise or if the value changed meanwhile
+func (s *Server) putIfMatch(w http.ResponseWriter, req *http.Request, key string, value []byte, ifMatch string, expiration time.Duration) {
+	strg := s.requestStorage(req)
 
-		r, err = s.storage.GetPattern(filter)
-	} else {
-		r, err = s.storage.Get(key)
+	r, err := strg.Get(key)
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
-	if s.storage.IsNotExist(err) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	current, err := ioutil.ReadAll(r)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error reading key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	value, err = ioutil.ReadAll(r)
-	if err != nil {
-		s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+	if !etagMatches(ifMatch, fmt.Sprintf(`"%x"`, md5.Sum(current))) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	swapped, err := strg.CompareAndSwap(key, string(current), string(value), expiration)
+	if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error swapping key (%s): %s", key, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	s.streamToWriter(value, w)
+	if !swapped {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+// etagMatches Returns whether etag is one of the comma separated entity tags of an If-Match header, `*` matching any,
+// weak tags never matching as If-Match compares strongly
+func etagMatches(ifMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPattern Returns error if pattern has more wildcards than the configured maximum
+func (s *Server) checkPattern(pattern string) error {
+	if s.maxPatternWildcards <= 0 {
+		return nil
+	}
+
+	if wildcards := strings.Count(pattern, "*") + strings.Count(pattern, "?") + strings.Count(pattern, "["); wildcards > s.maxPatternWildcards {
+		return fmt.Errorf("filter has %d wildcards, more than the maximum of %d", wildcards, s.maxPatternWildcards)
+	}
+
+	return nil
+}
+
+func (s *Server) putHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	key := vars["id"]
+	value, err := readBody(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %s", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	value, err = decodeValue(req, value)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid body encoding: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, versioned, err := parseVersion(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if versioned && len(req.FormValue("refresh_if_ttl_below")) > 0 {
+		http.Error(w, "If-Version-Match cannot be combined with refresh_if_ttl_below", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseMetadata(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(metadata) > 0 && (versioned || len(req.FormValue("refresh_if_ttl_below")) > 0) {
+		http.Error(w, "Content-Disposition cannot be combined with If-Version-Match or refresh_if_ttl_below", http.StatusBadRequest)
+		return
+	}
+
+	// conditional writes keep the stored metadata, the Content-Type most clients send along any body is
+	// stored by plain writes only
+	if ifMatch := req.Header.Get("If-Match"); len(ifMatch) > 0 {
+		if versioned || len(req.FormValue("refresh_if_ttl_below")) > 0 || len(metadata) > 0 {
+			http.Error(w, "If-Match cannot be combined with If-Version-Match, refresh_if_ttl_below or Content-Disposition", http.StatusBadRequest)
+			return
+		}
+
+		s.putIfMatch(w, req, key, value, ifMatch, expiration)
+		return
+	}
+
+	if versioned {
+		strg := s.requestStorage(req)
+		newVersion, err := strg.PutIfVersion(key, string(value), version, expiration)
+		if strg.IsVersionMismatch(err) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		} else if strg.IsConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if strg.IsUnavailable(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error putting new key (%s): %s", key, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Version", strconv.FormatInt(newVersion, 10))
+		s.writeSuccess(w)
+		return
+	}
+
+	if refreshIfTTLBelow := req.FormValue("refresh_if_ttl_below"); len(refreshIfTTLBelow) > 0 {
+		threshold, err := strconv.Atoi(refreshIfTTLBelow)
+		if err != nil || threshold < 0 {
+			s.logger.WithField("Component", "HTTP").Debugf("Error in refresh threshold (%s): %s", refreshIfTTLBelow, err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		strg := s.requestStorage(req)
+		refreshed, err := strg.PutIfTTLBelow(key, string(value), time.Duration(threshold)*time.Second, expiration)
+		if strg.IsConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if strg.IsUnavailable(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error refreshing key (%s): %s", key, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Refreshed", strconv.FormatBool(refreshed))
+		s.writeSuccess(w)
+		return
+	}
+
+	if metadata, err = withContentType(metadata, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	if len(metadata) > 0 {
+		err = strg.PutWithMetadata(key, string(value), metadata, expiration)
+	} else {
+		err = strg.Put(key, string(value), expiration)
+	}
+
+	if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error putting new key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+// formPutHandler Saves every field of an urlencoded form as a key, `expire_in` applying to all of them
+func (s *Server) formPutHandler(w http.ResponseWriter, req *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in form content: %s", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys := make([]string, 0, len(req.PostForm))
+	for key := range req.PostForm {
+		if key != "expire_in" && key != "expire_at" {
+			keys = append(keys, key)
+		}
+	}
+
+	if s.maxBatchSize > 0 && len(keys) > s.maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch of %d keys exceeds the maximum of %d keys", len(keys), s.maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	sort.Strings(keys)
+
+	strg := s.requestStorage(req)
+	for _, key := range keys {
+		if err := strg.Put(key, req.PostForm.Get(key), expiration); strg.IsConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if strg.IsUnavailable(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error putting new key (%s): %s", key, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.writeSuccess(w)
+}
+
+// bulkPutHandler Saves every key of a JSON object body with its string value, `expire_in` applying to all of them,
+// answering 207 with the error of every key that failed to be saved
+func (s *Server) bulkPutHandler(w http.ResponseWriter, req *http.Request) {
+	var entries map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&entries); err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %s", err)
+		http.Error(w, "body must be a JSON object of string values", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := entries[""]; ok {
+		http.Error(w, "keys must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	if s.maxBatchSize > 0 && len(entries) > s.maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch of %d keys exceeds the maximum of %d keys", len(entries), s.maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	err = strg.PutMany(entries, expiration)
+	if failed, ok := err.(storage.PutManyError); ok {
+		results := make(map[string]string, len(failed))
+		for key, err := range failed {
+			if strg.IsConflict(err) || strg.IsUnavailable(err) {
+				results[key] = err.Error()
+				continue
+			}
+
+			s.logger.WithField("Component", "HTTP").Errorf("Error putting new key (%s): %s", key, err)
+			results[key] = http.StatusText(http.StatusInternalServerError)
+		}
+
+		value, err := json.Marshal(results)
+		if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error marshaling failed keys: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write(value)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error putting keys: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+func (s *Server) expireHandler(w http.ResponseWriter, req *http.Request) {
+	filter := req.FormValue("filter")
+
+	if err := s.checkPattern(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	updated, err := strg.ExpirePattern(filter, expiration)
+	if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error expiring pattern (%s): %s", filter, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, updated)
+}
+
+func (s *Server) countHandler(w http.ResponseWriter, req *http.Request) {
+	filter := req.FormValue("filter")
+
+	if err := s.checkPattern(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	count, err := strg.CountPattern(filter)
+	if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error counting pattern (%s): %s", filter, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, count)
+}
+
+// changesHandler Returns the mutations after `since`, 410 when the change log no longer holds all of them
+func (s *Server) changesHandler(w http.ResponseWriter, req *http.Request) {
+	var since uint64
+	if v := req.FormValue("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "since must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, latest, ok := s.changes.since(since)
+	w.Header().Set("X-Sequence", strconv.FormatUint(latest, 10))
+
+	if !ok {
+		http.Error(w, fmt.Sprintf("changes since %d are no longer retained: resync the keys", since), http.StatusGone)
+		return
+	}
+
+	value, err := json.Marshal(changes)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling changes (%d): %s", since, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter(value, w)
+}
+
+func (s *Server) existsManyHandler(w http.ResponseWriter, req *http.Request) {
+	keys, err := s.decodeKeys(req)
+	if err == errBatchTooLarge {
+		http.Error(w, fmt.Sprintf("batch exceeds the maximum of %d keys", s.maxBatchSize), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %s", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	exists, err := strg.ExistsMany(keys)
+	if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error checking keys existence: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	value, err := json.Marshal(exists)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling keys existence: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter(value, w)
+}
+
+// mgetHandler Returns the values of the keys in the JSON array body as a JSON object by key, missing keys being skipped,
+// or streams them with `format=framed`: every existing key is written as a frame of its key length, key, value length and value,
+// lengths being big endian uint32
+func (s *Server) mgetHandler(w http.ResponseWriter, req *http.Request) {
+	format := req.FormValue("format")
+	if format != "" && format != "json" && format != "framed" {
+		http.Error(w, "format must be json or framed", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.decodeKeys(req)
+	if err == errBatchTooLarge {
+		http.Error(w, fmt.Sprintf("batch exceeds the maximum of %d keys", s.maxBatchSize), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %s", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if format != "framed" {
+		s.mgetJSON(w, req, keys)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	strg := s.requestStorage(req)
+	written := false
+	for _, key := range keys {
+		r, err := strg.Get(key)
+		if strg.IsNotExist(err) {
+			continue
+		}
+
+		var value []byte
+		if err == nil {
+			value, err = ioutil.ReadAll(r)
+		}
+
+		if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+			if !written && strg.IsUnavailable(err) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			} else if !written {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+
+			// once streaming the truncated frame is left for the client to detect
+			return
+		}
+
+		written = true
+		if err := writeFrame(w, key, value); err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error dumping value, err: %s", err)
+			return
+		}
+	}
+}
+
+// mgetJSON Writes the values of the existing keys as a JSON object by key, in key order,
+// bytes that are not UTF-8 being replaced as in listings
+func (s *Server) mgetJSON(w http.ResponseWriter, req *http.Request, keys []string) {
+	strg := s.requestStorage(req)
+	values, err := strg.GetMany(keys)
+	if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting keys: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	object := make(map[string]string, len(values))
+	for key, value := range values {
+		object[key] = string(value)
+	}
+
+	// maps are encoded sorted by key
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(s.escapeHTML)
+	if err := encoder.Encode(object); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling values: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), w)
+}
+
+// decodeKeys Returns the JSON array of keys in the body, errBatchTooLarge as soon as it holds more than the configured maximum
+func (s *Server) decodeKeys(req *http.Request) ([]string, error) {
+	decoder := json.NewDecoder(req.Body)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, fmt.Errorf("keys must be a JSON array")
+	}
+
+	keys := []string{}
+	for decoder.More() {
+		if s.maxBatchSize > 0 && len(keys) == s.maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+
+		var key string
+		if err := decoder.Decode(&key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// writeFrame Writes key and value each preceded by its length as big endian uint32
+func writeFrame(w io.Writer, key string, value []byte) error {
+	frame := make([]byte, 0, 8+len(key)+len(value))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(key)))
+	frame = append(frame, key...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(value)))
+	frame = append(frame, value...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+func (s *Server) pushHandler(w http.ResponseWriter, req *http.Request) {
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+
+	element, err := readBody(req)
+	if err != nil || !json.Valid(element) {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in body content: %v", err)
+		http.Error(w, "element must be valid JSON", http.StatusBadRequest)
+		return
+	}
+
+	err = strg.Push(key, string(element))
+	if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error pushing to key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+func (s *Server) popHandler(w http.ResponseWriter, req *http.Request) {
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+
+	element, err := strg.Pop(key)
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error popping from key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter([]byte(element), w)
+}
+
+// counterHandler Returns the handler adding `by`, 1 by default, to the integer stored by key, subtracting it when decrement is set,
+// a missing key counting as 0, answering the new value as plain text
+func (s *Server) counterHandler(decrement bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		strg := s.requestStorage(req)
+		vars := mux.Vars(req)
+		key := vars["id"]
+
+		delta := int64(1)
+		if by := req.FormValue("by"); len(by) > 0 {
+			var err error
+			if delta, err = strconv.ParseInt(by, 10, 64); err != nil {
+				http.Error(w, "by must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		apply := func() (int64, error) {
+			if decrement {
+				return strg.Decrement(key, delta)
+			}
+
+			return strg.Increment(key, delta)
+		}
+
+		var counter int64
+		var err error
+		if idempotencyKey := req.Header.Get(IdempotencyKeyHeader); s.idempotency != nil && len(idempotencyKey) > 0 {
+			var replayed, conflict bool
+			// keys are scoped by actor, retries must repeat the same change of the same counter
+			counter, replayed, conflict, err = s.idempotency.do(actor(req)+"\x00"+idempotencyKey, req.URL.Path+"\x00"+strconv.FormatInt(delta, 10), apply)
+			if conflict {
+				http.Error(w, IdempotencyKeyHeader+" already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+
+			if replayed {
+				w.Header().Set(IdempotentReplayedHeader, "true")
+			}
+		} else {
+			counter, err = apply()
+		}
+
+		if strg.IsConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if strg.IsUnavailable(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error incrementing key (%s): %s", key, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, strconv.FormatInt(counter, 10))
+	}
+}
+
+// equalsHandler Answers whether the values of a key and of the `to` one are byte-equal, compared in chunks server-side
+func (s *Server) equalsHandler(w http.ResponseWriter, req *http.Request) {
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+
+	to := req.FormValue("to")
+	if len(to) == 0 {
+		http.Error(w, "to is required", http.StatusBadRequest)
+		return
+	}
+
+	r, err := strg.Get(key)
+	var other io.Reader
+	if err == nil {
+		other, err = strg.Get(to)
+	}
+
+	var equal bool
+	if err == nil {
+		equal, err = readersEqual(r, other)
+	}
+
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error comparing key (%s) to key (%s): %s", key, to, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.streamToWriter([]byte(strconv.FormatBool(equal)), w)
+}
+
+// readersEqual Returns whether a and b read the same bytes, holding a chunk of each at once, or error if reading fails
+func readersEqual(a io.Reader, b io.Reader) (bool, error) {
+	chunkA := make([]byte, 32*1024)
+	chunkB := make([]byte, 32*1024)
+
+	for {
+		nA, errA := io.ReadFull(a, chunkA)
+		nB, errB := io.ReadFull(b, chunkB)
+
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !doneA {
+			return false, errA
+		} else if errB != nil && !doneB {
+			return false, errB
+		}
+
+		if !bytes.Equal(chunkA[:nA], chunkB[:nB]) {
+			return false, nil
+		}
+
+		if doneA || doneB {
+			return doneA && doneB, nil
+		}
+	}
+}
+
+// blobPutHandler Saves the body as a blob split in chunk keys, streaming it without buffering it whole
+func (s *Server) blobPutHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	expiration, err := parseExpiration(req)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkTTL(expiration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strg := s.requestStorage(req)
+	size, err := storage.PutBlob(strg, id, req.Body, s.blobChunkSize, expiration)
+	if strg.IsConflict(err) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error putting blob (%s) after %d bytes: %s", id, size, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+// blobGetHandler Streams a blob reassembled from its chunk keys
+func (s *Server) blobGetHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	strg := s.requestStorage(req)
+	r, size, err := storage.GetBlob(strg, id)
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting blob (%s): %s", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	// once streaming the status is sent, a missing chunk can only cut the response short
+	if _, err := io.Copy(w, r); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error streaming blob (%s): %s", id, err)
+	}
+}
+
+// blobDeleteHandler Deletes a blob with its chunk keys
+func (s *Server) blobDeleteHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	strg := s.requestStorage(req)
+	err := storage.DeleteBlob(strg, id)
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error deleting blob (%s): %s", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+
+	version, versioned, err := parseVersion(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(key) == 0 && versioned {
+		http.Error(w, "If-Version-Match requires a key", http.StatusBadRequest)
+		return
+	} else if len(key) == 0 {
+		err = strg.DeleteAll()
+	} else if versioned {
+		err = strg.DeleteIfVersion(key, version)
+	} else {
+		err = strg.Delete(key)
+	}
+
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsVersionMismatch(err) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	} else if strg.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error deleting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccess(w)
+}
+
+func (s *Server) headHandler(w http.ResponseWriter, req *http.Request) {
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+
+	// the value is never read, only the metadata headers are
+	exists, err := strg.Exists(key)
+	if err == nil && exists {
+		err = writeMetadata(strg, key, w)
+	}
+
+	if err == nil && exists {
+		w.Header().Set("Content-Type", valueContentType(w))
+		s.writeExpireIn(strg, key, w)
+	}
+
+	if (err == nil && !exists) || strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error hitting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) getHandler(w http.ResponseWriter, req *http.Request) {
+	var r io.Reader
+	var value []byte
+	var err error
+
+	// whether no write overlapped the scan of a listing
+	consistent := true
+
+	// entries matching the filters of a paged listing
+	total := -1
+
+	// page of a listing sorted descending or by creation, taken once sorted
+	sortedLimit, sortedOffset := 0, 0
+
+	strg := s.requestStorage(req)
+	vars := mux.Vars(req)
+	key := vars["id"]
+	filter := req.FormValue("filter")
+
+	if encoding := req.FormValue("encoding"); encoding != "" && encoding != "raw" && encoding != "base64" {
+		http.Error(w, errEncoding.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(key) > 0 && s.signedURLExpiry > 0 && !s.touchOnGet && !transformsValue(req) {
+		// decorators transforming values, such as encryption, are no URLSigner
+		if signer, ok := storage.WithContext(req.Context(), s.getStorage()).(storage.URLSigner); ok {
+			s.redirectToSignedURL(signer, strg, key, w, req)
+			return
+		}
+	}
+
+	if len(key) == 0 {
+		if s.disableList {
+			http.Error(w, "listing is disabled", http.StatusForbidden)
+			return
+		}
+
+		if len(filter) == 0 {
+			filter = "*"
+		}
+
+		filters := req.Form["filter"]
+		if len(filters) == 0 {
+			filters = []string{filter}
+		}
+
+		for _, filter := range filters {
+			if err := s.checkPattern(filter); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if order := req.FormValue("sort"); order != "" && order != "asc" && order != "desc" && order != "created" {
+			http.Error(w, "sort must be asc, desc or created", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, perr := parsePage(req)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		paged := limit > 0 || offset > 0
+		if order := req.FormValue("sort"); order == "desc" || order == "created" {
+			sortedLimit, sortedOffset = limit, offset
+			limit, offset = 0, 0
+		}
+
+		op := req.FormValue("filter_op")
+		if len(op) == 0 {
+			op = "or"
+		}
+
+		if op != "or" && op != "and" {
+			http.Error(w, "filter_op must be or or and", http.StatusBadRequest)
+			return
+		} else if keysOnly, _ := strconv.ParseBool(req.FormValue("keys_only")); keysOnly {
+			s.serveKeys(strg, filters, op == "and", w, req)
+			return
+		} else if len(filters) > 1 {
+			filter = strings.Join(filters, " "+op+" ")
+
+			var matched int
+			r, matched, consistent, err = combinePatterns(strg, filters, op == "and", limit, offset)
+			if paged {
+				total = matched
+			}
+		} else if counter, ok := storage.WithContext(req.Context(), s.getStorage()).(storage.CountedPatternGetter); ok && paged {
+			// the total is counted by the scan listing the page, files are not read again
+			r, total, err = counter.GetPatternCounted(filter, limit, offset)
+			consistent = err != nil || storage.IsConsistent(r)
+		} else {
+			r, err = strg.GetPattern(filter, limit, offset)
+			consistent = err != nil || storage.IsConsistent(r)
+
+			if err == nil && paged {
+				total, err = strg.CountPattern(filter)
+			}
+		}
+	} else if defaultOnMiss, _ := strconv.ParseBool(req.FormValue("default_on_miss")); defaultOnMiss {
+		expiration, perr := parseExpiration(req)
+		if perr != nil {
+			s.logger.WithField("Component", "HTTP").Debugf("Error in expiration (%s): %s", req.FormValue("expire_in"), perr)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if perr := s.checkTTL(expiration); perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var created bool
+		value, created, err = strg.GetOrCreate(key, req.FormValue("default"), expiration)
+		r = bytes.NewReader(value)
+
+		w.Header().Set("X-Created", strconv.FormatBool(created))
+	} else if touch, _ := strconv.ParseBool(req.FormValue("touch_on_get")); touch || s.touchOnGet {
+		if s.slidingTTL <= 0 {
+			http.Error(w, "touch_on_get requires a sliding TTL window", http.StatusBadRequest)
+			return
+		}
+
+		r, err = strg.GetAndTouch(key, s.slidingTTL, s.slidingTTLThreshold)
+	} else if cache, ok := storage.WithContext(req.Context(), s.getStorage()).(storage.CachedGetter); ok {
+		var hit bool
+		r, hit, err = cache.GetCached(key)
+
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+
+		if strg.IsNotExist(err) && s.serveStale {
+			r, err = getStale(strg, key, w)
+		}
+	} else {
+		var version int64
+		if r, version, err = strg.GetVersioned(key); err == nil {
+			w.Header().Set("X-Version", strconv.FormatInt(version, 10))
+		} else if strg.IsNotExist(err) && s.serveStale {
+			r, err = getStale(strg, key, w)
+		}
+	}
+
+	if strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(key) > 0 {
+		s.writeExpireIn(strg, key, w)
+		s.serveValue(strg, key, r, w, req)
+		return
+	}
+
+	if !consistent && s.strictScans {
+		http.Error(w, "listing was modified during the scan: retry", http.StatusConflict)
+		return
+	} else if !consistent {
+		w.Header().Set("X-Consistent", "false")
+	}
+
+	if total >= 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+
+	value, err = ioutil.ReadAll(r)
+	if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	withHash, _ := strconv.ParseBool(req.FormValue("with_hash"))
+	distinct, _ := strconv.ParseBool(req.FormValue("distinct_values"))
+	encoded := req.FormValue("encoding") == "base64"
+
+	descending := req.FormValue("sort") == "desc"
+	byCreation := req.FormValue("sort") == "created"
+	if s.maxListKeys > 0 || s.maxResponseBytes > 0 || descending || byCreation || !s.escapeHTML || withHash || distinct || s.hashListedKeys || encoded {
+		var entries []map[string]string
+		if err := json.Unmarshal(value, &entries); err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error parsing listing (%s): %s", filter, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if byCreation {
+			if entries, err = sortByCreation(strg, entries); err != nil {
+				s.logger.WithField("Component", "HTTP").Errorf("Error sorting listing (%s): %s", filter, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			entries = pageListing(entries, sortedLimit, sortedOffset)
+		} else if descending {
+			// reversed once shaped, the page is counted from the end
+			entries = pageListingFromEnd(entries, sortedLimit, sortedOffset)
+		}
+
+		if encoded {
+			if entries, err = encodeEntries(strg, entries); err != nil {
+				s.logger.WithField("Component", "HTTP").Errorf("Error encoding listing (%s): %s", filter, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if s.hashListedKeys {
+			entries = hashKeys(entries)
+		}
+
+		if withHash || distinct {
+			entries = hashEntries(entries, withHash, distinct)
+		}
+
+		if descending {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+
+		if s.maxListKeys > 0 && len(entries) > s.maxListKeys {
+			if !s.truncateList {
+				http.Error(w, fmt.Sprintf("listing matches %d keys, more than the maximum of %d: use a narrower filter", len(entries), s.maxListKeys), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			entries = entries[:s.maxListKeys]
+			w.Header().Set("X-Truncated", "true")
+		}
+
+		var written int
+		if value, written, err = s.marshalListing(entries); err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error shaping listing (%s): %s", filter, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if written < len(entries) {
+			if !s.truncateList {
+				http.Error(w, fmt.Sprintf("listing exceeds the maximum of %d bytes: use a narrower filter", s.maxResponseBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			w.Header().Set("X-Truncated", "true")
+		}
+	}
+
+	s.writeListing(value, w, req)
+}
+
+// encodeEntries Returns the listing entries with their values base64 encoded as stored, got again by key
+// as JSON listings replace bytes that are not UTF-8, skipping keys deleted since listed; listings are
+// encoded only on request, plain values being what clients and the proxy provider parse
+func encodeEntries(strg storage.Storage, entries []map[string]string) ([]map[string]string, error) {
+	encoded := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		for key := range entry {
+			r, err := strg.Get(key)
+			if strg.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			value, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+
+			encoded = append(encoded, map[string]string{key: base64.StdEncoding.EncodeToString(value)})
+		}
+	}
+
+	return encoded, nil
+}
+
+// hashKeys Returns the listing entries with their keys replaced by the hex sha256 of them
+func hashKeys(entries []map[string]string) []map[string]string {
+	hashed := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		for key, value := range entry {
+			hash := sha256.Sum256([]byte(key))
+			hashed = append(hashed, map[string]string{hex.EncodeToString(hash[:]): value})
+		}
+	}
+
+	return hashed
+}
+
+// hashEntries Returns the listing entries as `{"key":…,"value":…,"hash":…}` with the md5 of their value when withHash is set,
+// only the first key of every distinct value when distinct is set
+func hashEntries(entries []map[string]string, withHash bool, distinct bool) []map[string]string {
+	seen := map[[md5.Size]byte]bool{}
+
+	shaped := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		for key, value := range entry {
+			hash := md5.Sum([]byte(value))
+			if distinct && seen[hash] {
+				continue
+			}
+
+			seen[hash] = true
+
+			if withHash {
+				entry = map[string]string{"key": key, "value": value, "hash": hex.EncodeToString(hash[:])}
+			}
+
+			shaped = append(shaped, entry)
+		}
+	}
+
+	return shaped
+}
+
+// transformsValue Returns whether a single-key GET asks for more than the value as stored
+func transformsValue(req *http.Request) bool {
+	defaultOnMiss, _ := strconv.ParseBool(req.FormValue("default_on_miss"))
+	touch, _ := strconv.ParseBool(req.FormValue("touch_on_get"))
+	pretty, _ := strconv.ParseBool(req.FormValue("pretty"))
+
+	return defaultOnMiss || touch || pretty || len(req.FormValue("template")) > 0 || req.FormValue("encoding") == "base64" || acceptsYAML(req)
+}
+
+// redirectToSignedURL Redirects to the URL signer serves the value of key from, 404 if key does not exist
+func (s *Server) redirectToSignedURL(signer storage.URLSigner, strg storage.Storage, key string, w http.ResponseWriter, req *http.Request) {
+	var location string
+
+	exists, err := strg.Exists(key)
+	if err == nil && exists {
+		location, err = signer.SignedURL(key, s.signedURLExpiry)
+	}
+
+	if (err == nil && !exists) || strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error signing url of key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, location, http.StatusFound)
+}
+
+// getStale Returns io.Reader for a key expired within the storage grace period, warning it is stale, or error if it fails
+func getStale(strg storage.Storage, key string, w http.ResponseWriter) (io.Reader, error) {
+	r, stale, err := strg.GetStale(key)
+	if err == nil && stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+
+	return r, err
+}
+
+// serveKeys Writes the JSON array of the keys matching any of filters, or all of them when intersect is set,
+// without their values, honouring sort, limit and offset as listings do
+func (s *Server) serveKeys(strg storage.Storage, filters []string, intersect bool, w http.ResponseWriter, req *http.Request) {
+	if req.FormValue("sort") == "created" {
+		http.Error(w, "keys_only cannot be sorted by creation", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := combineKeys(strg, filters, intersect)
+	if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error listing keys (%s): %s", strings.Join(filters, ", "), err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if req.FormValue("sort") == "desc" {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	// validated along the listing
+	limit, offset, _ := parsePage(req)
+	if limit > 0 || offset > 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(keys)))
+
+		if offset > len(keys) {
+			offset = len(keys)
+		}
+
+		keys = keys[offset:]
+		if limit > 0 && limit < len(keys) {
+			keys = keys[:limit]
+		}
+	}
+
+	if s.maxListKeys > 0 && len(keys) > s.maxListKeys {
+		if !s.truncateList {
+			http.Error(w, fmt.Sprintf("listing matches %d keys, more than the maximum of %d: use a narrower filter", len(keys), s.maxListKeys), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		keys = keys[:s.maxListKeys]
+		w.Header().Set("X-Truncated", "true")
+	}
+
+	if s.hashListedKeys {
+		for i, key := range keys {
+			hash := sha256.Sum256([]byte(key))
+			keys[i] = hex.EncodeToString(hash[:])
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(s.escapeHTML)
+	if err := encoder.Encode(keys); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error marshaling keys: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeListing(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), w, req)
+}
+
+// combineKeys Returns the sorted keys matching any of patterns, or all of them when intersect is set
+func combineKeys(strg storage.Storage, patterns []string, intersect bool) ([]string, error) {
+	if len(patterns) == 1 {
+		return strg.Keys(patterns[0])
+	}
+
+	matches := map[string]int{}
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		if seen[pattern] {
+			continue
+		}
+
+		seen[pattern] = true
+
+		keys, err := strg.Keys(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			matches[key]++
+		}
+	}
+
+	keys := make([]string, 0, len(matches))
+	for key, count := range matches {
+		if !intersect || count == len(seen) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// combinePatterns Returns the page of limit entries from offset, all of them if limit is 0, of the listing
+// of entries matching any of patterns, or all of them when intersect is set, how many entries match
+// and whether no write overlapped any of the scans
+func combinePatterns(strg storage.Storage, patterns []string, intersect bool, limit int, offset int) (io.Reader, int, bool, error) {
+	values := map[string]string{}
+	matches := map[string]int{}
+	seen := map[string]bool{}
+	consistent := true
+	for _, pattern := range patterns {
+		if seen[pattern] {
+			continue
+		}
+
+		seen[pattern] = true
+
+		r, err := strg.GetPattern(pattern, 0, 0)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		consistent = consistent && storage.IsConsistent(r)
+
+		var entries []map[string]string
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, 0, false, err
+		}
+
+		for _, entry := range entries {
+			for key, value := range entry {
+				values[key] = value
+				matches[key]++
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !intersect || matches[key] == len(seen) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	listing := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		listing = append(listing, map[string]string{key: values[key]})
+	}
+
+	value, err := json.Marshal(pageListing(listing, limit, offset))
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return bytes.NewReader(value), len(listing), consistent, nil
+}
+
+// pageListing Returns limit entries of the listing from offset, all of them if limit is 0
+func pageListing(entries []map[string]string, limit int, offset int) []map[string]string {
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+
+	entries = entries[offset:]
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+// pageListingFromEnd Returns, in their order, the limit entries of the listing preceding the last offset ones,
+// all of them if limit is 0
+func pageListingFromEnd(entries []map[string]string, limit int, offset int) []map[string]string {
+	end := len(entries) - offset
+	if end < 0 {
+		end = 0
+	}
+
+	start := 0
+	if limit > 0 && limit < end {
+		start = end - limit
+	}
+
+	return entries[start:end]
+}
+
+// sortByCreation Returns the listing entries ordered by the creation time of their keys, oldest first,
+// keys without one following in key order and keys deleted since listed being skipped
+func sortByCreation(strg storage.Storage, entries []map[string]string) ([]map[string]string, error) {
+	type createdEntry struct {
+		entry   map[string]string
+		created time.Time
+		known   bool
+	}
+
+	listed := make([]createdEntry, 0, len(entries))
+	for _, entry := range entries {
+		for key := range entry {
+			metadata, err := strg.GetMetadata(key)
+			if strg.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			created, err := time.Parse(time.RFC3339Nano, metadata[storage.CreatedAtHeader])
+			listed = append(listed, createdEntry{entry: entry, created: created, known: err == nil})
+		}
+	}
+
+	sort.SliceStable(listed, func(i, j int) bool {
+		if listed[i].known != listed[j].known {
+			return listed[i].known
+		}
+
+		return listed[i].created.Before(listed[j].created)
+	})
+
+	sorted := make([]map[string]string, 0, len(listed))
+	for _, entry := range listed {
+		sorted = append(sorted, entry.entry)
+	}
+
+	return sorted, nil
+}
+
+// marshalListing Returns the JSON listing, escaping HTML characters unless disabled, and the number of entries
+// written: encoding stops at the last whole entry fitting within maxResponseBytes
+func (s *Server) marshalListing(entries []map[string]string) ([]byte, int, error) {
+	limit := s.maxResponseBytes
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+
+	buf := &limitedBuffer{limit: limit}
+	if _, err := buf.Write([]byte("[")); err != nil {
+		return []byte("[]"), 0, nil
+	}
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(s.escapeHTML)
+
+	for i, entry := range entries {
+		mark := buf.Len()
+
+		if i > 0 {
+			if _, err := buf.Write([]byte(",")); err != nil {
+				return append(buf.Bytes(), ']'), i, nil
+			}
+		}
+
+		// the trailing newline written by the encoder keeps room for the following comma or closing bracket
+		if err := encoder.Encode(entry); err != nil {
+			buf.Truncate(mark)
+			return append(buf.Bytes(), ']'), i, nil
+		}
+
+		buf.Truncate(buf.Len() - 1)
+	}
+
+	return append(buf.Bytes(), ']'), len(entries), nil
+}
+
+// limitedBuffer fails writes once more than limit bytes would be buffered
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("output exceeds %d bytes", b.limit)
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// renderTemplate Writes a JSON value rendered through a text/template
+func (s *Server) renderTemplate(value []byte, text string, w http.ResponseWriter) {
+	var data interface{}
+	if err := json.Unmarshal(value, &data); err != nil {
+		http.Error(w, "value is not JSON", http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := template.New("value").Parse(text)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid template: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	result := make(chan error, 1)
+	output := &limitedBuffer{limit: templateMaxOutput}
+	go func() {
+		result <- tmpl.Execute(output, data)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			http.Error(w, fmt.Sprintf("template execution failed: %s", err), http.StatusBadRequest)
+			return
+		}
+	case <-time.After(templateTimeout):
+		http.Error(w, "template execution timed out", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(output.Len()))
+
+	if _, err := io.Copy(w, &output.Buffer); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error dumping rendered template, err: %s", err)
+	}
+}
+
+// serveValue Serves the value of key read from r, streaming it from the storage unless it has to be transformed,
+// in which case it is buffered up to the configured maximum
+func (s *Server) serveValue(strg storage.Storage, key string, r io.Reader, w http.ResponseWriter, req *http.Request) {
+	tmpl := req.FormValue("template")
+	encoded := req.FormValue("encoding") == "base64"
+	yaml := acceptsYAML(req)
+	pretty, _ := strconv.ParseBool(req.FormValue("pretty"))
+
+	if len(tmpl) == 0 && !encoded && !yaml && !pretty {
+		if !s.serveMetadata(strg, key, w) {
+			return
+		}
+
+		w.Header().Add("Vary", "Accept")
+		contentType := valueContentType(w)
+		if rs, ok := r.(io.ReadSeeker); ok {
+			s.serveReader(rs, contentType, w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := io.Copy(w, r); err != nil {
+			s.logger.WithField("Component", "HTTP").Errorf("Error dumping value, err: %s", err)
+		}
+
+		return
+	}
+
+	value, err := s.bufferValue(r)
+	if err == errValueTooLarge {
+		http.Error(w, fmt.Sprintf("value is larger than the maximum of %d bytes buffered to transform it: get it as is", s.maxBufferedValue), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting key (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(tmpl) > 0 {
+		s.renderTemplate(value, tmpl, w)
+		return
+	}
+
+	if !s.serveMetadata(strg, key, w) {
+		return
+	}
+
+	if encoded {
+		s.serveContent([]byte(base64.StdEncoding.EncodeToString(value)), "text/plain; charset=utf-8", w, req)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if yaml {
+		if converted, ok := jsonToYAML(value); ok {
+			s.serveContent(converted, "application/yaml", w, req)
+			return
+		}
+	} else if indented, ok := indentJSON(value); ok {
+		s.serveContent(indented, "application/json", w, req)
+		return
+	}
+
+	s.serveContent(value, valueContentType(w), w, req)
+}
+
+// indentJSON Returns value reindented, false if it is not JSON
+func indentJSON(value []byte) ([]byte, bool) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, value, "", "  "); err != nil {
+		return nil, false
+	}
+
+	return indented.Bytes(), true
+}
+
+// bufferValue Returns the value read from r, errValueTooLarge if longer than the configured maximum
+func (s *Server) bufferValue(r io.Reader) ([]byte, error) {
+	if s.maxBufferedValue <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	value, err := ioutil.ReadAll(io.LimitReader(r, int64(s.maxBufferedValue)+1))
+	if err == nil && len(value) > s.maxBufferedValue {
+		return nil, errValueTooLarge
+	}
+
+	return value, err
+}
+
+// serveMetadata Sets the metadata of key as response headers, answering the error and returning false if it fails
+func (s *Server) serveMetadata(strg storage.Storage, key string, w http.ResponseWriter) bool {
+	if err := writeMetadata(strg, key, w); strg.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return false
+	} else if strg.IsUnavailable(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return false
+	} else if err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error getting key metadata (%s): %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// serveContent Writes a single value honouring Range and If-Range against its ETag
+func (s *Server) serveContent(value []byte, contentType string, w http.ResponseWriter, req *http.Request) {
+	s.serveReader(bytes.NewReader(value), contentType, w, req)
+}
+
+// serveReader Serves the content of rs hashing it for the ETag, without holding it in memory
+func (s *Server) serveReader(rs io.ReadSeeker, contentType string, w http.ResponseWriter, req *http.Request) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, rs); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error hashing value, err: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		s.logger.WithField("Component", "HTTP").Errorf("Error rewinding value, err: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, hash.Sum(nil)))
+
+	http.ServeContent(w, req, "", time.Time{}, rs)
+}
+
+// writeSuccess Answers a successful write with the configured success status
+func (s *Server) writeSuccess(w http.ResponseWriter) {
+	if s.successStatus != http.StatusOK {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.streamToWriter([]byte(`{"ok":true}`), w)
 }
 
 func (s *Server) streamToWriter(value []byte, w http.ResponseWriter) {
@@ -2,12 +2,41 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/aspacca/keyvaluestorage/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -92,6 +121,49 @@ func TestServer_Health(t *testing.T) {
 	assertBody(rr, `OK`, t)
 }
 
+type degradedStorage struct {
+	storage.Storage
+}
+
+func (s degradedStorage) Health() storage.Health {
+	return storage.Health{Degraded: true, Reason: "memory storage snapshot failed 3 consecutive times: no space left on device", FlushFailures: 3, FlushFailuresTotal: 5}
+}
+
+func TestServer_HealthDegraded(t *testing.T) {
+	s := boostrap(t)
+	UseStorage(degradedStorage{s.getStorage()})(s)
+	AdminToken("secret")(s)
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusServiceUnavailable, t)
+	assertBody(rr, "DEGRADED: memory storage snapshot failed 3 consecutive times: no space left on device\n", t)
+
+	req, err = http.NewRequest("GET", "/admin/storage-health", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	var health storageHealth
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !health.Health.Degraded || health.Health.FlushFailures != 3 || health.Health.FlushFailuresTotal != 5 {
+		t.Fatalf("expected degraded health, found : %+v", health.Health)
+	}
+}
+
 func TestServer_PutWithExpiration(t *testing.T) {
 	s := boostrap(t)
 
@@ -332,6 +404,181 @@ func TestServer_HeadNotFound(t *testing.T) {
 	assertStatus(rr, http.StatusNotFound, t)
 }
 
+func TestServer_HeadExpired(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key?expire_in=0", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("HEAD", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+}
+
+func TestServer_Equals(t *testing.T) {
+	s := boostrap(t)
+
+	for key, value := range map[string]string{"a key": "a value", "a copy": "a value", "another key": "another value", "a longer key": "a value and more"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/keys/a key/equals?to=a copy", http.StatusOK, "true"},
+		{"/keys/a key/equals?to=another key", http.StatusOK, "false"},
+		{"/keys/a key/equals?to=a longer key", http.StatusOK, "false"},
+		{"/keys/a longer key/equals?to=a key", http.StatusOK, "false"},
+		{"/keys/a key/equals?to=a missing key", http.StatusNotFound, "Not Found\n"},
+		{"/keys/a missing key/equals?to=a key", http.StatusNotFound, "Not Found\n"},
+		{"/keys/a key/equals", http.StatusBadRequest, "to is required\n"},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, test.status, t)
+		assertBody(rr, test.body, t)
+	}
+}
+
+func TestServer_DisableList(t *testing.T) {
+	s := boostrap(t)
+	DisableList()(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for _, path := range []string{"/keys", "/keys?filter=*", "/keys?filter=a*"} {
+		req, err = http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusForbidden, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+}
+
+func TestServer_GetExpireIn(t *testing.T) {
+	s := boostrap(t)
+
+	for _, path := range []string{"/keys/a key", "/keys/an expiring key?expire_in=60"} {
+		req, err := http.NewRequest("PUT", path, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		for key, expected := range map[string]string{"a key": "-1", "an expiring key": "60"} {
+			req, err := http.NewRequest(method, "/keys/"+key, nil)
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			rr := executeRequest(req, s)
+
+			assertStatus(rr, http.StatusOK, t)
+
+			if expireIn := rr.Header().Get("X-Expire-In"); expireIn != expected {
+				t.Fatalf("expected: %s, found : %s", expected, expireIn)
+			}
+		}
+	}
+}
+
+func TestServer_HashListedKeys(t *testing.T) {
+	for _, hashed := range []bool{false, true} {
+		s := boostrap(t)
+		if hashed {
+			HashListedKeys()(s)
+		}
+
+		req, err := http.NewRequest("PUT", "/keys/user@example.com", bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		req, err = http.NewRequest("GET", "/keys?filter=*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+
+		key := "user@example.com"
+		if hashed {
+			hash := sha256.Sum256([]byte(key))
+			key = hex.EncodeToString(hash[:])
+		}
+
+		assertBody(rr, `[{"`+key+`":"a value"}]`, t)
+
+		req, err = http.NewRequest("GET", "/keys/user@example.com", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, "a value", t)
+	}
+}
+
 func TestServer_GetWithFilterEmpty(t *testing.T) {
 	s := boostrap(t)
 
@@ -364,3 +611,3788 @@ func TestServer_GetWithFilterEmpty(t *testing.T) {
 	assertStatus(rr, http.StatusOK, t)
 	assertBody(rr, `[]`, t)
 }
+
+func TestServer_SwapProvider(t *testing.T) {
+	emptyDir := filepath.Join(os.TempDir(), "keyvaluestorage-empty")
+	seededDir := filepath.Join(os.TempDir(), "keyvaluestorage-seeded")
+	for _, dir := range []string{emptyDir, seededDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("err in boostrap: %s", err)
+		}
+	}
+
+	seeded, err := storage.NewMemoryStorage(seededDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = seeded.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	seeded.Flush()
+
+	empty, err := storage.NewMemoryStorage(emptyDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(empty), AdminToken("secret"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	req, err := http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	req, err = http.NewRequest("POST", "/admin/provider?provider=memory&basedir="+seededDir, nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusUnauthorized, t)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `a value`, t)
+}
+
+func TestServer_ExpirePattern(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key?expire_in=1", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("PUT", "/keys/another key?expire_in=1", bytes.NewReader([]byte("another value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("PUT", "/keys/expire?filter=another*&expire_in=3600", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `1`, t)
+
+	time.Sleep(time.Duration(2 * time.Second))
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	req, err = http.NewRequest("GET", "/keys/another key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `another value`, t)
+}
+
+func TestServer_GetIfRange(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	etag := rr.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatalf("expected ETag header")
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Range", "bytes=2-6")
+	req.Header.Set("If-Range", etag)
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusPartialContent, t)
+	assertBody(rr, `value`, t)
+
+	req.Header.Set("If-Range", `"stale"`)
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `a value`, t)
+}
+
+func TestServer_GetWithFilterMaxListKeys(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		s := boostrap(t)
+		MaxListKeys(1, truncate)(s)
+
+		for _, key := range []string{"a key", "another key"} {
+			req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte("a value")))
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			rr := executeRequest(req, s)
+
+			assertStatus(rr, http.StatusNoContent, t)
+		}
+
+		req, err := http.NewRequest("GET", "/keys?filter=another*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, `[{"another key":"a value"}]`, t)
+
+		req, err = http.NewRequest("GET", "/keys?filter=*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		if truncate {
+			assertStatus(rr, http.StatusOK, t)
+
+			var entries []map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			if len(entries) != 1 {
+				t.Fatalf("expected: %d, found : %d", 1, len(entries))
+			}
+
+			if chk := rr.Header().Get("X-Truncated"); chk != "true" {
+				t.Fatalf("expected: %s, found : %s", "true", chk)
+			}
+		} else {
+			assertStatus(rr, http.StatusRequestEntityTooLarge, t)
+		}
+	}
+}
+
+func TestServer_AuditLog(t *testing.T) {
+	s := boostrap(t)
+
+	var log bytes.Buffer
+	AuditLog(&log)(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.SetBasicAuth("an actor", "a password")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("DELETE", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.SetBasicAuth("an actor", "a password")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	decoder := json.NewDecoder(&log)
+	for _, operation := range []string{"put", "delete"} {
+		var record map[string]string
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if record["operation"] != operation || record["key"] != "a key" || record["actor"] != "an actor" {
+			t.Fatalf("unexpected audit record: %v", record)
+		}
+	}
+
+	if decoder.More() {
+		t.Fatalf("unexpected audit records")
+	}
+}
+
+func TestServer_Pprof(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := boostrap(t)
+		AdminToken("secret")(s)
+		if enabled {
+			EnablePprof()(s)
+		}
+
+		s.setupRouter()
+
+		req, err := http.NewRequest("GET", "/debug/pprof/", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := executeRequest(req, s)
+
+		if enabled {
+			assertStatus(rr, http.StatusOK, t)
+		} else {
+			assertStatus(rr, http.StatusNotFound, t)
+		}
+	}
+}
+
+func TestServer_Capabilities(t *testing.T) {
+	s := boostrap(t)
+	MaxListKeys(100, true)(s)
+
+	req, err := http.NewRequest("GET", "/capabilities", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	var chk capabilities
+	if err := json.Unmarshal(rr.Body.Bytes(), &chk); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if chk.Provider != "fs" {
+		t.Fatalf("expected: %s, found : %s", "fs", chk.Provider)
+	}
+
+	if chk.Limits.MaxListKeys != 100 || !chk.Limits.TruncateList {
+		t.Fatalf("unexpected limits: %+v", chk.Limits)
+	}
+
+	if len(chk.Operations) == 0 {
+		t.Fatalf("expected operations")
+	}
+
+	for _, operation := range []string{"pattern", "pagination", "keys_only", "changes", "delete", "if_version_match"} {
+		if !containsString(chk.Operations, operation) {
+			t.Fatalf("expected %s in: %v", operation, chk.Operations)
+		}
+	}
+
+	// disabled features and operations the storage rejects are left out
+	s = boostrap(t)
+	DisableList()(s)
+	ChangeLogSize(0)(s)
+	MaxBatchSize(10)(s)
+	MaxBufferedValue(1024)(s)
+	UseStorage(storage.NewAppendOnlyStorage(s.getStorage()))(s)
+	s.setupRouter()
+
+	req, err = http.NewRequest("GET", "/capabilities", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	chk = capabilities{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &chk); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, operation := range []string{"pattern", "pagination", "keys_only", "changes", "delete", "delete_all", "expire_pattern"} {
+		if containsString(chk.Operations, operation) {
+			t.Fatalf("expected no %s in: %v", operation, chk.Operations)
+		}
+	}
+
+	if !containsString(chk.Operations, "get") || !containsString(chk.Operations, "count_pattern") {
+		t.Fatalf("expected get and count_pattern in: %v", chk.Operations)
+	}
+
+	if chk.Limits.MaxBatchSize != 10 || chk.Limits.MaxBufferedValue != 1024 {
+		t.Fatalf("unexpected limits: %+v", chk.Limits)
+	}
+
+	// the routes advertised are the ones answered
+	req, err = http.NewRequest("GET", "/keys/changes?since=0", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	if rr.Code == http.StatusOK {
+		t.Fatalf("expected the change log not served")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signingStorage signs URLs of a stub object store for its keys
+type signingStorage struct {
+	storage.Storage
+}
+
+func (s signingStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	return fmt.Sprintf("https://a-bucket.s3.amazonaws.com/%s?X-Amz-Expires=%d&X-Amz-Signature=stub", url.PathEscape(key), int64(expiry/time.Second)), nil
+}
+
+func TestServer_SignedURLRedirect(t *testing.T) {
+	s := boostrap(t)
+	UseStorage(signingStorage{s.getStorage()})(s)
+	SignedURLRedirect(15 * time.Minute)(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte(`{"a":1}`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusFound, t)
+
+	expected := "https://a-bucket.s3.amazonaws.com/a%20key?X-Amz-Expires=900&X-Amz-Signature=stub"
+	if location := rr.Header().Get("Location"); location != expected {
+		t.Fatalf("expected: %s, found : %s", expected, location)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a missing key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	// transformed values are served by the server
+	req, err = http.NewRequest("GET", "/keys/a key?encoding=base64", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, base64.StdEncoding.EncodeToString([]byte(`{"a":1}`)), t)
+
+	req, err = http.NewRequest("GET", "/capabilities", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	if !strings.Contains(rr.Body.String(), `"signed_url_redirect"`) {
+		t.Fatalf("expected signed_url_redirect capability, found : %s", rr.Body.String())
+	}
+}
+
+func TestServer_GetTrailingSlash(t *testing.T) {
+	for _, redirect := range []bool{false, true} {
+		s := boostrap(t)
+		if redirect {
+			RedirectTrailingSlash()(s)
+		}
+
+		req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, `a value`, t)
+
+		req, err = http.NewRequest("GET", "/keys/a key/", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		if redirect {
+			assertStatus(rr, http.StatusPermanentRedirect, t)
+
+			if chk := rr.Header().Get("Location"); chk != "/keys/a%20key" {
+				t.Fatalf("expected: %s, found : %s", "/keys/a%20key", chk)
+			}
+		} else {
+			assertStatus(rr, http.StatusOK, t)
+			assertBody(rr, `a value`, t)
+		}
+
+		req, err = http.NewRequest("DELETE", "/keys/", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusMethodNotAllowed, t)
+
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, `a value`, t)
+	}
+}
+
+func TestServer_PutMinTTL(t *testing.T) {
+	s := boostrap(t)
+	MinTTL(time.Duration(60 * time.Second))(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("PUT", "/keys/a key?expire_in=30", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("PUT", "/keys/a key?expire_in=60", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `a value`, t)
+}
+
+func TestServer_CountWithFilter(t *testing.T) {
+	s := boostrap(t)
+
+	for _, key := range []string{"a key", "another key"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err := http.NewRequest("GET", "/keys/count?filter=another*", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `1`, t)
+}
+
+type heldLocksStorage struct {
+	storage.Storage
+}
+
+func (s heldLocksStorage) HeldLocks() map[string]time.Duration {
+	return map[string]time.Duration{"a key": time.Duration(2 * time.Second)}
+}
+
+func TestServer_PurgeExpired(t *testing.T) {
+	s := boostrap(t)
+	AdminToken("secret")(s)
+
+	for _, path := range []string{"/keys/an expired key?expire_in=0", "/keys/another expired key?expire_in=0", "/keys/a key"} {
+		req, err := http.NewRequest("PUT", path, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err := http.NewRequest("POST", "/admin/purge-expired", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusUnauthorized, t)
+
+	for _, expected := range []string{`{"purged":2}`, `{"purged":0}`} {
+		req, err = http.NewRequest("POST", "/admin/purge-expired", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer secret")
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+}
+
+func TestServer_GetStale(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-stale")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	memory, err := storage.NewMemoryStorage(tmpDir, storage.ExpiryGrace(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(memory), AdminToken("secret"), ServeStale())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	req, err := http.NewRequest("PUT", "/keys/an expired key?expire_in=0", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	purge := func(expected string) {
+		req, err := http.NewRequest("POST", "/admin/purge-expired", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/an expired key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+
+	if warning := rr.Header().Get("Warning"); warning != `110 - "Response is Stale"` {
+		t.Fatalf("expected: %s, found : %s", `110 - "Response is Stale"`, warning)
+	}
+
+	purge(`{"purged":0}`)
+
+	time.Sleep(600 * time.Millisecond)
+
+	req, err = http.NewRequest("GET", "/keys/an expired key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	if warning := rr.Header().Get("Warning"); warning != "" {
+		t.Fatalf("expected no warning, found : %s", warning)
+	}
+
+	purge(`{"purged":1}`)
+}
+
+// writeCertificate Writes a self-signed PEM certificate and its key to dir, returns their paths
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestServer_TLS(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-tls")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	certPath, keyPath := writeCertificate(t, tmpDir)
+
+	if _, err := New(TLSCert(certPath), TLSKey(keyPath), TLSRedirect("127.0.0.1:0")); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, options := range [][]OptionFn{
+		{TLSCert(certPath)},
+		{TLSKey(keyPath)},
+		{TLSRedirect("127.0.0.1:0")},
+		{TLSCert(certPath), TLSKey(filepath.Join(tmpDir, "missing.pem"))},
+		{TLSCert(keyPath), TLSKey(certPath)},
+	} {
+		if _, err := New(options...); err == nil {
+			t.Fatalf("expected error for TLS options")
+		}
+	}
+}
+
+func TestServer_RedirectToTLS(t *testing.T) {
+	for listener, expected := range map[string]string{
+		"0.0.0.0:8443": "https://example.com:8443/keys/a%20key?expire_in=10",
+		"0.0.0.0:443":  "https://example.com/keys/a%20key?expire_in=10",
+	} {
+		s, err := New(Listener(listener))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req, err := http.NewRequest("PUT", "http://example.com:8080/keys/a%20key?expire_in=10", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := httptest.NewRecorder()
+		s.redirectToTLS(rr, req)
+
+		assertStatus(rr, http.StatusPermanentRedirect, t)
+
+		if location := rr.Header().Get("Location"); location != expected {
+			t.Fatalf("expected: %s, found : %s", expected, location)
+		}
+	}
+}
+
+func TestServer_BasicAuth(t *testing.T) {
+	if _, err := New(BasicAuth("user", "")); err == nil {
+		t.Fatalf("err expected")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-auth")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	memory, err := storage.NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(memory), BasicAuth("user", "secret"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	for _, credentials := range [][]string{nil, {"user", "wrong"}, {"other", "secret"}} {
+		req, err := http.NewRequest("PUT", "/keys/a%20key", bytes.NewBufferString("a value"))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if credentials != nil {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+
+		rr := executeRequest(req, s)
+		assertStatus(rr, http.StatusUnauthorized, t)
+
+		if challenge := rr.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Basic ") {
+			t.Fatalf("expected: Basic challenge, found : %s", challenge)
+		}
+	}
+
+	req, err := http.NewRequest("PUT", "/keys/a%20key", bytes.NewBufferString("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.SetBasicAuth("user", "secret")
+
+	rr := executeRequest(req, s)
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a%20key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.SetBasicAuth("user", "secret")
+
+	rr = executeRequest(req, s)
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+
+	req, err = http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+	assertStatus(rr, http.StatusOK, t)
+}
+
+func TestServer_Metrics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-metrics")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	memory, err := storage.NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(memory), Metrics())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	for _, tc := range []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"PUT", "/keys/a%20key", http.StatusNoContent},
+		{"GET", "/keys/a%20key", http.StatusOK},
+		{"GET", "/keys/another%20key", http.StatusNotFound},
+		{"DELETE", "/keys/a%20key", http.StatusNoContent},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, bytes.NewBufferString("a value"))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+		assertStatus(rr, tc.status, t)
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+	assertStatus(rr, http.StatusOK, t)
+
+	for _, expected := range []string{
+		`keyvaluestorage_operations_total{operation="get",storage="memory"} 2`,
+		`keyvaluestorage_operations_total{operation="put",storage="memory"} 1`,
+		`keyvaluestorage_operations_total{operation="delete",storage="memory"} 1`,
+		`keyvaluestorage_hits_total{storage="memory"} 1`,
+		`keyvaluestorage_misses_total{storage="memory"} 1`,
+		`keyvaluestorage_operation_duration_seconds_count{operation="get",storage="memory"} 2`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Fatalf("expected: %s, found : %s", expected, rr.Body.String())
+		}
+	}
+}
+
+func TestServer_Init(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-init")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	memory, err := storage.NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(memory), AdminToken("secret"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/admin/init?key=leader&value=me", http.StatusNoContent},
+		{"/admin/init?key=leader&value=another", http.StatusConflict},
+		{"/admin/init?key=other&value=another", http.StatusConflict},
+		{"/admin/init?key=jobs/leader&value=another&prefix=jobs/", http.StatusNoContent},
+		{"/admin/init?key=jobs/other&value=another&prefix=jobs/", http.StatusConflict},
+		{"/admin/init?key=leader&value=another&prefix=jobs/", http.StatusBadRequest},
+		{"/admin/init?value=another", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", test.path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, test.status, t)
+	}
+
+	req, err := http.NewRequest("GET", "/keys/leader", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "me", t)
+}
+
+func TestServer_HeldLocks(t *testing.T) {
+	s := boostrap(t)
+	UseStorage(heldLocksStorage{s.getStorage()})(s)
+	AdminToken("secret")(s)
+
+	req, err := http.NewRequest("GET", "/admin/locks", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `[{"key":"a key","held_for":2}]`, t)
+}
+
+func TestServer_StorageHealth(t *testing.T) {
+	s := boostrap(t)
+	UseStorage(storage.NewLatencyStorage(s.getStorage(), time.Millisecond, 0))(s)
+	AdminToken("secret")(s)
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+	}
+
+	req, err := http.NewRequest("GET", "/admin/storage-health", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	var health storageHealth
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, operation := range []string{"put", "get"} {
+		stats, ok := health.Operations[operation]
+		if !ok {
+			t.Fatalf("expected: %s, found : %v", operation, health.Operations)
+		}
+
+		if stats.Count != 3 {
+			t.Fatalf("expected: %d, found : %d", 3, stats.Count)
+		}
+
+		if stats.Errors != 0 || stats.ErrorRate != 0 {
+			t.Fatalf("expected: %d, found : %d", 0, stats.Errors)
+		}
+
+		if stats.P50 <= 0 || stats.P99 < stats.P50 {
+			t.Fatalf("expected: nonzero latencies, found : %v", stats)
+		}
+	}
+}
+
+func TestServer_MaxBatchSize(t *testing.T) {
+	s := boostrap(t)
+	MaxBatchSize(3)(s)
+
+	for path, body := range map[string]string{
+		"/keys/mexists":            `["a","b","c","d"]`,
+		"/keys/mget?format=framed": `["a","b","c","d"]`,
+	} {
+		req, err := http.NewRequest("POST", path, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusBadRequest, t)
+		assertBody(rr, "batch exceeds the maximum of 3 keys\n", t)
+	}
+
+	req, err := http.NewRequest("POST", "/keys", strings.NewReader("a=1&b=2&c=3&d=4"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+	assertBody(rr, "batch of 4 keys exceeds the maximum of 3 keys\n", t)
+
+	req, err = http.NewRequest("POST", "/keys", strings.NewReader("a=1&b=2&c=3&expire_in=60"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("POST", "/keys/mexists", bytes.NewReader([]byte(`["a","b","d"]`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `{"a":true,"b":true,"d":false}`, t)
+}
+
+func TestServer_ExistsMany(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("PUT", "/keys/an expired key?expire_in=0", bytes.NewReader([]byte("an expired value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("POST", "/keys/mexists", bytes.NewReader([]byte(`["a key","an expired key","a missing key"]`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `{"a key":true,"a missing key":false,"an expired key":false}`, t)
+}
+
+func TestServer_GetReadCache(t *testing.T) {
+	s := boostrap(t)
+	UseStorage(storage.NewCacheStorage(s.getStorage(), 10, time.Duration(time.Minute)))(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for _, expected := range []string{"MISS", "HIT"} {
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, `a value`, t)
+
+		if chk := rr.Header().Get("X-Cache"); chk != expected {
+			t.Fatalf("expected: %s, found : %s", expected, chk)
+		}
+	}
+}
+
+func TestServer_GetWithFilterMaxPatternWildcards(t *testing.T) {
+	s := boostrap(t)
+	MaxPatternWildcards(2)(s)
+
+	req, err := http.NewRequest("GET", "/keys?filter=a*b?c[de]*", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("GET", "/keys?filter=another*", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `[]`, t)
+}
+
+func TestServer_GetWithTemplate(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte(`{"name":"a name","tags":["a","b"]}`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key?template="+url.QueryEscape(`name: {{.name}} ({{len .tags}})`), nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `name: a name (2)`, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key?template="+url.QueryEscape(`{{.name`), nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+}
+
+func TestServer_PushPop(t *testing.T) {
+	s := boostrap(t)
+
+	for _, element := range []string{`1`, `"two"`, `{"three":3}`} {
+		req, err := http.NewRequest("POST", "/keys/a list/push", bytes.NewReader([]byte(element)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for _, expected := range []string{`{"three":3}`, `"two"`, `1`} {
+		req, err := http.NewRequest("POST", "/keys/a list/pop", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("POST", "/keys/a key/push", bytes.NewReader([]byte(`1`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusConflict, t)
+}
+
+func TestServer_GetDefaultOnMiss(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("GET", "/keys/a key?default_on_miss=true&default={}", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `{}`, t)
+
+	if chk := rr.Header().Get("X-Created"); chk != "true" {
+		t.Fatalf("expected: %s, found : %s", "true", chk)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key?default_on_miss=true&default={}", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `a value`, t)
+
+	if chk := rr.Header().Get("X-Created"); chk != "false" {
+		t.Fatalf("expected: %s, found : %s", "false", chk)
+	}
+}
+
+func TestServer_PutTruncatedBody(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a val")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.ContentLength = int64(len("a value"))
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+}
+
+func TestServer_GetWithFilterSorted(t *testing.T) {
+	s := boostrap(t)
+
+	for _, key := range []string{"c", "a", "b"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(key)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for order, expected := range map[string]string{
+		"":     `[{"a":"a"},{"b":"b"},{"c":"c"}]`,
+		"asc":  `[{"a":"a"},{"b":"b"},{"c":"c"}]`,
+		"desc": `[{"c":"c"},{"b":"b"},{"a":"a"}]`,
+	} {
+		req, err := http.NewRequest("GET", "/keys?filter=*&sort="+order, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_GetKeysOnly(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("GET", "/keys?keys_only=true", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `[]`, t)
+
+	for _, key := range []string{"c", "a", "b", "ab"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte("a value of "+key)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for url, expected := range map[string]string{
+		"/keys?keys_only=true":                                   `["a","ab","b","c"]`,
+		"/keys?keys_only=true&filter=a*":                         `["a","ab"]`,
+		"/keys?keys_only=true&filter=a*&filter=c":                `["a","ab","c"]`,
+		"/keys?keys_only=true&filter=a*&filter=*b&filter_op=and": `["ab"]`,
+		"/keys?keys_only=true&filter=z*":                         `[]`,
+		"/keys?keys_only=true&sort=desc&limit=2":                 `["c","b"]`,
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_GetWithFilterPaged(t *testing.T) {
+	s := boostrap(t)
+
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-paged")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	// memory pages by key
+	memory, err := storage.NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(memory)(s)
+
+	for _, key := range []string{"c", "a", "b", "ab"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(key)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+		total    string
+	}{
+		{"/keys?filter=*&limit=2", `[{"a":"a"},{"ab":"ab"}]`, "4"},
+		{"/keys?filter=a*&limit=1&offset=1", `[{"ab":"ab"}]`, "2"},
+		{"/keys?filter=*&offset=3", `[{"c":"c"}]`, "4"},
+		{"/keys?filter=a*&filter=c&limit=2&offset=1", `[{"ab":"ab"},{"c":"c"}]`, "3"},
+		{"/keys?filter=*&limit=2&offset=10", `[]`, "4"},
+		{"/keys?filter=*", `[{"a":"a"},{"ab":"ab"},{"b":"b"},{"c":"c"}]`, ""},
+		{"/keys?filter=*&sort=desc&limit=1", `[{"c":"c"}]`, "4"},
+		{"/keys?filter=*&sort=desc&limit=2&offset=1", `[{"b":"b"},{"ab":"ab"}]`, "4"},
+		{"/keys?filter=a*&filter=c&sort=desc&limit=2", `[{"c":"c"},{"ab":"ab"}]`, "3"},
+	} {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, tc.expected, t)
+
+		if total := rr.Header().Get("X-Total-Count"); total != tc.total {
+			t.Fatalf("expected: %s, found : %s", tc.total, total)
+		}
+	}
+
+	for _, query := range []string{"limit=-1", "limit=a", "offset=-2"} {
+		req, err := http.NewRequest("GET", "/keys?filter=*&"+query, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusBadRequest, t)
+	}
+}
+
+func TestServer_GetWithFilterGzip(t *testing.T) {
+	s := boostrap(t)
+	GzipListings(100)(s)
+
+	value := strings.Repeat("a value ", 20)
+	for _, key := range []string{"a key", "another key", "b key"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, strings.NewReader(value))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for _, tc := range []struct {
+		url            string
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{"/keys?filter=*", "gzip, deflate", true},
+		{"/keys?filter=*&keys_only=true", "gzip", false},
+		{"/keys?filter=*", "", false},
+		{"/keys?filter=*", "gzip;q=0, identity", false},
+		{"/keys?filter=b*", "*", true},
+		{"/keys?filter=z*", "gzip", false},
+	} {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if len(tc.acceptEncoding) > 0 {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+
+		if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Fatalf("expected: %s, found : %s", "Accept-Encoding", vary)
+		}
+
+		body := rr.Body.Bytes()
+		if gzipped := rr.Header().Get("Content-Encoding") == "gzip"; gzipped != tc.gzipped {
+			t.Fatalf("expected gzipped %t for %s with %q", tc.gzipped, tc.url, tc.acceptEncoding)
+		} else if gzipped {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			if body, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+		}
+
+		var listing []interface{}
+		if err := json.Unmarshal(body, &listing); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+}
+
+func TestServer_GetWithFilterDistinctValues(t *testing.T) {
+	s := boostrap(t)
+
+	for key, value := range map[string]string{"a": "x", "b": "y", "c": "x", "d": "z", "e": "y"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	hash := func(value string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(value)))
+	}
+
+	for query, expected := range map[string]string{
+		"distinct_values=true":                `[{"a":"x"},{"b":"y"},{"d":"z"}]`,
+		"distinct_values=true&sort=desc":      `[{"d":"z"},{"b":"y"},{"a":"x"}]`,
+		"with_hash=true&distinct_values=true": `[{"hash":"` + hash("x") + `","key":"a","value":"x"},{"hash":"` + hash("y") + `","key":"b","value":"y"},{"hash":"` + hash("z") + `","key":"d","value":"z"}]`,
+		"with_hash=true&filter=[ac]":          `[{"hash":"` + hash("x") + `","key":"a","value":"x"},{"hash":"` + hash("x") + `","key":"c","value":"x"}]`,
+	} {
+		req, err := http.NewRequest("GET", "/keys?"+query, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_Changes(t *testing.T) {
+	s := boostrap(t)
+	ChangeLogSize(3)(s)
+
+	mutate := func(method string, key string) {
+		req, err := http.NewRequest(method, "/keys/"+key, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	mutate("PUT", "a")
+	mutate("PUT", "b")
+	mutate("DELETE", "a")
+
+	changes := func(since string, expected []string) {
+		req, err := http.NewRequest("GET", "/keys/changes?since="+since, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+
+		var found []change
+		if err := json.Unmarshal(rr.Body.Bytes(), &found); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		var chk []string
+		for _, c := range found {
+			chk = append(chk, fmt.Sprintf("%d %s %s", c.Seq, c.Operation, c.Key))
+		}
+
+		if !reflect.DeepEqual(chk, expected) {
+			t.Fatalf("expected: %v, found : %v", expected, chk)
+		}
+	}
+
+	changes("0", []string{"1 put a", "2 put b", "3 delete a"})
+	changes("1", []string{"2 put b", "3 delete a"})
+	changes("3", nil)
+
+	mutate("PUT", "c")
+
+	changes("1", []string{"2 put b", "3 delete a", "4 put c"})
+
+	req, err := http.NewRequest("GET", "/keys/changes?since=0", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusGone, t)
+
+	if rr.Header().Get("X-Sequence") != "4" {
+		t.Fatalf("expected: %s, found : %s", "4", rr.Header().Get("X-Sequence"))
+	}
+}
+
+func TestServer_GetWithFilterEscapeHTML(t *testing.T) {
+	for escape, expected := range map[bool]string{
+		true:  `[{"a key":"\u003cb\u003ebold\u003c/b\u003e \u0026 \"quoted\""}]`,
+		false: `[{"a key":"<b>bold</b> & \"quoted\""}]`,
+	} {
+		s := boostrap(t)
+		EscapeHTML(escape)(s)
+
+		req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte(`<b>bold</b> & "quoted"`)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		req, err = http.NewRequest("GET", "/keys?filter=*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_GetWithFilterMaxResponseBytes(t *testing.T) {
+	s := boostrap(t)
+
+	for _, key := range []string{"a", "b", "c"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	// each entry is 15 bytes, the whole listing is 49
+	for _, tc := range []struct {
+		max      int
+		truncate bool
+		status   int
+		body     string
+	}{
+		{49, false, http.StatusOK, `[{"a":"a value"},{"b":"a value"},{"c":"a value"}]`},
+		{48, false, http.StatusRequestEntityTooLarge, "listing exceeds the maximum of 48 bytes: use a narrower filter\n"},
+		{48, true, http.StatusOK, `[{"a":"a value"},{"b":"a value"}]`},
+		{16, true, http.StatusOK, `[]`},
+	} {
+		MaxResponseBytes(tc.max)(s)
+		MaxListKeys(0, tc.truncate)(s)
+
+		req, err := http.NewRequest("GET", "/keys?filter=*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+		assertBody(rr, tc.body, t)
+
+		if truncated := rr.Header().Get("X-Truncated") == "true"; truncated != (tc.truncate && tc.body != `[{"a":"a value"},{"b":"a value"},{"c":"a value"}]`) {
+			t.Fatalf("unexpected X-Truncated: %s", rr.Header().Get("X-Truncated"))
+		}
+	}
+}
+
+func TestServer_FormPut(t *testing.T) {
+	s := boostrap(t)
+
+	form := url.Values{}
+	form.Set("a key", "a value")
+	form.Set("another key", "<b>another value</b>")
+	form.Set("expire_in", "60")
+
+	req, err := http.NewRequest("POST", "/keys", bytes.NewReader([]byte(form.Encode())))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for key, expected := range map[string]string{"a key": "a value", "another key": "<b>another value</b>"} {
+		req, err = http.NewRequest("GET", "/keys/"+url.PathEscape(key), nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/expire_in", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	form = url.Values{}
+	form.Set("a third key", "a third value")
+	form.Set("expire_at", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+	req, err = http.NewRequest("POST", "/keys", bytes.NewReader([]byte(form.Encode())))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a%20third%20key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a third value", t)
+
+	if expireIn := rr.Header().Get("X-Expire-In"); expireIn == "" || expireIn == "-1" {
+		t.Fatalf("expected: an expiration, found : %s", expireIn)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/expire_at", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+
+	req, err = http.NewRequest("POST", "/keys", bytes.NewReader([]byte(`{"a key":"a value"}`)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusUnsupportedMediaType, t)
+}
+
+func TestServer_ReservedKeyNames(t *testing.T) {
+	s := boostrap(t)
+
+	for _, key := range []string{"admin", "health", "metrics", "capabilities", "changes", "count", "expire", "mexists", "mget", "bulk"} {
+		for _, tc := range []struct {
+			method string
+			url    string
+			body   string
+			status int
+			value  string
+		}{
+			{"PUT", "/keys/" + key + "?expire_in=60", "a " + key + " value", http.StatusNoContent, ""},
+			{"GET", "/keys/" + key, "", http.StatusOK, "a " + key + " value"},
+			{"HEAD", "/keys/" + key, "", http.StatusOK, ""},
+			{"PUT", "/keys/" + key, "1", http.StatusNoContent, ""},
+			{"POST", "/keys/" + key + "/increment", "", http.StatusOK, "2"},
+			{"DELETE", "/keys/" + key, "", http.StatusNoContent, ""},
+			{"GET", "/keys/" + key, "", http.StatusNotFound, ""},
+		} {
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			rr := executeRequest(req, s)
+
+			assertStatus(rr, tc.status, t)
+			if len(tc.value) > 0 {
+				assertBody(rr, tc.value, t)
+			}
+		}
+	}
+
+	// the fixed routes are still served along their query
+	for _, tc := range []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/keys/count?filter=*", http.StatusOK},
+		{"GET", "/keys/changes?since=0", http.StatusOK},
+		{"PUT", "/keys/expire?filter=*&expire_in=60", http.StatusOK},
+		{"GET", "/health", http.StatusOK},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+	}
+}
+
+func TestServer_BulkPut(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("POST", "/keys/bulk?expire_in=60", strings.NewReader(`{"a key":"a value","another key":"<b>another value</b>"}`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for key, expected := range map[string]string{"a key": "a value", "another key": "<b>another value</b>"} {
+		req, err = http.NewRequest("GET", "/keys/"+url.PathEscape(key), nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	for _, body := range []string{`["a key"]`, `{"a key":1}`, `{"":"a value"}`} {
+		req, err = http.NewRequest("POST", "/keys/bulk", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusBadRequest, t)
+	}
+
+	MaxBatchSize(1)(s)
+
+	req, err = http.NewRequest("POST", "/keys/bulk", strings.NewReader(`{"a key":"a value","another key":"another value"}`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+}
+
+func TestServer_BulkPutPartialFailure(t *testing.T) {
+	s := boostrap(t)
+
+	strg, err := storage.NewStorage("memory", os.TempDir()+"/"+"keyvaluestorage", storage.AppendOnly())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(strg)(s)
+
+	if err := strg.Put("a key", "a value", -1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", "/keys/bulk", strings.NewReader(`{"a key":"another value","another key":"another value"}`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusMultiStatus, t)
+
+	var failed map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &failed); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, ok := failed["a key"]; !ok || len(failed) != 1 {
+		t.Fatalf("expected: %s, found : %v", "a key failed", failed)
+	}
+
+	for key, expected := range map[string]string{"a key": "a value", "another key": "another value"} {
+		req, err = http.NewRequest("GET", "/keys/"+url.PathEscape(key), nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_PutRefreshIfTTLBelow(t *testing.T) {
+	s := boostrap(t)
+
+	for _, tc := range []struct {
+		query     string
+		value     string
+		refreshed string
+		expected  string
+	}{
+		{"refresh_if_ttl_below=30&expire_in=300", "first", "true", "first"},
+		{"refresh_if_ttl_below=30&expire_in=300", "skipped", "false", "first"},
+		{"refresh_if_ttl_below=600&expire_in=300", "refreshed", "true", "refreshed"},
+	} {
+		req, err := http.NewRequest("PUT", "/keys/a key?"+tc.query, bytes.NewReader([]byte(tc.value)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		if rr.Header().Get("X-Refreshed") != tc.refreshed {
+			t.Fatalf("expected: %s, found : %s", tc.refreshed, rr.Header().Get("X-Refreshed"))
+		}
+
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, tc.expected, t)
+	}
+}
+
+// faultStorage fails the operations configured in faults with their error, delegating the others
+type faultStorage struct {
+	storage.Storage
+
+	faults map[string]error
+}
+
+func (s faultStorage) Get(key string) (io.Reader, error) {
+	if err := s.faults["get"]; err != nil {
+		return nil, err
+	}
+
+	return s.Storage.Get(key)
+}
+
+func (s faultStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	if err := s.faults["get"]; err != nil {
+		return nil, 0, err
+	}
+
+	return s.Storage.GetVersioned(key)
+}
+
+func (s faultStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	if err := s.faults["get_pattern"]; err != nil {
+		return nil, err
+	}
+
+	return s.Storage.GetPattern(pattern, limit, offset)
+}
+
+func (s faultStorage) Put(key string, value string, expiration time.Duration) error {
+	if err := s.faults["put"]; err != nil {
+		return err
+	}
+
+	return s.Storage.Put(key, value, expiration)
+}
+
+func (s faultStorage) Delete(key string) error {
+	if err := s.faults["delete"]; err != nil {
+		return err
+	}
+
+	return s.Storage.Delete(key)
+}
+
+func (s faultStorage) DeleteAll() error {
+	if err := s.faults["delete_all"]; err != nil {
+		return err
+	}
+
+	return s.Storage.DeleteAll()
+}
+
+func TestServer_StorageFaults(t *testing.T) {
+	for _, tc := range []struct {
+		operation string
+		method    string
+		url       string
+		body      string
+	}{
+		{"get", "GET", "/keys/a key", ""},
+		{"get_pattern", "GET", "/keys?filter=*", ""},
+		{"put", "PUT", "/keys/a key", "a value"},
+		{"delete", "DELETE", "/keys/a key", ""},
+		{"delete_all", "DELETE", "/keys", ""},
+	} {
+		s := boostrap(t)
+		UseStorage(faultStorage{
+			Storage: s.getStorage(),
+			faults:  map[string]error{tc.operation: errors.New("a fault")},
+		})(s)
+
+		hook := test.NewLocal(s.logger)
+
+		req, err := http.NewRequest(tc.method, tc.url, bytes.NewReader([]byte(tc.body)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusInternalServerError, t)
+		assertBody(rr, http.StatusText(http.StatusInternalServerError)+"\n", t)
+
+		entry := hook.LastEntry()
+		if entry == nil || entry.Level != logrus.ErrorLevel || !strings.Contains(entry.Message, "a fault") {
+			t.Fatalf("expected the fault of %s to be logged, found : %v", tc.operation, entry)
+		}
+	}
+}
+
+func TestServer_TypedKeys(t *testing.T) {
+	s := boostrap(t)
+
+	strg, err := storage.NewStorage("memory", os.TempDir()+"/"+"keyvaluestorage", storage.TypedKeys())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(strg)(s)
+
+	req, err := http.NewRequest("POST", "/keys/a list/push", bytes.NewReader([]byte("1")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("PUT", "/keys/a list", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusConflict, t)
+	assertBody(rr, "wrong type: operation against a key holding another kind of value\n", t)
+
+	req, err = http.NewRequest("POST", "/keys/a counter/increment", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	req, err = http.NewRequest("PUT", "/keys/a counter", bytes.NewReader([]byte("5")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusConflict, t)
+}
+
+func TestServer_GetWithFilters(t *testing.T) {
+	s := boostrap(t)
+
+	for _, key := range []string{"foo1", "foo2", "bar1", "baz1"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(key)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	for query, expected := range map[string]string{
+		"filter=foo*&filter=bar*":                      `[{"bar1":"bar1"},{"foo1":"foo1"},{"foo2":"foo2"}]`,
+		"filter=foo*&filter=bar*&filter_op=or":         `[{"bar1":"bar1"},{"foo1":"foo1"},{"foo2":"foo2"}]`,
+		"filter=*1&filter=ba*&filter_op=and":           `[{"bar1":"bar1"},{"baz1":"baz1"}]`,
+		"filter=foo*&filter=bar*&filter_op=and":        `[]`,
+		"filter=*1&filter=ba*&filter_op=and&sort=desc": `[{"baz1":"baz1"},{"bar1":"bar1"}]`,
+	} {
+		req, err := http.NewRequest("GET", "/keys?"+query, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+
+	req, err := http.NewRequest("GET", "/keys?filter=foo*&filter=bar*&filter_op=xor", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+}
+
+func TestServer_ProxyStorageCanceled(t *testing.T) {
+	// the remote stub answers only once the request is canceled, reporting it did
+	aborted := make(chan struct{}, 2)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer remote.Close()
+
+	strg, err := storage.NewStorage("proxy", "", storage.ProxyURL(remote.URL), storage.CircuitBreaker(2, time.Minute))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(strg), RequestTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	// first aborted by the request timeout, then by the client going away before it
+	for _, clientGone := range []bool{false, true} {
+		req, err := http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if clientGone {
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+
+			time.AfterFunc(10*time.Millisecond, cancel)
+			req = req.WithContext(ctx)
+		}
+
+		started := time.Now()
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusServiceUnavailable, t)
+
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Fatalf("expected the request to be aborted, took: %s", elapsed)
+		}
+
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the remote request to be canceled")
+		}
+	}
+}
+
+func TestServer_ProxyStorage(t *testing.T) {
+	backend := boostrap(t)
+
+	remote := httptest.NewServer(backend.router)
+	defer remote.Close()
+
+	strg, err := storage.NewStorage("proxy", "", storage.ProxyURL(remote.URL))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s, err := New(UseStorage(strg))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	s.setupRouter()
+
+	req, err := http.NewRequest("PUT", "/keys/a key?expire_in=60", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for _, front := range []*Server{s, backend} {
+		req, err = http.NewRequest("GET", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, front)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, "a value", t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys?filter=a*", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `[{"a key":"a value"}]`, t)
+
+	req, err = http.NewRequest("POST", "/keys/a key/push", bytes.NewReader([]byte("1")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusConflict, t)
+
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req, err = http.NewRequest("DELETE", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, status, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+}
+
+func TestServer_GetTouch(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("GET", "/keys/a key?touch_on_get=true", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	SlidingTTL(time.Hour, time.Hour, false)(s)
+
+	req, err = http.NewRequest("PUT", "/keys/a key?expire_in=1", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key?touch_on_get=true", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+}
+
+func TestServer_ContentType(t *testing.T) {
+	s := boostrap(t)
+
+	for key, contentType := range map[string]string{
+		"a json":   "application/json",
+		"an image": "image/png",
+		"a text":   "text/plain; charset=utf-8",
+		"a value":  "",
+	} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte("a value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+
+		for _, method := range []string{"GET", "HEAD"} {
+			req, err = http.NewRequest(method, "/keys/"+key, nil)
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			rr = executeRequest(req, s)
+
+			assertStatus(rr, http.StatusOK, t)
+
+			if found := rr.Header().Get("Content-Type"); found != contentType {
+				t.Fatalf("expected: %s, found : %s", contentType, found)
+			}
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/keys?filter=a*", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	if found := rr.Header().Get("Content-Type"); found != "application/json" {
+		t.Fatalf("expected: %s, found : %s", "application/json", found)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "not a type;")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	// conditional writes keep the stored type, whatever type is sent along
+	for _, tc := range []struct {
+		url    string
+		header string
+		value  string
+	}{
+		{"/keys/a json", "If-Version-Match", "1"},
+		{"/keys/a json", "If-Match", "*"},
+		{"/keys/a json?expire_in=60", "", ""},
+		{"/keys/a json?refresh_if_ttl_below=120&expire_in=60", "", ""},
+	} {
+		req, err = http.NewRequest("PUT", tc.url, bytes.NewReader([]byte("another value")))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if len(tc.header) > 0 {
+			req.Header.Set(tc.header, tc.value)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a json?refresh_if_ttl_below=120&expire_in=60", bytes.NewReader([]byte("a refreshed value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a json", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a refreshed value", t)
+
+	if found := rr.Header().Get("Content-Type"); found != "application/json" {
+		t.Fatalf("expected: %s, found : %s", "application/json", found)
+	}
+}
+
+func TestServer_ContentDisposition(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key?filename=report 2020.pdf", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for _, method := range []string{"GET", "HEAD"} {
+		req, err = http.NewRequest(method, "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+
+		if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="report 2020.pdf"` {
+			t.Fatalf("expected: %s, found : %s", `attachment; filename="report 2020.pdf"`, disposition)
+		}
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/another key", bytes.NewReader([]byte("another value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Disposition", "inline; filename=page.html")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/another key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "another value", t)
+
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != "inline; filename=page.html" {
+		t.Fatalf("expected: %s, found : %s", "inline; filename=page.html", disposition)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/another key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Content-Disposition", "attachment; filename=")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("PUT", "/keys/another key", bytes.NewReader([]byte("a value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/another key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != "" {
+		t.Fatalf("expected no Content-Disposition, found : %s", disposition)
+	}
+}
+
+func TestServer_GetYAML(t *testing.T) {
+	s := boostrap(t)
+
+	values := map[string]string{
+		"a json key": `{"name":"a value","tags":["a","b"],"count":3,"ratio":0.5,"nested":{"ok":true,"none":null}}`,
+		"a raw key":  "a value: not json",
+	}
+
+	for key, value := range values {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err := http.NewRequest("GET", "/keys/a json key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Accept", "text/html, application/yaml;q=0.9")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `name: a value
+tags:
+- a
+- b
+count: 3
+ratio: 0.5
+nested:
+  ok: true
+  none: null
+`, t)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/yaml" {
+		t.Fatalf("expected: %s, found : %s", "application/yaml", contentType)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a raw key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("Accept", "application/yaml")
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, values["a raw key"], t)
+
+	req, err = http.NewRequest("GET", "/keys/a json key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, values["a json key"], t)
+}
+
+func TestServer_GetPretty(t *testing.T) {
+	s := boostrap(t)
+
+	values := map[string]string{
+		"a json key": `{"name":"a value","tags":["a","b"],"nested":{"ok":true}}`,
+		"a raw key":  "{a value: not json",
+	}
+
+	for key, value := range values {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/keys/a json key?pretty=true", `{
+  "name": "a value",
+  "tags": [
+    "a",
+    "b"
+  ],
+  "nested": {
+    "ok": true
+  }
+}`},
+		{"/keys/a raw key?pretty=true", values["a raw key"]},
+		{"/keys/a json key", values["a json key"]},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, test.expected, t)
+	}
+}
+
+func TestServer_Blobs(t *testing.T) {
+	s := boostrap(t)
+	BlobChunkSize(1000)(s)
+
+	value := make([]byte, 4500)
+	for i := range value {
+		value[i] = byte(i % 251)
+	}
+
+	req, err := http.NewRequest("PUT", "/blobs/a blob", bytes.NewReader(value))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a blob", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `{"size":4500,"chunks":5}`, t)
+
+	req, err = http.NewRequest("GET", "/keys/a blob%234", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	if !bytes.Equal(rr.Body.Bytes(), value[4000:]) {
+		t.Fatalf("expected the last %d bytes, found : %d bytes", 500, rr.Body.Len())
+	}
+
+	req, err = http.NewRequest("GET", "/blobs/a blob", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	if !bytes.Equal(rr.Body.Bytes(), value) {
+		t.Fatalf("expected the %d bytes put, found : %d bytes", len(value), rr.Body.Len())
+	}
+
+	if contentLength := rr.Header().Get("Content-Length"); contentLength != "4500" {
+		t.Fatalf("expected: %s, found : %s", "4500", contentLength)
+	}
+
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req, err = http.NewRequest("DELETE", "/blobs/a blob", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, status, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a blob%230", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+}
+
+// largeValueStorage serves value for every key without copying it
+type largeValueStorage struct {
+	storage.Storage
+	value []byte
+}
+
+func (s largeValueStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	return bytes.NewReader(s.value), 1, nil
+}
+
+func (s largeValueStorage) GetMetadata(key string) (storage.Metadata, error) {
+	return nil, nil
+}
+
+// discardResponseWriter counts the bytes written without keeping them
+type discardResponseWriter struct {
+	header  http.Header
+	status  int
+	written int
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.written += len(b)
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func TestServer_GetStreamsLargeValue(t *testing.T) {
+	s := boostrap(t)
+
+	value := bytes.Repeat([]byte("a"), 16<<20)
+	UseStorage(largeValueStorage{s.getStorage(), value})(s)
+
+	req, err := http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	w := &discardResponseWriter{header: http.Header{}}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	s.router.ServeHTTP(w, req)
+
+	runtime.ReadMemStats(&after)
+
+	if w.status != http.StatusOK || w.written != len(value) {
+		t.Fatalf("expected: %d bytes with %d, found : %d bytes with %d", len(value), http.StatusOK, w.written, w.status)
+	}
+
+	if length := w.header.Get("Content-Length"); length != strconv.Itoa(len(value)) {
+		t.Fatalf("expected: %d, found : %s", len(value), length)
+	}
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("expected the handler to allocate less than 1MB streaming a 16MB value, found : %d", allocated)
+	}
+}
+
+func TestServer_MaxBufferedValue(t *testing.T) {
+	s := boostrap(t)
+	MaxBufferedValue(8)(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("a longer value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key?encoding=base64", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusRequestEntityTooLarge, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a longer value", t)
+}
+
+func TestServer_RecordLastWriter(t *testing.T) {
+	s := boostrap(t)
+	RecordLastWriter()(s)
+
+	for _, user := range []string{"alice", "bob"} {
+		req, err := http.NewRequest("PUT", "/keys/a key?filename=report.pdf", bytes.NewReader([]byte("a value by "+user)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		req.SetBasicAuth(user, "a password")
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		for _, method := range []string{"GET", "HEAD"} {
+			req, err = http.NewRequest(method, "/keys/a key", nil)
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			rr = executeRequest(req, s)
+
+			assertStatus(rr, http.StatusOK, t)
+
+			if writer := rr.Header().Get("X-Last-Writer"); writer != user {
+				t.Fatalf("expected: %s, found : %s", user, writer)
+			}
+
+			if disposition := rr.Header().Get("Content-Disposition"); disposition != "attachment; filename=report.pdf" {
+				t.Fatalf("expected: %s, found : %s", "attachment; filename=report.pdf", disposition)
+			}
+		}
+	}
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader([]byte("an anonymous value")))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("X-Last-Writer", "alice")
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	if writer := rr.Header().Get("X-Last-Writer"); writer != "anonymous" {
+		t.Fatalf("expected: %s, found : %s", "anonymous", writer)
+	}
+}
+
+func TestServer_CreationTimes(t *testing.T) {
+	s := boostrap(t)
+
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-created")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	memory, err := storage.NewMemoryStorage(tmpDir, storage.CreationTimes())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(memory)(s)
+
+	for _, key := range []string{"c", "a", "b", "c"} {
+		req, err := http.NewRequest("PUT", "/keys/"+key, bytes.NewReader([]byte(key)))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		req, err := http.NewRequest(method, "/keys/a", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+
+		if _, err := time.Parse(time.RFC3339Nano, rr.Header().Get("X-Created-At")); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for url, expected := range map[string]string{
+		"/keys?filter=*&sort=created":                   `[{"c":"c"},{"a":"a"},{"b":"b"}]`,
+		"/keys?filter=*&sort=created&limit=1&offset=1":  `[{"a":"a"}]`,
+		"/keys?filter=a&filter=b&sort=created&offset=1": `[{"b":"b"}]`,
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusOK, t)
+		assertBody(rr, expected, t)
+	}
+}
+
+func TestServer_Base64Encoding(t *testing.T) {
+	s := boostrap(t)
+
+	binary := []byte{0x00, 0xff, 0xfe, '"', '<', 0x80, '\n'}
+
+	req, err := http.NewRequest("PUT", "/keys/a key?encoding=base64", strings.NewReader(base64.StdEncoding.EncodeToString(binary)))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	if !bytes.Equal(rr.Body.Bytes(), binary) {
+		t.Fatalf("expected: %v, found : %v", binary, rr.Body.Bytes())
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a key?encoding=base64", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, base64.StdEncoding.EncodeToString(binary), t)
+
+	req, err = http.NewRequest("PUT", "/keys/another key?encoding=base64", strings.NewReader("not base64!"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	for _, path := range []string{"/keys/a key?encoding=hex", "/keys?encoding=hex"} {
+		req, err = http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusBadRequest, t)
+	}
+}
+
+func TestServer_Tracing(t *testing.T) {
+	s := boostrap(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	Tracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))(s)
+	s.setupRouter()
+
+	req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// the trace of the caller goes on
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	request, ok := spans["PUT /keys/{id}"]
+	if !ok {
+		t.Fatalf("expected request span, found : %v", spans)
+	}
+
+	if request.SpanKind() != trace.SpanKindServer || request.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || request.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected server span child of the caller, found : %v %v", request.SpanKind(), request.Parent())
+	}
+
+	put, ok := spans["storage put"]
+	if !ok {
+		t.Fatalf("expected storage span, found : %v", spans)
+	}
+
+	if put.Parent().SpanID() != request.SpanContext().SpanID() || put.SpanContext().TraceID() != request.SpanContext().TraceID() {
+		t.Fatalf("expected: %s, found : %s", request.SpanContext().SpanID(), put.Parent().SpanID())
+	}
+}
+
+func TestServer_BinaryValues(t *testing.T) {
+	s := boostrap(t)
+
+	binary := []byte{'a', 0x00, 0xff, 'b', 0xfe, 0x80}
+
+	req, err := http.NewRequest("PUT", "/keys/a key", bytes.NewReader(binary))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	if !bytes.Equal(rr.Body.Bytes(), binary) {
+		t.Fatalf("expected: %v, found : %v", binary, rr.Body.Bytes())
+	}
+
+	req, err = http.NewRequest("GET", "/keys?filter=*&encoding=base64", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	var listing []map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(listing) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(listing))
+	}
+
+	value, err := base64.StdEncoding.DecodeString(listing[0]["a key"])
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !bytes.Equal(value, binary) {
+		t.Fatalf("expected: %v, found : %v", binary, value)
+	}
+}
+
+func TestServer_SuccessStatus(t *testing.T) {
+	s := boostrap(t)
+
+	for _, tc := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusNoContent, ""},
+		{http.StatusOK, `{"ok":true}`},
+	} {
+		SuccessStatus(tc.status)(s)
+
+		req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+		assertBody(rr, tc.body, t)
+
+		req, err = http.NewRequest("DELETE", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+		assertBody(rr, tc.body, t)
+
+		req, err = http.NewRequest("DELETE", "/keys/a key", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNotFound, t)
+	}
+}
+
+func TestServer_IfVersionMatch(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Version-Match", "0")
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+	if version := rr.Header().Get("X-Version"); version != "1" {
+		t.Fatalf("expected: %s, found : %s", "1", version)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a key", strings.NewReader("another value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Version-Match", "1")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+	if version := rr.Header().Get("X-Version"); version != "2" {
+		t.Fatalf("expected: %s, found : %s", "2", version)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a key", strings.NewReader("a stale value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Version-Match", "1")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusPreconditionFailed, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "another value", t)
+	if version := rr.Header().Get("X-Version"); version != "2" {
+		t.Fatalf("expected: %s, found : %s", "2", version)
+	}
+
+	req, err = http.NewRequest("DELETE", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Version-Match", "1")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusPreconditionFailed, t)
+
+	req.Header.Set("If-Version-Match", "not a version")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req.Header.Set("If-Version-Match", "2")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+}
+
+func TestServer_Increment(t *testing.T) {
+	s := boostrap(t)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/keys/a counter/increment", http.StatusOK, "1"},
+		{"/keys/a counter/increment?by=5", http.StatusOK, "6"},
+		{"/keys/a counter/decrement?by=2", http.StatusOK, "4"},
+		{"/keys/a counter/increment?by=-10", http.StatusOK, "-6"},
+		{"/keys/a counter/increment?by=many", http.StatusBadRequest, "by must be an integer\n"},
+		{"/keys/a key/increment", http.StatusConflict, "entry is not an integer\n"},
+	}
+
+	req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", test.path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, test.status, t)
+		assertBody(rr, test.body, t)
+	}
+
+	req, err = http.NewRequest("GET", "/keys/a counter", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "-6", t)
+}
+
+func TestServer_IncrementIdempotency(t *testing.T) {
+	s := boostrap(t)
+	IdempotencyTTL(time.Hour)(s)
+
+	tests := []struct {
+		path           string
+		idempotencyKey string
+		status         int
+		body           string
+		replayed       string
+	}{
+		{"/keys/a counter/increment?by=5", "a retry", http.StatusOK, "5", ""},
+		{"/keys/a counter/increment?by=5", "a retry", http.StatusOK, "5", "true"},
+		{"/keys/a counter/increment?by=6", "a retry", http.StatusUnprocessableEntity, "Idempotency-Key already used for a different request\n", ""},
+		{"/keys/another counter/increment?by=5", "a retry", http.StatusUnprocessableEntity, "Idempotency-Key already used for a different request\n", ""},
+		{"/keys/a counter/increment?by=5", "another retry", http.StatusOK, "10", ""},
+		{"/keys/a counter/increment?by=5", "", http.StatusOK, "15", ""},
+		{"/keys/a counter/increment?by=5", "", http.StatusOK, "20", ""},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", test.path, nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if len(test.idempotencyKey) > 0 {
+			req.Header.Set("Idempotency-Key", test.idempotencyKey)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, test.status, t)
+		assertBody(rr, test.body, t)
+
+		if replayed := rr.Header().Get("Idempotent-Replayed"); replayed != test.replayed {
+			t.Fatalf("expected: %s, found : %s", test.replayed, replayed)
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/keys/a counter", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "20", t)
+}
+
+func TestServer_IncrementIdempotencyConcurrent(t *testing.T) {
+	s := boostrap(t)
+	IdempotencyTTL(time.Hour)(s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("POST", "/keys/a counter/increment", nil)
+			req.Header.Set("Idempotency-Key", "a retry")
+
+			rr := executeRequest(req, s)
+
+			assertStatus(rr, http.StatusOK, t)
+			assertBody(rr, "1", t)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestServer_IfMatch(t *testing.T) {
+	s := boostrap(t)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Match", "*")
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusPreconditionFailed, t)
+
+	req, err = http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNoContent, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	etag := rr.Header().Get("ETag")
+
+	// two writers holding the same ETag, only one of them swaps the value
+	statuses := make(chan int, 2)
+
+	var wg sync.WaitGroup
+	for _, value := range []string{"a value of one", "a value of another"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+
+			req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader(value))
+			if err != nil {
+				t.Errorf("err not expected: %s", err)
+				return
+			}
+
+			req.Header.Set("If-Match", etag)
+
+			statuses <- executeRequest(req, s).Code
+		}(value)
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	found := map[int]int{}
+	for status := range statuses {
+		found[status]++
+	}
+
+	expected := map[int]int{http.StatusNoContent: 1, http.StatusPreconditionFailed: 1}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, found)
+	}
+
+	req, err = http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	req.Header.Set("If-Match", "W/"+etag)
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusPreconditionFailed, t)
+
+	req.Header.Set("If-Version-Match", "1")
+	req.Header.Set("If-Match", "*")
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+}
+
+func TestServer_MGet(t *testing.T) {
+	s := boostrap(t)
+
+	for key, value := range map[string]string{
+		"a key":       "a \"quoted\" value\n",
+		"another key": "<b>another value</b>",
+	} {
+		req, err := http.NewRequest("PUT", "/keys/"+url.PathEscape(key), strings.NewReader(value))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err := http.NewRequest("POST", "/keys/mget", strings.NewReader(`["another key", "missing key", "a key"]`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, `{"a key":"a \"quoted\" value\n","another key":"\u003cb\u003eanother value\u003c/b\u003e"}`, t)
+
+	var values map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &values); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if values["a key"] != "a \"quoted\" value\n" {
+		t.Fatalf("expected: %s, found : %s", "a \"quoted\" value\n", values["a key"])
+	}
+}
+
+func TestServer_MGetFramed(t *testing.T) {
+	s := boostrap(t)
+
+	values := map[string]string{
+		"a key":       "a value",
+		"binary key":  string([]byte{0x00, 0xff, '\n', 0x80}),
+		"empty value": "",
+	}
+
+	for key, value := range values {
+		req, err := http.NewRequest("PUT", "/keys/"+url.PathEscape(key), strings.NewReader(value))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, http.StatusNoContent, t)
+	}
+
+	req, err := http.NewRequest("POST", "/keys/mget?format=framed", strings.NewReader(`["a key", "missing key", "binary key", "empty value"]`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+
+	found := map[string]string{}
+	stream := rr.Body.Bytes()
+	for len(stream) > 0 {
+		var frame [2][]byte
+		for i := range frame {
+			if len(stream) < 4 {
+				t.Fatalf("truncated frame: %v", stream)
+			}
+
+			length := int(binary.BigEndian.Uint32(stream))
+			stream = stream[4:]
+			if len(stream) < length {
+				t.Fatalf("truncated frame: %v", stream)
+			}
+
+			frame[i], stream = stream[:length], stream[length:]
+		}
+
+		found[string(frame[0])] = string(frame[1])
+	}
+
+	if !reflect.DeepEqual(found, values) {
+		t.Fatalf("expected: %v, found : %v", values, found)
+	}
+
+	req, err = http.NewRequest("POST", "/keys/mget?format=xml", strings.NewReader(`["a key"]`))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+}
+
+func TestServer_RelaxedScans(t *testing.T) {
+	s := boostrap(t)
+
+	strg, err := storage.NewFileSystemStorage(os.TempDir()+"/"+"keyvaluestorage", storage.RelaxedScans())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(strg)(s)
+
+	for i := 0; i < 50; i++ {
+		if err := strg.Put(fmt.Sprintf("key %02d", i), "a value", -1); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	// writes interleaved with the scans until one of them overlaps a scan
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if err := strg.Put(fmt.Sprintf("key %02d", i%50), fmt.Sprintf("value %d", i), -1); err != nil {
+				t.Errorf("err not expected: %s", err)
+				return
+			}
+		}
+	}()
+
+	listing := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/keys?filter=key*", nil)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		return executeRequest(req, s)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr := listing()
+		assertStatus(rr, http.StatusOK, t)
+
+		if rr.Header().Get("X-Consistent") == "false" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			close(stop)
+			wg.Wait()
+			t.Fatalf("expected: %s, found : %s", "X-Consistent: false", "consistent listings only")
+		}
+	}
+
+	StrictScans()(s)
+
+	for {
+		if rr := listing(); rr.Code == http.StatusConflict {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			close(stop)
+			wg.Wait()
+			t.Fatalf("expected: %d, found : %s", http.StatusConflict, "consistent listings only")
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	rr := listing()
+	assertStatus(rr, http.StatusOK, t)
+
+	if consistent := rr.Header().Get("X-Consistent"); consistent != "" {
+		t.Fatalf("expected: %s, found : %s", "", consistent)
+	}
+}
+
+func TestServer_AppendOnly(t *testing.T) {
+	s := boostrap(t)
+
+	strg, err := storage.NewStorage("memory", os.TempDir()+"/"+"keyvaluestorage", storage.AppendOnly())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	UseStorage(strg)(s)
+
+	for _, tc := range []struct {
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"PUT", "/keys/a key", "a value", http.StatusNoContent},
+		{"PUT", "/keys/a key", "another value", http.StatusConflict},
+		{"DELETE", "/keys/a key", "", http.StatusForbidden},
+		{"DELETE", "/keys", "", http.StatusForbidden},
+		{"PUT", "/keys/expire?filter=*&expire_in=1", "", http.StatusForbidden},
+		{"PUT", "/keys/another key", "another value", http.StatusNoContent},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+	}
+
+	req, err := http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+}
+
+func TestServer_PutExpireAt(t *testing.T) {
+	s := boostrap(t)
+
+	for _, tc := range []struct {
+		expireAt string
+		status   int
+	}{
+		{strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10), http.StatusBadRequest},
+		{time.Now().Add(-time.Minute).Format(time.RFC3339), http.StatusBadRequest},
+		{"tomorrow", http.StatusBadRequest},
+		{time.Now().Add(time.Hour).Format(time.RFC3339), http.StatusNoContent},
+		{strconv.FormatInt(time.Now().Add(2*time.Second).Unix(), 10), http.StatusNoContent},
+	} {
+		req, err := http.NewRequest("PUT", "/keys/a key?expire_at="+url.QueryEscape(tc.expireAt), strings.NewReader("a value"))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr := executeRequest(req, s)
+
+		assertStatus(rr, tc.status, t)
+	}
+
+	req, err := http.NewRequest("PUT", "/keys/a key?expire_in=10&expire_at="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusBadRequest, t)
+
+	req, err = http.NewRequest("GET", "/keys/a key", nil)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusOK, t)
+	assertBody(rr, "a value", t)
+
+	time.Sleep(3 * time.Second)
+
+	rr = executeRequest(req, s)
+
+	assertStatus(rr, http.StatusNotFound, t)
+}
+
+func TestServer_CircuitBreakerOpen(t *testing.T) {
+	s := boostrap(t)
+
+	UseStorage(storage.NewBreakerStorage(faultStorage{
+		Storage: s.getStorage(),
+		faults:  map[string]error{"get": errors.New("a fault"), "put": errors.New("a fault")},
+	}, 1, time.Minute))(s)
+
+	req, err := http.NewRequest("PUT", "/keys/a key", strings.NewReader("a value"))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	rr := executeRequest(req, s)
+
+	assertStatus(rr, http.StatusInternalServerError, t)
+
+	for _, method := range []string{"PUT", "GET"} {
+		req, err = http.NewRequest(method, "/keys/a key", strings.NewReader("a value"))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		rr = executeRequest(req, s)
+
+		assertStatus(rr, http.StatusServiceUnavailable, t)
+	}
+}
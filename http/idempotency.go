@@ -0,0 +1,105 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader Header naming a retried request, answered with the result of the first one
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader Header set on the answer of a retried request, replaying the first one
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// idempotentResult The result of the request first sent with an idempotency key, done is closed once it is set
+type idempotentResult struct {
+	request string
+	counter int64
+	ok      bool
+	expires time.Time
+	done    chan struct{}
+}
+
+// idempotencyCache Results of the requests sent with an idempotency key, retained for ttl
+type idempotencyCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	nextSweep time.Time
+	results   map[string]*idempotentResult
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		results: make(map[string]*idempotentResult),
+	}
+}
+
+// idempotencyCache.do Runs fn once for the idempotency key, returning the counter of its first successful run
+// to the retries of the same request; replayed is true for the retries, conflict when the key was sent
+// along a different request
+func (c *idempotencyCache) do(key string, request string, fn func() (int64, error)) (counter int64, replayed bool, conflict bool, err error) {
+	var result *idempotentResult
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.sweep(now)
+
+		var found bool
+		result, found = c.results[key]
+		if found && result.ok && now.After(result.expires) {
+			delete(c.results, key)
+			found = false
+		}
+
+		if !found {
+			result = &idempotentResult{request: request, done: make(chan struct{})}
+			c.results[key] = result
+			c.mu.Unlock()
+			break
+		}
+
+		c.mu.Unlock()
+
+		if result.request != request {
+			return 0, false, true, nil
+		}
+
+		// a retry waits for the request in flight, running on its own if that one failed
+		<-result.done
+		if result.ok {
+			return result.counter, true, false, nil
+		}
+	}
+
+	counter, err = fn()
+
+	c.mu.Lock()
+	if err == nil {
+		result.counter = counter
+		result.ok = true
+		result.expires = time.Now().Add(c.ttl)
+	} else {
+		delete(c.results, key)
+	}
+	c.mu.Unlock()
+
+	close(result.done)
+
+	return counter, false, false, err
+}
+
+// idempotencyCache.sweep Drops the expired results, at most once per ttl
+func (c *idempotencyCache) sweep(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+
+	for key, result := range c.results {
+		if result.ok && now.After(result.expires) {
+			delete(c.results, key)
+		}
+	}
+
+	c.nextSweep = now.Add(c.ttl)
+}
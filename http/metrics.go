@@ -0,0 +1,99 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectors of the operations run by /keys and /blobs requests, labeled by the storage type
+var (
+	operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyvaluestorage_operations_total",
+		Help: "Operations run, by operation (get, put or delete) and storage type.",
+	}, []string{"operation", "storage"})
+
+	hitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyvaluestorage_hits_total",
+		Help: "Get operations finding what was asked, by storage type.",
+	}, []string{"storage"})
+
+	missesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyvaluestorage_misses_total",
+		Help: "Get operations not finding what was asked, by storage type.",
+	}, []string{"storage"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keyvaluestorage_operation_duration_seconds",
+		Help:    "Latency of the operations, by operation (get, put or delete) and storage type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "storage"})
+
+	registerMetrics sync.Once
+)
+
+// Metrics Record the operations of every /keys and /blobs request in collectors of the default
+// Prometheus registry, exposed at /metrics
+func Metrics() OptionFn {
+	return func(srvr *Server) {
+		registerMetrics.Do(func() {
+			prometheus.MustRegister(operationsTotal, hitsTotal, missesTotal, operationDuration)
+		})
+
+		srvr.metrics = true
+	}
+
+}
+
+// operation Returns the operation a request runs: get, put or delete
+func operation(req *http.Request) string {
+	switch req.Method {
+	case http.MethodDelete:
+		return "delete"
+	case http.MethodGet, http.MethodHead:
+		return "get"
+	}
+
+	// the reads sent with POST not to be bound to the length of the URL
+	if template, err := mux.CurrentRoute(req).GetPathTemplate(); err == nil && (template == "/keys/mexists" || template == "/keys/mget") {
+		return "get"
+	}
+
+	return "put"
+}
+
+// measureRequest Runs h recording the operation of requests to /keys and /blobs, its latency and,
+// for gets, whether it hit or missed
+func (s *Server) measureRequest(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// trailing slashes are served by the route without it, measured on its own
+		if !strings.HasPrefix(req.URL.Path, "/keys") && !strings.HasPrefix(req.URL.Path, "/blobs") || strings.HasSuffix(req.URL.Path, "/") {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		op := operation(req)
+		storageType := s.getStorage().Type()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, req)
+
+		operationDuration.WithLabelValues(op, storageType).Observe(time.Since(start).Seconds())
+		operationsTotal.WithLabelValues(op, storageType).Inc()
+
+		if op != "get" {
+			return
+		}
+
+		if sw.status == http.StatusNotFound {
+			missesTotal.WithLabelValues(storageType).Inc()
+		} else if sw.status < http.StatusBadRequest {
+			hitsTotal.WithLabelValues(storageType).Inc()
+		}
+	})
+}
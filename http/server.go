@@ -1,13 +1,24 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/PuerkitoBio/ghost/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/aspacca/keyvaluestorage/storage"
 	"github.com/sirupsen/logrus"
@@ -16,6 +27,15 @@ import (
 // parse request with maximum memory of _24Kilobits
 const _24K = (1 << 10) * 24
 
+// number of mutations retained for GET /keys/changes unless set with ChangeLogSize
+const defaultChangeLogSize = 1000
+
+// number of recent calls per operation GET /admin/storage-health reports on
+const storageHealthWindow = 1000
+
+// size of the chunks blobs are split in unless set with BlobChunkSize
+const defaultBlobChunkSize = 1 << 20
+
 // OptionFn Functional option type
 type OptionFn func(*Server)
 
@@ -27,19 +47,338 @@ func Listener(s string) OptionFn {
 
 }
 
+// TLSCert Set the PEM certificate file served over HTTPS along TLSKey, plain HTTP being served without them
+func TLSCert(path string) OptionFn {
+	return func(srvr *Server) {
+		srvr.tlsCert = path
+	}
+
+}
+
+// TLSKey Set the PEM private key file of the certificate set with TLSCert
+func TLSKey(path string) OptionFn {
+	return func(srvr *Server) {
+		srvr.tlsKey = path
+	}
+
+}
+
+// TLSRedirect Set a bind address answering plain HTTP requests with a 308 to their HTTPS URL, requires TLSCert
+func TLSRedirect(s string) OptionFn {
+	return func(srvr *Server) {
+		srvr.tlsRedirect = s
+	}
+
+}
+
 // UseStorage Set storage type
 func UseStorage(s storage.Storage) OptionFn {
 	return func(srvr *Server) {
-		srvr.storage = s
+		srvr.storage.Store(storageHolder{s})
+	}
+
+}
+
+// BasicAuth Require the credentials of username and password by basic auth on the /keys and /blobs endpoints,
+// /health, /capabilities and the /admin endpoints, behind AdminToken, being left out
+func BasicAuth(username string, password string) OptionFn {
+	return func(srvr *Server) {
+		srvr.authUser = username
+		srvr.authPass = password
+	}
+
+}
+
+// AdminToken Set bearer token required by /admin endpoints
+func AdminToken(token string) OptionFn {
+	return func(srvr *Server) {
+		srvr.adminToken = token
+	}
+
+}
+
+// MaxListKeys Set maximum number of entries returned by a listing,
+// exceeding it fails with 413 unless truncate is set
+func MaxListKeys(max int, truncate bool) OptionFn {
+	return func(srvr *Server) {
+		srvr.maxListKeys = max
+		srvr.truncateList = truncate
+	}
+
+}
+
+// StrictScans Fail listings a concurrent write may have torn with 409, instead of answering them with `X-Consistent: false`
+func StrictScans() OptionFn {
+	return func(srvr *Server) {
+		srvr.strictScans = true
+	}
+
+}
+
+// DisableList Reject listings of the collection with 403, single keys are still served
+func DisableList() OptionFn {
+	return func(srvr *Server) {
+		srvr.disableList = true
+	}
+
+}
+
+// HashListedKeys List keys as the hex sha256 of them, single keys are still got by their name
+func HashListedKeys() OptionFn {
+	return func(srvr *Server) {
+		srvr.hashListedKeys = true
+	}
+
+}
+
+// MaxResponseBytes Set maximum size of a listing response,
+// exceeding it fails with 413 unless truncation is set with MaxListKeys
+func MaxResponseBytes(max int) OptionFn {
+	return func(srvr *Server) {
+		srvr.maxResponseBytes = max
+	}
+
+}
+
+// GzipListings Gzip listings of at least threshold bytes for clients accepting it, answering them with
+// `Vary: Accept-Encoding`, 0 to never compress them
+func GzipListings(threshold int) OptionFn {
+	return func(srvr *Server) {
+		srvr.gzipListings = threshold
+	}
+
+}
+
+// MaxBufferedValue Set maximum size of a value buffered in memory to transform it (template, base64, YAML or pretty),
+// exceeding it fails with 413, values served as is being always streamed
+func MaxBufferedValue(max int) OptionFn {
+	return func(srvr *Server) {
+		srvr.maxBufferedValue = max
+	}
+
+}
+
+// MaxBatchSize Set maximum number of keys of a batch operation (mget, mexists, bulk put, form put), more are rejected with 400
+func MaxBatchSize(max int) OptionFn {
+	return func(srvr *Server) {
+		srvr.maxBatchSize = max
+	}
+
+}
+
+// RecordLastWriter Record the actor of every PUT of a key, answered as X-Last-Writer by GET and HEAD
+func RecordLastWriter() OptionFn {
+	return func(srvr *Server) {
+		srvr.recordLastWriter = true
+	}
+
+}
+
+// SignedURLRedirect Answer plain single-key GETs with a 302 to a URL signed for expiry by storages able to, see storage.URLSigner;
+// no bundled provider signs URLs, it applies to the storages given by UseStorage
+func SignedURLRedirect(expiry time.Duration) OptionFn {
+	return func(srvr *Server) {
+		srvr.signedURLExpiry = expiry
+	}
+
+}
+
+// ServeStale Serve keys the storage still holds during its expiry grace period, with a Warning header
+func ServeStale() OptionFn {
+	return func(srvr *Server) {
+		srvr.serveStale = true
+	}
+
+}
+
+// BlobChunkSize Set size of the chunks a value PUT to /blobs/{id} is split in
+func BlobChunkSize(size int) OptionFn {
+	return func(srvr *Server) {
+		srvr.blobChunkSize = size
+	}
+
+}
+
+// RequestTimeout Set deadline of every request, the proxied instance being canceled along it
+func RequestTimeout(timeout time.Duration) OptionFn {
+	return func(srvr *Server) {
+		srvr.requestTimeout = timeout
+	}
+
+}
+
+// AuditLog Set writer receiving an audit record for every mutation
+func AuditLog(w io.Writer) OptionFn {
+	return func(srvr *Server) {
+		srvr.auditLog = w
+	}
+
+}
+
+// StorageOptions Set options for storage providers created at runtime
+func StorageOptions(options ...storage.OptionFn) OptionFn {
+	return func(srvr *Server) {
+		srvr.storageOptions = options
+	}
+
+}
+
+// EnablePprof Mount net/http/pprof handlers under /debug/pprof, gated as /admin endpoints
+func EnablePprof() OptionFn {
+	return func(srvr *Server) {
+		srvr.enablePprof = true
+	}
+
+}
+
+// RedirectTrailingSlash Answer key paths with a trailing slash with 308 instead of stripping it
+func RedirectTrailingSlash() OptionFn {
+	return func(srvr *Server) {
+		srvr.redirectTrailingSlash = true
+	}
+
+}
+
+// MinTTL Set minimum expiration accepted on writes, rejecting keys without one
+func MinTTL(ttl time.Duration) OptionFn {
+	return func(srvr *Server) {
+		srvr.minTTL = ttl
+	}
+
+}
+
+// MaxPatternWildcards Set maximum number of `*`, `?` and `[` accepted in a filter
+func MaxPatternWildcards(max int) OptionFn {
+	return func(srvr *Server) {
+		srvr.maxPatternWildcards = max
+	}
+
+}
+
+// IdempotencyTTL Answer increments and decrements retried with the same Idempotency-Key with the counter
+// of the first one, without applying them again, for ttl after it; 0 disables it
+func IdempotencyTTL(ttl time.Duration) OptionFn {
+	return func(srvr *Server) {
+		srvr.idempotency = nil
+		if ttl > 0 {
+			srvr.idempotency = newIdempotencyCache(ttl)
+		}
+	}
+
+}
+
+// ChangeLogSize Set number of mutations retained for GET /keys/changes, 0 disables it
+func ChangeLogSize(size int) OptionFn {
+	return func(srvr *Server) {
+		srvr.changes = nil
+		if size > 0 {
+			srvr.changes = newChangeLog(size)
+		}
+	}
+
+}
+
+// EscapeHTML Set whether `<`, `>` and `&` are escaped in listing responses
+func EscapeHTML(escape bool) OptionFn {
+	return func(srvr *Server) {
+		srvr.escapeHTML = escape
 	}
 
 }
 
+// SlidingTTL Set window single-key GETs with touch_on_get push the expiration of keys to,
+// rewriting it only when less than threshold is left, and whether every GET touches
+func SlidingTTL(window time.Duration, threshold time.Duration, always bool) OptionFn {
+	return func(srvr *Server) {
+		srvr.slidingTTL = window
+		srvr.slidingTTLThreshold = threshold
+		srvr.touchOnGet = always
+	}
+
+}
+
+// SuccessStatus Set status of successful writes and deletes: http.StatusNoContent (default),
+// or http.StatusOK with a `{"ok":true}` body for clients not handling 204
+func SuccessStatus(status int) OptionFn {
+	return func(srvr *Server) {
+		srvr.successStatus = status
+	}
+
+}
+
+// storageHolder keeps atomic.Value consistently typed across providers
+type storageHolder struct {
+	storage.Storage
+}
+
 // Server HTTP Server struct
 type Server struct {
-	logger  *logrus.Logger
-	router  *mux.Router
-	storage storage.Storage
+	logger     *logrus.Logger
+	router     *mux.Router
+	storage    atomic.Value
+	inFlight   sync.RWMutex
+	adminToken string
+
+	authUser string
+	authPass string
+
+	maxListKeys    int
+	truncateList   bool
+	strictScans    bool
+	disableList    bool
+	hashListedKeys bool
+
+	maxResponseBytes int
+
+	maxBufferedValue int
+
+	maxBatchSize int
+
+	requestTimeout time.Duration
+
+	auditLog io.Writer
+
+	recordLastWriter bool
+
+	storageOptions []storage.OptionFn
+
+	enablePprof bool
+
+	redirectTrailingSlash bool
+
+	minTTL time.Duration
+
+	maxPatternWildcards int
+
+	changes *changeLog
+
+	idempotency *idempotencyCache
+
+	escapeHTML bool
+
+	gzipListings int
+
+	slidingTTL          time.Duration
+	slidingTTLThreshold time.Duration
+	touchOnGet          bool
+
+	successStatus int
+
+	blobChunkSize int
+
+	serveStale bool
+
+	signedURLExpiry time.Duration
+
+	storageStats *storage.StorageStats
+
+	tracer trace.Tracer
+
+	metrics bool
+
+	tlsCert     string
+	tlsKey      string
+	tlsRedirect string
 
 	ListenerString string
 }
@@ -50,36 +389,212 @@ func New(options ...OptionFn) (*Server, error) {
 	logger.Out = os.Stdout
 
 	s := &Server{
-		logger: logger,
+		logger:        logger,
+		changes:       newChangeLog(defaultChangeLogSize),
+		escapeHTML:    true,
+		successStatus: http.StatusNoContent,
+		storageStats:  storage.NewStorageStats(storageHealthWindow),
+		blobChunkSize: defaultBlobChunkSize,
 	}
 
 	for _, optionFn := range options {
 		optionFn(s)
 	}
 
+	if err := s.checkTLS(); err != nil {
+		return nil, err
+	}
+
+	if (len(s.authUser) == 0) != (len(s.authPass) == 0) {
+		return nil, fmt.Errorf("basic auth requires both a user and a password")
+	}
+
 	return s, nil
 }
 
+// checkTLS Returns error unless the TLS certificate and key are both set and load, or neither is
+func (s *Server) checkTLS() error {
+	if len(s.tlsCert) == 0 && len(s.tlsKey) == 0 {
+		if len(s.tlsRedirect) > 0 {
+			return fmt.Errorf("redirecting to HTTPS requires a TLS certificate and key")
+		}
+
+		return nil
+	}
+
+	if len(s.tlsCert) == 0 || len(s.tlsKey) == 0 {
+		return fmt.Errorf("TLS requires both a certificate and a key")
+	}
+
+	if _, err := tls.LoadX509KeyPair(s.tlsCert, s.tlsKey); err != nil {
+		return fmt.Errorf("TLS certificate and key do not load: %s", err)
+	}
+
+	return nil
+}
+
+// redirectToTLS Answers a plain HTTP request with a 308, keeping its method, to its URL over HTTPS on the port of the listener
+func (s *Server) redirectToTLS(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if _, port, err := net.SplitHostPort(s.ListenerString); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+func (s *Server) getStorage() storage.Storage {
+	return s.storage.Load().(storageHolder).Storage
+}
+
+// requestStorage Returns the active storage decorated for the request
+func (s *Server) requestStorage(req *http.Request) storage.Storage {
+	// a client giving up or the request deadline aborts operations against a remote storage
+	strg := storage.WithContext(req.Context(), s.getStorage())
+	if s.tracer != nil {
+		strg = storage.NewTracedStatsStorage(strg, s.storageStats, s.storageTracer(req.Context()))
+	} else {
+		strg = storage.NewStatsStorage(strg, s.storageStats)
+	}
+
+	if s.recordLastWriter {
+		strg = storage.NewLastWriterStorage(strg, actor(req))
+	}
+
+	var writers []io.Writer
+	if s.auditLog != nil {
+		writers = append(writers, s.auditLog)
+	}
+
+	if s.changes != nil {
+		writers = append(writers, s.changes)
+	}
+
+	if len(writers) > 0 {
+		strg = storage.NewAuditStorage(strg, io.MultiWriter(writers...), actor(req))
+	}
+
+	return strg
+}
+
+// actor Returns the authenticated identity of the request
+func actor(req *http.Request) string {
+	if user, _, ok := req.BasicAuth(); ok {
+		return user
+	}
+
+	return "anonymous"
+}
+
+// swapStorage waits for in-flight requests to drain, then replaces the active storage returning the previous one
+// and discarding the stats of the previous one
+func (s *Server) swapStorage(strg storage.Storage) storage.Storage {
+	s.inFlight.Lock()
+	defer s.inFlight.Unlock()
+
+	old := s.getStorage()
+	s.storage.Store(storageHolder{strg})
+	s.storageStats.Reset()
+
+	return old
+}
+
+// drain tracks a request as in-flight so that storage swaps wait for it
+func (s *Server) drain(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s.inFlight.RLock()
+		defer s.inFlight.RUnlock()
+
+		if s.requestTimeout > 0 {
+			ctx, cancel := context.WithTimeout(req.Context(), s.requestTimeout)
+			defer cancel()
+
+			req = req.WithContext(ctx)
+		}
+
+		h(w, req)
+	}
+}
+
 func (s *Server) setupRouter() {
 	s.router = mux.NewRouter()
 
-	s.router.HandleFunc("/health", healthHandler).Methods("GET")
+	s.router.HandleFunc("/health", s.healthHandler).Methods("GET")
+	s.router.HandleFunc("/capabilities", s.capabilitiesHandler).Methods("GET")
+
+	// fixed routes under /keys match only along their query, or with a method no key route has,
+	// not to shadow keys named as them
+	s.router.Path("/keys/count").Queries("filter", "{filter}").HandlerFunc(s.drain(s.countHandler)).Methods("GET")
+	if s.changes != nil {
+		s.router.Path("/keys/changes").Queries("since", "{since}").HandlerFunc(s.changesHandler).Methods("GET")
+	}
+
+	s.router.HandleFunc("/keys/{id}", s.drain(s.getHandler)).Methods("GET")
+	s.router.HandleFunc("/keys", s.drain(s.getHandler)).Methods("GET")
+	s.router.Path("/keys").Queries("filter", "{filter=.*}").HandlerFunc(s.drain(s.getHandler)).Methods("GET")
+	s.router.Path("/keys/expire").Queries("filter", "{filter}", "expire_in", "{expire_in=[0-9]+}").HandlerFunc(s.drain(s.expireHandler)).Methods("PUT")
+	s.router.HandleFunc("/keys/{id}", s.drain(s.putHandler)).Methods("PUT")
+	s.router.Path("/keys/{id}").Queries("expire_in", "{expire_in=[0-9]+}").HandlerFunc(s.drain(s.putHandler)).Methods("PUT")
+	s.router.HandleFunc("/keys", s.drain(s.formPutHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/mexists", s.drain(s.existsManyHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/bulk", s.drain(s.bulkPutHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/mget", s.drain(s.mgetHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/{id}/push", s.drain(s.pushHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/{id}/pop", s.drain(s.popHandler)).Methods("POST")
+	s.router.HandleFunc("/keys/{id}/increment", s.drain(s.counterHandler(false))).Methods("POST")
+	s.router.HandleFunc("/keys/{id}/decrement", s.drain(s.counterHandler(true))).Methods("POST")
+	s.router.HandleFunc("/keys/{id}/equals", s.drain(s.equalsHandler)).Methods("GET")
+	s.router.HandleFunc("/keys/{id}", s.drain(s.headHandler)).Methods("HEAD")
+	s.router.HandleFunc("/keys/{id}", s.drain(s.deleteHandler)).Methods("DELETE")
+	s.router.HandleFunc("/blobs/{id}", s.drain(s.blobPutHandler)).Methods("PUT")
+	s.router.HandleFunc("/blobs/{id}", s.drain(s.blobGetHandler)).Methods("GET")
+	s.router.HandleFunc("/blobs/{id}", s.drain(s.blobDeleteHandler)).Methods("DELETE")
+	s.router.HandleFunc("/keys", s.drain(s.deleteHandler)).Methods("DELETE")
+
+	// the listing only, a trailing slash must not turn into deleting every key
+	s.router.Path("/keys/").HandlerFunc(s.trailingSlashHandler).Methods("GET", "HEAD")
+	s.router.Path("/keys/{id}/").HandlerFunc(s.trailingSlashHandler)
 
-	s.router.HandleFunc("/keys/{id}", s.getHandler).Methods("GET")
-	s.router.HandleFunc("/keys", s.getHandler).Methods("GET")
-	s.router.Path("/keys").Queries("filter", "{filter=.*}").HandlerFunc(s.getHandler).Methods("GET")
-	s.router.HandleFunc("/keys/{id}", s.putHandler).Methods("PUT")
-	s.router.Path("/keys/{id}").Queries("expire_in", "{expire_in=[0-9]+}").HandlerFunc(s.putHandler).Methods("PUT")
-	s.router.HandleFunc("/keys/{id}", s.headHandler).Methods("HEAD")
-	s.router.HandleFunc("/keys/{id}", s.deleteHandler).Methods("DELETE")
-	s.router.HandleFunc("/keys", s.deleteHandler).Methods("DELETE")
+	s.router.HandleFunc("/admin/provider", s.admin(s.providerHandler)).Methods("POST")
+	s.router.HandleFunc("/admin/locks", s.admin(s.locksHandler)).Methods("GET")
+	s.router.HandleFunc("/admin/storage-health", s.admin(s.storageHealthHandler)).Methods("GET")
+	s.router.HandleFunc("/admin/purge-expired", s.admin(s.drain(s.purgeExpiredHandler))).Methods("POST")
+	s.router.HandleFunc("/admin/init", s.admin(s.drain(s.initHandler))).Methods("POST")
+
+	if s.enablePprof {
+		s.router.HandleFunc("/debug/pprof/cmdline", s.admin(pprof.Cmdline))
+		s.router.HandleFunc("/debug/pprof/profile", s.admin(pprof.Profile))
+		s.router.HandleFunc("/debug/pprof/symbol", s.admin(pprof.Symbol))
+		s.router.HandleFunc("/debug/pprof/trace", s.admin(pprof.Trace))
+		s.router.PathPrefix("/debug/pprof/").HandlerFunc(s.admin(pprof.Index))
+	}
+
+	if s.metrics {
+		s.router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	}
 
 	s.router.NotFoundHandler = http.HandlerFunc(s.notFoundHandler)
+
+	if s.tracer != nil {
+		s.router.Use(s.traceRequest)
+	}
+
+	if len(s.authUser) > 0 {
+		s.router.Use(s.basicAuth)
+	}
+
+	if s.metrics {
+		s.router.Use(s.measureRequest)
+	}
 }
 
 // Run Start the server
 func (s *Server) Run() {
-	s.logger.Infof("starting Key Value Storage HTTP Backend using storage provider: %s", s.storage.Type())
+	s.logger.Infof("starting Key Value Storage HTTP Backend using storage provider: %s", s.getStorage().Type())
 
 	s.setupRouter()
 
@@ -88,17 +603,39 @@ func (s *Server) Run() {
 			Addr:    s.ListenerString,
 			Handler: handlers.PanicHandler(s.router, nil),
 		}
+
+		if len(s.tlsCert) > 0 {
+			listener.ListenAndServeTLS(s.tlsCert, s.tlsKey)
+			return
+		}
+
 		listener.ListenAndServe()
 	}()
 
-	s.logger.Infof("listening on port: %v\n", s.ListenerString)
+	if len(s.tlsRedirect) > 0 {
+		go func() {
+			redirect := &http.Server{
+				Addr:    s.tlsRedirect,
+				Handler: http.HandlerFunc(s.redirectToTLS),
+			}
+			redirect.ListenAndServe()
+		}()
+
+		s.logger.Infof("redirecting to HTTPS on port: %v\n", s.tlsRedirect)
+	}
+
+	if len(s.tlsCert) > 0 {
+		s.logger.Infof("listening over HTTPS on port: %v\n", s.ListenerString)
+	} else {
+		s.logger.Infof("listening on port: %v\n", s.ListenerString)
+	}
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt)
 	signal.Notify(term, syscall.SIGTERM)
 
 	<-term
 
-	s.storage.Flush()
+	s.getStorage().Flush()
 
 	s.logger.Info("server stopped.")
 }
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/aspacca/keyvaluestorage/storage"
+)
+
+// name of the tracer spans of requests and storage operations are started with
+const tracerName = "github.com/aspacca/keyvaluestorage"
+
+// Tracing Trace every request, and the storage operations it runs, with spans of provider
+// continuing the trace of the caller carried by the W3C traceparent header
+func Tracing(provider trace.TracerProvider) OptionFn {
+	return func(srvr *Server) {
+		srvr.tracer = provider.Tracer(tracerName)
+	}
+
+}
+
+// statusWriter records the status a response is written with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// traceRequest Runs h within a span of the request, child of the one of the caller if any
+func (s *Server) traceRequest(h http.Handler) http.Handler {
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := req.URL.Path
+		if template, err := mux.CurrentRoute(req).GetPathTemplate(); err == nil {
+			route = template
+		}
+
+		ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := s.tracer.Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, req.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// storageTracer Returns the tracer of the storage operations of a request, as children of its span in ctx
+func (s *Server) storageTracer(ctx context.Context) storage.OperationTracer {
+	return func(operation string) func(error) {
+		_, span := s.tracer.Start(ctx, "storage "+operation,
+			trace.WithSpanKind(trace.SpanKindInternal),
+			trace.WithAttributes(attribute.String("storage.operation", operation)),
+		)
+
+		return func(failure error) {
+			if failure != nil {
+				span.RecordError(failure)
+				span.SetStatus(codes.Error, failure.Error())
+			}
+
+			span.End()
+		}
+	}
+}
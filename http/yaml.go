@@ -0,0 +1,108 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// yamlMediaTypes are the Accept media types single-key GETs answer with YAML
+var yamlMediaTypes = map[string]bool{
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
+// acceptsYAML Returns whether req accepts one of yamlMediaTypes
+func acceptsYAML(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && yamlMediaTypes[mediaType] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonToYAML Returns the JSON value converted to YAML, keeping the order of object members,
+// and whether value was JSON at all
+func jsonToYAML(value []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	converted, err := decodeYAMLValue(decoder)
+	if err != nil {
+		return nil, false
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+
+	out, err := yaml.Marshal(converted)
+	if err != nil {
+		return nil, false
+	}
+
+	return out, true
+}
+
+// decodeYAMLValue Decodes the next JSON value as yaml.MapSlice for objects, []interface{} for arrays and scalars
+func decodeYAMLValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			object := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				member, err := decodeYAMLValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+
+				object = append(object, yaml.MapItem{Key: key, Value: member})
+			}
+
+			_, err = decoder.Token()
+			return object, err
+		case '[':
+			array := []interface{}{}
+			for decoder.More() {
+				element, err := decodeYAMLValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+
+				array = append(array, element)
+			}
+
+			_, err = decoder.Token()
+			return array, err
+		}
+
+		return nil, fmt.Errorf("unexpected delimiter %s", token)
+	case json.Number:
+		if i, err := token.Int64(); err == nil {
+			return i, nil
+		}
+
+		return token.Float64()
+	}
+
+	return token, nil
+}
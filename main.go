@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/aspacca/keyvaluestorage/http"
 	"github.com/aspacca/keyvaluestorage/storage"
 	"github.com/minio/cli"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
 )
 
 var version = "0.1"
@@ -33,6 +46,31 @@ var globalFlags = []cli.Flag{
 		Usage: "0.0.0.0:8080",
 		Value: "0.0.0.0:8080",
 	},
+	cli.StringFlag{
+		Name:  "auth-user",
+		Usage: "user required by basic auth on /keys and /blobs, along auth-pass (disabled when empty)",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "auth-pass",
+		Usage: "password of auth-user",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "path of the PEM certificate served over HTTPS, along tls-key",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "path of the PEM private key of tls-cert",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "tls-redirect",
+		Usage: "address answering plain HTTP with a redirect to HTTPS, e.g. 0.0.0.0:80",
+		Value: "",
+	},
 	cli.StringFlag{
 		Name:  "basedir",
 		Usage: "path to storage",
@@ -40,9 +78,280 @@ var globalFlags = []cli.Flag{
 	},
 	cli.StringFlag{
 		Name:  "provider",
-		Usage: "fs|memory",
+		Usage: "fs|memory|proxy|redis",
+		Value: "",
+	},
+	cli.IntFlag{
+		Name:  "max-pattern-wildcards",
+		Usage: "maximum number of *, ? and [ accepted in a filter, 0 for unlimited",
+		Value: 0,
+	},
+	cli.IntFlag{
+		Name:  "min-ttl",
+		Usage: "minimum expire_in in seconds accepted on writes, keys without expiration are rejected",
+		Value: 0,
+	},
+	cli.DurationFlag{
+		Name:  "idempotency-ttl",
+		Usage: "answer increments and decrements retried with the same Idempotency-Key with the first result, for this long after it, e.g. 24h",
+	},
+	cli.DurationFlag{
+		Name:  "key-index",
+		Usage: "fs provider: index keys in memory for pattern queries, reconciled with basedir at this interval, e.g. 1m",
+	},
+	cli.IntFlag{
+		Name:  "pattern-readers",
+		Usage: "fs provider: files read at once to answer filtered listings",
+		Value: 1,
+	},
+	cli.BoolFlag{
+		Name:  "relaxed-scans",
+		Usage: "fs provider: answer listings without blocking writes meanwhile, with X-Consistent: false when one overlapped the scan",
+	},
+	cli.BoolFlag{
+		Name:  "strict-scans",
+		Usage: "fail listings a concurrent write may have torn with 409 instead of answering X-Consistent: false",
+	},
+	cli.BoolFlag{
+		Name:  "fifo-writes",
+		Usage: "serialize reads and writes of the same key in arrival order",
+	},
+	cli.IntFlag{
+		Name:  "read-cache-size",
+		Usage: "number of values kept in the LRU read cache, 0 to disable",
+		Value: 0,
+	},
+	cli.DurationFlag{
+		Name:  "read-cache-ttl",
+		Usage: "maximum age of a value in the LRU read cache",
+		Value: time.Second,
+	},
+	cli.DurationFlag{
+		Name:  "simulate-latency",
+		Usage: "benchmarking only: delay every storage operation, e.g. 50ms",
+	},
+	cli.DurationFlag{
+		Name:  "simulate-latency-jitter",
+		Usage: "benchmarking only: add a random delay up to this duration",
+	},
+	cli.StringFlag{
+		Name:  "admin-token",
+		Usage: "bearer token enabling /admin endpoints",
+		Value: "",
+	},
+	cli.BoolFlag{
+		Name:  "redirect-trailing-slash",
+		Usage: "answer /keys/{id}/ with 308 to /keys/{id} instead of stripping the slash",
+	},
+	cli.BoolFlag{
+		Name:  "enable-pprof",
+		Usage: "mount pprof handlers under /debug/pprof, requires admin-token",
+	},
+	cli.BoolFlag{
+		Name:  "enable-tracing",
+		Usage: "trace requests and storage operations with OpenTelemetry, exported over OTLP/HTTP as set by the OTEL_EXPORTER_OTLP_* environment variables",
+	},
+	cli.BoolFlag{
+		Name:  "enable-metrics",
+		Usage: "expose Prometheus metrics of the operations of /keys and /blobs, by storage type, at /metrics",
+	},
+	cli.StringFlag{
+		Name:  "audit-log",
+		Usage: "path of the append-only audit log of mutations, - for the logger",
+		Value: "",
+	},
+	cli.IntFlag{
+		Name:  "max-list-keys",
+		Usage: "maximum number of entries returned by a listing, 0 for unlimited",
+		Value: 0,
+	},
+	cli.DurationFlag{
+		Name:  "sliding-ttl",
+		Usage: "window GETs with touch_on_get=true push the expiration of keys to (e.g. 30m), 0 to disable",
+	},
+	cli.DurationFlag{
+		Name:  "sliding-ttl-threshold",
+		Usage: "slide only when less than this is left, limiting fs writes (default: sliding-ttl)",
+	},
+	cli.BoolFlag{
+		Name:  "touch-on-get",
+		Usage: "every single-key GET slides the expiration, requires sliding-ttl",
+	},
+	cli.StringFlag{
+		Name:  "proxy-url",
+		Usage: "proxy provider: base url of the keyvaluestorage instance to forward to",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "read-through-provider",
+		Usage: "fs|memory provider caching the values read from provider, written through",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "read-through-basedir",
+		Usage: "path to the read-through cache storage",
+		Value: "",
+	},
+	cli.DurationFlag{
+		Name:  "read-through-ttl",
+		Usage: "maximum time a value is kept in the read-through cache",
+		Value: time.Minute,
+	},
+	cli.StringFlag{
+		Name:  "redis-addr",
+		Usage: "redis provider: host:port of the redis server to store keys in",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "redis-prefix",
+		Usage: "redis provider: prefix of the keys stored on the redis server, deleting all keys deletes only those",
+		Value: "",
+	},
+	cli.DurationFlag{
+		Name:  "max-age",
+		Usage: "treat entries created longer ago as expired regardless of their TTL (e.g. 720h), 0 to disable",
+	},
+	cli.IntFlag{
+		Name:  "max-maintenance",
+		Usage: "background maintenance tasks (snapshots, index reconciles) of the storage running at once",
+		Value: 1,
+	},
+	cli.DurationFlag{
+		Name:  "eviction-interval",
+		Usage: "memory and fs providers: delete expired entries in background at this interval, e.g. 1m",
+	},
+	cli.StringFlag{
+		Name:  "oplog",
+		Usage: "fs provider: file to append every put and delete to, for point-in-time recovery with replay",
+		Value: "",
+	},
+	cli.DurationFlag{
+		Name:  "snapshot-load-timeout",
+		Usage: "memory provider: fail startup if loading memory.db takes longer (e.g. 1m), 0 to wait indefinitely",
+	},
+	cli.DurationFlag{
+		Name:  "snapshot-reload",
+		Usage: "memory provider: interval to check whether another process replaced memory.db and reload it (e.g. 30s), 0 to never reload",
+	},
+	cli.IntFlag{
+		Name:  "max-flush-failures",
+		Usage: "memory provider: consecutive failed flushes of memory.db after which writes fail with 503 until one succeeds, 0 to keep accepting them",
+	},
+	cli.IntFlag{
+		Name:  "breaker-threshold",
+		Usage: "consecutive failures of a remote storage (proxy, redis, replicate-to) after which requests fail with 503 for breaker-cooldown, 0 to disable",
+		Value: 0,
+	},
+	cli.DurationFlag{
+		Name:  "breaker-cooldown",
+		Usage: "time requests to a failing remote storage are short-circuited before probing it again",
+		Value: 30 * time.Second,
+	},
+	cli.StringFlag{
+		Name:  "replicate-to",
+		Usage: "base url of a keyvaluestorage instance every write is mirrored to in background",
+		Value: "",
+	},
+	cli.IntFlag{
+		Name:  "replication-queue",
+		Usage: "writes queued for replicate-to before new ones are dropped",
+		Value: 1000,
+	},
+	cli.StringFlag{
+		Name:  "eviction-webhook",
+		Usage: "url a JSON event is posted to in background, with retries, for every key deleted or purged once expired",
 		Value: "",
 	},
+	cli.IntFlag{
+		Name:  "compress-threshold",
+		Usage: "size in bytes from which values are stored gzip compressed when it makes them smaller, 0 to disable",
+		Value: 0,
+	},
+	cli.BoolFlag{
+		Name:  "append-only",
+		Usage: "keys can only be created and read: overwrites fail with 409, deletes and expires with 403",
+	},
+	cli.BoolFlag{
+		Name:  "typed-keys",
+		Usage: "reject operations against keys holding another value type (string, list) with 409",
+	},
+	cli.BoolFlag{
+		Name:  "creation-times",
+		Usage: "memory and fs providers: record when keys are first written, answered in X-Created-At and listed with sort=created",
+	},
+	cli.StringFlag{
+		Name:  "fallback-basedir",
+		Usage: "fs provider: path storage switched to when basedir keeps failing writes",
+		Value: "",
+	},
+	cli.StringFlag{
+		Name:  "encryption-key",
+		Usage: "hex encoded master key (at least 32 bytes) enabling encryption of stored values",
+		Value: "",
+	},
+	cli.IntFlag{
+		Name:  "change-log-size",
+		Usage: "number of mutations retained for /keys/changes, 0 to disable",
+		Value: 1000,
+	},
+	cli.IntFlag{
+		Name:  "success-status",
+		Usage: "status of successful writes and deletes: 204, or 200 with a {\"ok\":true} body",
+		Value: 204,
+	},
+	cli.BoolFlag{
+		Name:  "no-escape-html",
+		Usage: "do not escape <, > and & in listing responses",
+	},
+	cli.IntFlag{
+		Name:  "max-response-bytes",
+		Usage: "maximum size of a listing response, 0 for unlimited",
+		Value: 0,
+	},
+	cli.BoolFlag{
+		Name:  "record-last-writer",
+		Usage: "record the basic auth user of every PUT of a key, answered as X-Last-Writer by GET and HEAD",
+	},
+	cli.IntFlag{
+		Name:  "gzip-listings",
+		Usage: "size in bytes from which listings are sent gzip compressed to clients accepting it, 0 to disable",
+		Value: 1024,
+	},
+	cli.IntFlag{
+		Name:  "max-batch-size",
+		Usage: "maximum number of keys of a batch operation (mget, mexists, bulk put, form put), 0 for unlimited",
+		Value: 0,
+	},
+	cli.IntFlag{
+		Name:  "blob-chunk-size",
+		Usage: "size in bytes of the chunk keys a value PUT to /blobs/{id} is split in",
+		Value: 1 << 20,
+	},
+	cli.DurationFlag{
+		Name:  "expiry-grace",
+		Usage: "time a key is still served after expiring (e.g. 5s), with a Warning header, before it is 404 and purged",
+	},
+	cli.DurationFlag{
+		Name:  "request-timeout",
+		Usage: "deadline of every request (e.g. 5s), operations against the proxied instance being canceled along it, 0 for none",
+	},
+	cli.IntFlag{
+		Name:  "max-buffered-value",
+		Usage: "maximum size of a value buffered in memory to transform it (template, base64, YAML, pretty), 0 for unlimited",
+		Value: 0,
+	},
+	cli.BoolFlag{
+		Name:  "truncate-list",
+		Usage: "truncate listings over max-list-keys or max-response-bytes instead of failing with 413",
+	},
+	cli.BoolFlag{
+		Name:  "hash-listed-keys",
+		Usage: "list keys as the hex sha256 of them, single keys are still got by their name",
+	},
+	cli.BoolFlag{
+		Name:  "disable-list",
+		Usage: "reject GET /keys listings with 403, single keys are still served",
+	},
 }
 
 type cmd struct {
@@ -53,6 +362,68 @@ func versionAction(c *cli.Context) {
 	fmt.Println("Key value storage server ver")
 }
 
+func replayAction(c *cli.Context) {
+	to := time.Now()
+	if v := c.String("to"); v != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			panic(fmt.Sprintf("Error parsing replay time (%s): %s\n", v, err))
+		}
+	}
+
+	applied, err := storage.Replay(c.String("oplog"), to, c.String("basedir"))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Replayed %d operations up to %s in %s\n", applied, to.Format(time.RFC3339Nano), c.String("basedir"))
+}
+
+func benchAction(c *cli.Context) {
+	mix, err := storage.ParseBenchMix(c.String("mix"))
+	if err != nil {
+		panic(err)
+	}
+
+	// the provider is set by the global flags
+	global := c.Parent()
+	strg, err := newStorage(global, newStorageOptions(global))
+	if err != nil {
+		panic(err)
+	}
+
+	defer strg.Flush()
+
+	result, err := storage.Bench(strg, storage.BenchConfig{
+		Duration:  c.Duration("duration"),
+		Workers:   c.Int("workers"),
+		Keys:      c.Int("keys"),
+		ValueSize: c.Int("value-size"),
+		Mix:       mix,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Benchmarked %s provider for %s: %d operations, %.1f ops/sec\n", strg.Type(), result.Elapsed.Round(time.Millisecond), result.Count(), result.OpsPerSecond())
+
+	operations := make([]string, 0, len(result.Operations))
+	for operation := range result.Operations {
+		operations = append(operations, operation)
+	}
+
+	sort.Strings(operations)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tERRORS\tOPS/SEC\tP50 MS\tP90 MS\tP99 MS")
+	for _, operation := range operations {
+		stats := result.Operations[operation]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.3f\t%.3f\t%.3f\n", operation, stats.Count, stats.Errors, float64(stats.Count)/result.Elapsed.Seconds(), stats.P50, stats.P90, stats.P99)
+	}
+
+	w.Flush()
+}
+
 func newServer() *cmd {
 	app := cli.NewApp()
 	app.Name = "Key value storage server"
@@ -66,6 +437,57 @@ func newServer() *cmd {
 			Name:   "version",
 			Action: versionAction,
 		},
+		{
+			Name:   "replay",
+			Usage:  "rebuild an fs storage as of a point in time from its operation log",
+			Action: replayAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "oplog",
+					Usage: "operation log to replay",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "replay operations up to this RFC 3339 timestamp (default: now)",
+				},
+				cli.StringFlag{
+					Name:  "basedir",
+					Usage: "empty dir to rebuild the fs storage in",
+				},
+			},
+		},
+		{
+			Name:   "bench",
+			Usage:  "benchmark the provider set by the global flags with a mix of puts, gets and deletes, without serving HTTP",
+			Action: benchAction,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "duration",
+					Usage: "how long to run operations for",
+					Value: 10 * time.Second,
+				},
+				cli.StringFlag{
+					Name:  "mix",
+					Usage: "relative weights of the operations run, as operation:weight",
+					Value: "put:20,get:70,delete:10",
+				},
+				cli.IntFlag{
+					Name:  "workers",
+					Usage: "operations run at once",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "keys",
+					Usage: "keys operated on, written before the run and deleted after it",
+					Value: 1000,
+				},
+				cli.IntFlag{
+					Name:  "value-size",
+					Usage: "bytes of the values put",
+					Value: 128,
+				},
+			},
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -78,27 +500,153 @@ func newServer() *cmd {
 			options = append(options, http.Listener(v))
 		}
 
-		switch provider := c.String("provider"); provider {
-		case "fs":
-			if v := c.String("basedir"); v == "" {
-				panic("basedir not set.")
-			} else if storage, err := storage.NewFileSystemStorage(v); err != nil {
-				panic(err)
-			} else {
-				options = append(options, http.UseStorage(storage))
+		if v := c.String("auth-user"); v != "" || c.String("auth-pass") != "" {
+			options = append(options, http.BasicAuth(v, c.String("auth-pass")))
+		}
+
+		if v := c.String("tls-cert"); v != "" {
+			options = append(options, http.TLSCert(v))
+		}
+
+		if v := c.String("tls-key"); v != "" {
+			options = append(options, http.TLSKey(v))
+		}
+
+		if v := c.String("tls-redirect"); v != "" {
+			options = append(options, http.TLSRedirect(v))
+		}
+
+		if v := c.String("admin-token"); v != "" {
+			options = append(options, http.AdminToken(v))
+		}
+
+		if v := c.Int("max-pattern-wildcards"); v > 0 {
+			options = append(options, http.MaxPatternWildcards(v))
+		}
+
+		if v := c.Int("min-ttl"); v > 0 {
+			options = append(options, http.MinTTL(time.Duration(v)*time.Second))
+		}
+
+		if v := c.Duration("idempotency-ttl"); v > 0 {
+			options = append(options, http.IdempotencyTTL(v))
+		}
+
+		if v := c.Duration("sliding-ttl"); v > 0 {
+			threshold := c.Duration("sliding-ttl-threshold")
+			if threshold <= 0 {
+				threshold = v
 			}
-		case "memory":
-			if v := c.String("basedir"); v == "" {
-				panic("basedir not set.")
-			} else if storage, err := storage.NewMemoryStorage(v); err != nil {
+
+			options = append(options, http.SlidingTTL(v, threshold, c.Bool("touch-on-get")))
+		}
+
+		if c.Bool("redirect-trailing-slash") {
+			options = append(options, http.RedirectTrailingSlash())
+		}
+
+		if c.Bool("enable-pprof") {
+			options = append(options, http.EnablePprof())
+		}
+
+		if c.Bool("enable-tracing") {
+			provider, err := newTracerProvider()
+			if err != nil {
+				panic(fmt.Sprintf("Error starting tracing: %s\n", err))
+			}
+
+			defer provider.Shutdown(context.Background())
+
+			options = append(options, http.Tracing(provider))
+		}
+
+		if c.Bool("enable-metrics") {
+			options = append(options, http.Metrics())
+		}
+
+		if v := c.Int("max-list-keys"); v > 0 || c.Bool("truncate-list") {
+			options = append(options, http.MaxListKeys(v, c.Bool("truncate-list")))
+		}
+
+		if c.Bool("strict-scans") {
+			options = append(options, http.StrictScans())
+		}
+
+		if c.Bool("hash-listed-keys") {
+			options = append(options, http.HashListedKeys())
+		}
+
+		if c.Bool("disable-list") {
+			options = append(options, http.DisableList())
+		}
+
+		if v := c.Int("max-response-bytes"); v > 0 {
+			options = append(options, http.MaxResponseBytes(v))
+		}
+
+		if v := c.Int("gzip-listings"); v > 0 {
+			options = append(options, http.GzipListings(v))
+		}
+
+		if v := c.Int("max-batch-size"); v > 0 {
+			options = append(options, http.MaxBatchSize(v))
+		}
+
+		if v := c.Int("blob-chunk-size"); v > 0 {
+			options = append(options, http.BlobChunkSize(v))
+		}
+
+		if v := c.Duration("request-timeout"); v > 0 {
+			options = append(options, http.RequestTimeout(v))
+		}
+
+		if v := c.Int("max-buffered-value"); v > 0 {
+			options = append(options, http.MaxBufferedValue(v))
+		}
+
+		switch v := c.Int("success-status"); v {
+		case 200, 204:
+			options = append(options, http.SuccessStatus(v))
+		default:
+			panic(fmt.Sprintf("Invalid success status %d: must be 200 or 204\n", v))
+		}
+
+		if c.Bool("no-escape-html") {
+			options = append(options, http.EscapeHTML(false))
+		}
+
+		options = append(options, http.ChangeLogSize(c.Int("change-log-size")))
+
+		switch v := c.String("audit-log"); v {
+		case "":
+		case "-":
+			options = append(options, http.AuditLog(logrus.StandardLogger().Writer()))
+		default:
+			if f, err := os.OpenFile(v, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
 				panic(err)
 			} else {
-				options = append(options, http.UseStorage(storage))
+				options = append(options, http.AuditLog(f))
 			}
-		default:
-			panic("Provider not set or invalid.")
 		}
 
+		if c.Bool("record-last-writer") {
+			options = append(options, http.RecordLastWriter())
+		}
+
+		if v := c.Duration("expiry-grace"); v > 0 {
+			options = append(options, http.ServeStale())
+		}
+
+		storageOptions := newStorageOptions(c)
+		options = append(options, http.StorageOptions(storageOptions...))
+
+		strg, err := newStorage(c, storageOptions)
+		if err != nil {
+			panic(err)
+		}
+
+		options = append(options, http.UseStorage(strg))
+
 		s, err := http.New(
 			options...,
 		)
@@ -115,6 +663,153 @@ func newServer() *cmd {
 	}
 }
 
+// newStorageOptions Returns the options of the storage set by the flags of c
+func newStorageOptions(c *cli.Context) []storage.OptionFn {
+	storageOptions := []storage.OptionFn{}
+	if c.Bool("fifo-writes") {
+		storageOptions = append(storageOptions, storage.FIFOWrites())
+	}
+
+	if v := c.Duration("key-index"); v > 0 {
+		storageOptions = append(storageOptions, storage.KeyIndex(v))
+	}
+
+	if v := c.Int("pattern-readers"); v > 1 {
+		storageOptions = append(storageOptions, storage.PatternReaders(v))
+	}
+
+	if c.Bool("relaxed-scans") {
+		storageOptions = append(storageOptions, storage.RelaxedScans())
+	}
+
+	if v := c.String("proxy-url"); v != "" {
+		storageOptions = append(storageOptions, storage.ProxyURL(v))
+	}
+
+	if v := c.String("read-through-provider"); v != "" {
+		storageOptions = append(storageOptions, storage.ReadThrough(v, c.String("read-through-basedir"), c.Duration("read-through-ttl")))
+	}
+
+	if v := c.String("redis-addr"); v != "" {
+		storageOptions = append(storageOptions, storage.Redis(v, c.String("redis-prefix")))
+	}
+
+	if v := c.Duration("max-age"); v > 0 {
+		storageOptions = append(storageOptions, storage.MaxAge(v))
+	}
+
+	if v := c.Duration("expiry-grace"); v > 0 {
+		storageOptions = append(storageOptions, storage.ExpiryGrace(v))
+	}
+
+	if v := c.Int("max-maintenance"); v > 1 {
+		storageOptions = append(storageOptions, storage.MaxMaintenance(v))
+	}
+
+	if v := c.Duration("eviction-interval"); v > 0 {
+		storageOptions = append(storageOptions, storage.EvictionInterval(v))
+	}
+
+	if v := c.String("oplog"); v != "" {
+		storageOptions = append(storageOptions, storage.OperationLog(v))
+	}
+
+	if v := c.Duration("snapshot-load-timeout"); v > 0 {
+		storageOptions = append(storageOptions, storage.SnapshotLoadTimeout(v))
+	}
+
+	if v := c.Duration("snapshot-reload"); v > 0 {
+		storageOptions = append(storageOptions, storage.SnapshotReload(v))
+	}
+
+	if v := c.Int("max-flush-failures"); v > 0 {
+		storageOptions = append(storageOptions, storage.MaxFlushFailures(v))
+	}
+
+	if v := c.Int("breaker-threshold"); v > 0 {
+		storageOptions = append(storageOptions, storage.CircuitBreaker(v, c.Duration("breaker-cooldown")))
+	}
+
+	if v := c.String("eviction-webhook"); v != "" {
+		storageOptions = append(storageOptions, storage.EvictionWebhook(v))
+	}
+
+	if v := c.String("replicate-to"); v != "" {
+		storageOptions = append(storageOptions, storage.ReplicateTo(v, c.Int("replication-queue")))
+	}
+
+	if v := c.Int("compress-threshold"); v > 0 {
+		storageOptions = append(storageOptions, storage.Compression(v))
+	}
+
+	if c.Bool("append-only") {
+		storageOptions = append(storageOptions, storage.AppendOnly())
+	}
+
+	if c.Bool("typed-keys") {
+		storageOptions = append(storageOptions, storage.TypedKeys())
+	}
+
+	if c.Bool("creation-times") {
+		storageOptions = append(storageOptions, storage.CreationTimes())
+	}
+
+	if v := c.String("fallback-basedir"); v != "" {
+		storageOptions = append(storageOptions, storage.FallbackDir(v))
+	}
+
+	if v := c.String("encryption-key"); v != "" {
+		masterKey, err := hex.DecodeString(v)
+		if err != nil {
+			panic(fmt.Sprintf("Error decoding encryption key: %s\n", err))
+		}
+
+		storageOptions = append(storageOptions, storage.Encryption(masterKey))
+	}
+
+	return storageOptions
+}
+
+// newStorage Returns the storage of the provider set by the flags of c, with storageOptions
+func newStorage(c *cli.Context, storageOptions []storage.OptionFn) (storage.Storage, error) {
+	strg, err := storage.NewStorage(c.String("provider"), c.String("basedir"), storageOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if latency, jitter := c.Duration("simulate-latency"), c.Duration("simulate-latency-jitter"); latency > 0 || jitter > 0 {
+		strg = storage.NewLatencyStorage(strg, latency, jitter)
+	}
+
+	if v := c.Int("read-cache-size"); v > 0 {
+		strg = storage.NewCacheStorage(strg, v, c.Duration("read-cache-ttl"))
+	}
+
+	return strg, nil
+}
+
+// newTracerProvider Returns a tracer provider exporting spans over OTLP/HTTP, set as the global one
+// for the storage providers calling other services to continue traces
+func newTracerProvider() (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.Merge(resource.NewSchemaless(attribute.String("service.name", "keyvaluestorage")), resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider, nil
+}
+
 func main() {
 	app := newServer()
 	app.RunAndExitOnError()
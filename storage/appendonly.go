@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errAppendOnlyOverwrite = errors.New("append-only: keys cannot be overwritten once written")
+
+var errAppendOnlyDelete = errors.New("append-only: keys cannot be deleted or expired")
+
+type appendOnlyStorage struct {
+	Storage
+
+	// serializes creations, checking a key is missing and writing it with metadata being two operations
+	locks *keyedLocker
+}
+
+// NewAppendOnlyStorage Decorator for storage
+// only creates and reads keys, rejecting overwrites as conflicts and deletes as forbidden,
+// a key can be created again only once expired
+func NewAppendOnlyStorage(storage Storage) *appendOnlyStorage {
+	return &appendOnlyStorage{
+		Storage: storage,
+		locks:   newKeyedLocker(false),
+	}
+}
+
+// appendOnlyStorage.WithContext Returns the storage bound to ctx, serializing creations along this one
+func (s *appendOnlyStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// appendOnlyStorage.Put Saves an entry by key with timeout only if missing, returns error if it fails
+func (s *appendOnlyStorage) Put(key string, value string, expiration time.Duration) error {
+	return s.PutWithMetadata(key, value, nil, expiration)
+}
+
+// appendOnlyStorage.PutMany Saves every entry by key with timeout unless one is already written, returns a PutManyError of the keys that failed
+func (s *appendOnlyStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// appendOnlyStorage.PutWithMetadata Saves an entry by key with timeout along metadata only if missing, returns error if it fails
+func (s *appendOnlyStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	if len(metadata) == 0 {
+		_, err := s.Storage.PutIfVersion(key, value, 0, expiration)
+		if s.Storage.IsVersionMismatch(err) {
+			return errAppendOnlyOverwrite
+		}
+
+		return err
+	}
+
+	if _, _, err := s.Storage.GetVersioned(key); err == nil {
+		return errAppendOnlyOverwrite
+	} else if !s.Storage.IsNotExist(err) {
+		return err
+	}
+
+	return s.Storage.PutWithMetadata(key, value, metadata, expiration)
+}
+
+// appendOnlyStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, and whether it was created or error if it fails
+func (s *appendOnlyStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	return s.Storage.GetOrCreate(key, defaultValue, expiration)
+}
+
+// appendOnlyStorage.PutIfTTLBelow Saves an entry by key with timeout only if missing, returns whether it was saved or error if it fails
+func (s *appendOnlyStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	_, err := s.Storage.PutIfVersion(key, value, 0, expiration)
+	if s.Storage.IsVersionMismatch(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// appendOnlyStorage.CompareAndSwap Fails, swapping the value of a key overwrites it
+func (s *appendOnlyStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	return false, errAppendOnlyOverwrite
+}
+
+// appendOnlyStorage.PutIfEmpty Saves an entry by key with timeout only if no key starting with prefix exists, returns whether it was saved or error if it fails
+func (s *appendOnlyStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	return s.Storage.PutIfEmpty(prefix, key, value, expiration)
+}
+
+// appendOnlyStorage.PutIfVersion Saves an entry by key with timeout only if missing, version being 0, returns the new version or error if it fails
+func (s *appendOnlyStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	if version != 0 {
+		return 0, errAppendOnlyOverwrite
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	return s.Storage.PutIfVersion(key, value, version, expiration)
+}
+
+// appendOnlyStorage.ExpirePattern Fails, expiring entries would delete them
+func (s *appendOnlyStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	return 0, errAppendOnlyDelete
+}
+
+// appendOnlyStorage.Delete Fails, entries cannot be deleted
+func (s *appendOnlyStorage) Delete(key string) error {
+	return errAppendOnlyDelete
+}
+
+// appendOnlyStorage.DeleteIfVersion Fails, entries cannot be deleted
+func (s *appendOnlyStorage) DeleteIfVersion(key string, version int64) error {
+	return errAppendOnlyDelete
+}
+
+// appendOnlyStorage.DeleteAll Fails, entries cannot be deleted
+func (s *appendOnlyStorage) DeleteAll() error {
+	return errAppendOnlyDelete
+}
+
+// appendOnlyStorage.Push Fails, elements cannot be appended in place to a written value
+func (s *appendOnlyStorage) Push(key string, element string) error {
+	return errAppendOnlyOverwrite
+}
+
+// appendOnlyStorage.Pop Fails, elements cannot be removed from a written value
+func (s *appendOnlyStorage) Pop(key string) (string, error) {
+	return "", errAppendOnlyOverwrite
+}
+
+// appendOnlyStorage.Increment Fails, a written value cannot be updated in place
+func (s *appendOnlyStorage) Increment(key string, delta int64) (int64, error) {
+	return 0, errAppendOnlyOverwrite
+}
+
+// appendOnlyStorage.Decrement Fails, a written value cannot be updated in place
+func (s *appendOnlyStorage) Decrement(key string, delta int64) (int64, error) {
+	return 0, errAppendOnlyOverwrite
+}
+
+// appendOnlyStorage.IsConflict Checks if error is a conflict with the stored value
+func (s *appendOnlyStorage) IsConflict(err error) bool {
+	return err == errAppendOnlyOverwrite || s.Storage.IsConflict(err)
+}
+
+// appendOnlyStorage.IsForbidden Checks if error is for an operation not allowed on the storage
+func (s *appendOnlyStorage) IsForbidden(err error) bool {
+	return err == errAppendOnlyDelete || s.Storage.IsForbidden(err)
+}
+
+// appendOnlyStorage.Rejected Returns the operations deleting or expiring keys, with the ones the decorated storage rejects
+func (s *appendOnlyStorage) Rejected() []string {
+	return append([]string{"delete", "delete_all", "expire_pattern"}, Rejected(s.Storage)...)
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestAppendOnlyStorage(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage := NewAppendOnlyStorage(memory)
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+
+	saved, err := storage.PutIfTTLBelow("a key", "another value", time.Hour, time.Duration(-1))
+	if err != nil || saved {
+		t.Fatalf("expected not saved, found : %t, %v", saved, err)
+	}
+
+	if err := storage.Push("a key", "1"); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+
+	for _, err := range []error{storage.Delete("a key"), storage.DeleteAll()} {
+		if !storage.IsForbidden(err) {
+			t.Fatalf("expected forbidden, found : %v", err)
+		}
+	}
+
+	if _, err := storage.ExpirePattern("*", time.Second); !storage.IsForbidden(err) {
+		t.Fatalf("expected forbidden, found : %v", err)
+	}
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", chk)
+	}
+}
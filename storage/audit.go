@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+type auditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	Key       string    `json:"key"`
+	Actor     string    `json:"actor"`
+}
+
+type auditStorage struct {
+	Storage
+
+	writer io.Writer
+	actor  string
+}
+
+// NewAuditStorage Decorator for storage
+// appends an audit record to writer for every mutation performed by actor
+func NewAuditStorage(storage Storage, writer io.Writer, actor string) *auditStorage {
+	return &auditStorage{
+		Storage: storage,
+		writer:  writer,
+		actor:   actor,
+	}
+}
+
+func (s *auditStorage) audit(operation string, key string) error {
+	record, err := json.Marshal(auditRecord{
+		Timestamp: time.Now().UTC(),
+		Operation: operation,
+		Key:       key,
+		Actor:     s.actor,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.writer.Write(append(record, '\n'))
+
+	return err
+}
+
+// auditStorage.Put Saves an entry by key with timeout and audits it, returns error if it fails
+func (s *auditStorage) Put(key string, value string, expiration time.Duration) error {
+	if err := s.Storage.Put(key, value, expiration); err != nil {
+		return err
+	}
+
+	return s.audit("put", key)
+}
+
+// auditStorage.PutMany Saves every entry by key with timeout auditing each, returns a PutManyError of the keys that failed
+func (s *auditStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// auditStorage.PutWithMetadata Saves an entry by key with timeout along metadata and audits it, returns error if it fails
+func (s *auditStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	if err := s.Storage.PutWithMetadata(key, value, metadata, expiration); err != nil {
+		return err
+	}
+
+	return s.audit("put", key)
+}
+
+// auditStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing and auditing the creation, or error if it fails
+func (s *auditStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	value, created, err := s.Storage.GetOrCreate(key, defaultValue, expiration)
+	if err != nil || !created {
+		return value, created, err
+	}
+
+	return value, created, s.audit("put", key)
+}
+
+// auditStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold and audits it, returns whether it was saved or error if it fails
+func (s *auditStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	return saved, s.audit("put", key)
+}
+
+// auditStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue and audits it, returns whether it was saved or error if it fails
+func (s *auditStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	return saved, s.audit("put", key)
+}
+
+// auditStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists and audits it, returns whether it was saved or error if it fails
+func (s *auditStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.PutIfEmpty(prefix, key, value, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	return saved, s.audit("put", key)
+}
+
+// auditStorage.PutIfVersion Saves an entry by key with timeout if its version is version and audits it, returns the new version or error if it fails
+func (s *auditStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	newVersion, err := s.Storage.PutIfVersion(key, value, version, expiration)
+	if err != nil {
+		return newVersion, err
+	}
+
+	return newVersion, s.audit("put", key)
+}
+
+// auditStorage.ExpirePattern Updates expiration of entries matching a pattern and audits it, returns count updated or error if it fails
+func (s *auditStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	updated, err := s.Storage.ExpirePattern(pattern, expiration)
+	if err != nil {
+		return updated, err
+	}
+
+	return updated, s.audit("expire", pattern)
+}
+
+// auditStorage.Delete Deletes an entry by key and audits it, returns error if it fails
+func (s *auditStorage) Delete(key string) error {
+	if err := s.Storage.Delete(key); err != nil {
+		return err
+	}
+
+	return s.audit("delete", key)
+}
+
+// auditStorage.DeleteIfVersion Deletes an entry by key if its version is version and audits it, returns error if it fails
+func (s *auditStorage) DeleteIfVersion(key string, version int64) error {
+	if err := s.Storage.DeleteIfVersion(key, version); err != nil {
+		return err
+	}
+
+	return s.audit("delete", key)
+}
+
+// auditStorage.Push Appends an element to the JSON array stored by key and audits it, returns error if it fails
+func (s *auditStorage) Push(key string, element string) error {
+	if err := s.Storage.Push(key, element); err != nil {
+		return err
+	}
+
+	return s.audit("push", key)
+}
+
+// auditStorage.Pop Removes and returns the last element of the JSON array stored by key and audits it or error if it fails
+func (s *auditStorage) Pop(key string) (string, error) {
+	element, err := s.Storage.Pop(key)
+	if err != nil {
+		return element, err
+	}
+
+	return element, s.audit("pop", key)
+}
+
+// auditStorage.Increment Adds delta to the integer stored by key and audits it, returns the new value or error if it fails
+func (s *auditStorage) Increment(key string, delta int64) (int64, error) {
+	counter, err := s.Storage.Increment(key, delta)
+	if err != nil {
+		return counter, err
+	}
+
+	return counter, s.audit("increment", key)
+}
+
+// auditStorage.Decrement Subtracts delta from the integer stored by key and audits it, returns the new value or error if it fails
+func (s *auditStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// auditStorage.DeleteAll Deletes all entries and audits it, returns error if it fails
+func (s *auditStorage) DeleteAll() error {
+	if err := s.Storage.DeleteAll(); err != nil {
+		return err
+	}
+
+	return s.audit("delete_all", "*")
+}
+
+// auditStorage.PurgeExpired Deletes all expired entries and audits it, returns the keys purged or error if it fails
+func (s *auditStorage) PurgeExpired() ([]string, error) {
+	purged, err := s.Storage.PurgeExpired()
+	if err != nil || len(purged) == 0 {
+		return purged, err
+	}
+
+	return purged, s.audit("purge_expired", "*")
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditStorage_Mutations(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	var log bytes.Buffer
+	storage := NewAuditStorage(memory, &log, "an actor")
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := []string{"put", "delete"}
+	records := 0
+	scanner := bufio.NewScanner(&log)
+	for i := 0; scanner.Scan(); i++ {
+		records++
+		if i >= len(expected) {
+			t.Fatalf("unexpected audit record: %s", scanner.Text())
+		}
+
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if record.Operation != expected[i] {
+			t.Fatalf("expected: %s, found : %s", expected[i], record.Operation)
+		}
+
+		if record.Key != "a key" {
+			t.Fatalf("expected: %s, found : %s", "a key", record.Key)
+		}
+
+		if record.Actor != "an actor" {
+			t.Fatalf("expected: %s, found : %s", "an actor", record.Actor)
+		}
+
+		if record.Timestamp.IsZero() {
+			t.Fatalf("expected timestamp set")
+		}
+	}
+
+	if records != len(expected) {
+		t.Fatalf("expected: %d, found : %d", len(expected), records)
+	}
+}
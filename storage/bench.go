@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix of the keys written by a benchmark, deleted once it ends
+const benchKeyPrefix = "bench:"
+
+// samples of every operation the latency percentiles of a benchmark are computed over
+const benchWindow = 100000
+
+// BenchMix Relative weights of the operations run by a benchmark
+type BenchMix struct {
+	Put    int
+	Get    int
+	Delete int
+}
+
+// ParseBenchMix Returns the mix of a comma separated list of operation:weight, e.g. `put:20,get:70,delete:10`,
+// operations left out weighting 0
+func ParseBenchMix(value string) (BenchMix, error) {
+	var mix BenchMix
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 {
+			return mix, fmt.Errorf("invalid mix (%s): operations must be given as operation:weight", item)
+		}
+
+		operation := parts[0]
+		w, err := strconv.Atoi(parts[1])
+		if err != nil || w < 0 {
+			return mix, fmt.Errorf("invalid mix (%s): weights must be non negative integers", item)
+		}
+
+		switch operation {
+		case "put":
+			mix.Put = w
+		case "get":
+			mix.Get = w
+		case "delete":
+			mix.Delete = w
+		default:
+			return mix, fmt.Errorf("invalid mix (%s): operations must be put, get or delete", item)
+		}
+	}
+
+	if mix.Put+mix.Get+mix.Delete == 0 {
+		return mix, fmt.Errorf("invalid mix (%s): at least an operation must weight more than 0", value)
+	}
+
+	return mix, nil
+}
+
+// BenchConfig What a benchmark runs: for Duration, Workers run operations picked by Mix, each against one of Keys keys,
+// puts writing values of ValueSize bytes
+type BenchConfig struct {
+	Duration  time.Duration
+	Workers   int
+	Keys      int
+	ValueSize int
+	Mix       BenchMix
+}
+
+// BenchResult The outcome of a benchmark, Operations being the stats of every operation run
+type BenchResult struct {
+	Elapsed    time.Duration
+	Operations map[string]OperationStats
+}
+
+// BenchResult.Count Returns how many operations were run
+func (r BenchResult) Count() int64 {
+	var count int64
+	for _, stats := range r.Operations {
+		count += stats.Count
+	}
+
+	return count
+}
+
+// BenchResult.OpsPerSecond Returns the operations run per second
+func (r BenchResult) OpsPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(r.Count()) / r.Elapsed.Seconds()
+}
+
+// Bench Runs the benchmark of config against storage, filled with the keys beforehand, and deletes the keys afterwards,
+// returns its result or error if it cannot run
+func Bench(storage Storage, config BenchConfig) (BenchResult, error) {
+	if config.Duration <= 0 || config.Workers <= 0 || config.Keys <= 0 || config.ValueSize < 0 {
+		return BenchResult{}, fmt.Errorf("benchmark requires a duration, workers and keys")
+	}
+
+	total := config.Mix.Put + config.Mix.Get + config.Mix.Delete
+	if config.Mix.Put < 0 || config.Mix.Get < 0 || config.Mix.Delete < 0 || total == 0 {
+		return BenchResult{}, fmt.Errorf("benchmark requires a mix of operations")
+	}
+
+	value := strings.Repeat("x", config.ValueSize)
+	for i := 0; i < config.Keys; i++ {
+		if err := storage.Put(benchKey(i), value, noExpiration); err != nil {
+			return BenchResult{}, err
+		}
+	}
+
+	defer func() {
+		for i := 0; i < config.Keys; i++ {
+			if err := storage.Delete(benchKey(i)); err != nil && !storage.IsNotExist(err) {
+				logger.Warnf("benchmark key %s could not be deleted: %s", benchKey(i), err)
+			}
+		}
+	}()
+
+	stats := NewStorageStats(benchWindow)
+	observed := NewStatsStorage(storage, stats)
+
+	start := time.Now()
+	deadline := start.Add(config.Duration)
+
+	var wg sync.WaitGroup
+	for w := 0; w < config.Workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			random := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				key := benchKey(random.Intn(config.Keys))
+
+				// failures are recorded by the stats
+				switch pick := random.Intn(total); {
+				case pick < config.Mix.Put:
+					observed.Put(key, value, noExpiration)
+				case pick < config.Mix.Put+config.Mix.Get:
+					observed.Get(key)
+				default:
+					observed.Delete(key)
+				}
+			}
+		}(start.UnixNano() + int64(w))
+	}
+
+	wg.Wait()
+
+	return BenchResult{Elapsed: time.Since(start), Operations: stats.Snapshot()}, nil
+}
+
+func benchKey(i int) string {
+	return benchKeyPrefix + strconv.Itoa(i)
+}
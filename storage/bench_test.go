@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBenchMix(t *testing.T) {
+	mix, err := ParseBenchMix("put:20, get:70,delete:10")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if expected := (BenchMix{Put: 20, Get: 70, Delete: 10}); mix != expected {
+		t.Fatalf("expected: %v, found : %v", expected, mix)
+	}
+
+	for _, value := range []string{"", "put", "put:-1", "put:many", "scan:1", "put:0,get:0"} {
+		if _, err := ParseBenchMix(value); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	result, err := Bench(storage, BenchConfig{
+		Duration:  100 * time.Millisecond,
+		Workers:   1,
+		Keys:      100,
+		ValueSize: 64,
+		Mix:       BenchMix{Put: 2, Get: 7, Delete: 1},
+	})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if result.OpsPerSecond() <= 0 {
+		t.Fatalf("expected: %s, found : %f", "ops/sec above 0", result.OpsPerSecond())
+	}
+
+	for _, operation := range []string{"put", "get", "delete"} {
+		if stats := result.Operations[operation]; stats.Count == 0 || stats.Errors > 0 {
+			t.Fatalf("expected: %s, found : %+v", operation+" run without errors", stats)
+		}
+	}
+
+	// the benchmark keys are deleted
+	if count, err := storage.CountPattern(benchKeyPrefix + "*"); err != nil || count != 0 {
+		t.Fatalf("expected: %d, found : %d (%v)", 0, count, err)
+	}
+
+	if _, err := Bench(storage, BenchConfig{Duration: time.Second, Workers: 1, Keys: 1}); err == nil {
+		t.Fatalf("expected an error without a mix")
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// blobManifest is stored under the id of a blob, its value being split across chunk keys
+type blobManifest struct {
+	Size   int64 `json:"size"`
+	Chunks int   `json:"chunks"`
+}
+
+// chunkKey Returns the key of chunk i of blob id
+func chunkKey(id string, i int) string {
+	return fmt.Sprintf("%s#%d", id, i)
+}
+
+// getManifest Returns the manifest of blob id, errNotExists if id is missing or not a blob
+func getManifest(storage Storage, id string) (blobManifest, error) {
+	var manifest blobManifest
+
+	r, err := storage.Get(id)
+	if err != nil {
+		return manifest, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(value, &manifest); err != nil || manifest.Chunks < 0 {
+		return manifest, errNotExists
+	}
+
+	return manifest, nil
+}
+
+// PutBlob Saves the value read from r with timeout as blob id, split in chunks of chunkSize bytes
+// saved under `id#0`, `id#1`, ... before the manifest under id, returns the size saved or error if it fails
+// only a chunk is held in memory at once, chunks left over by a longer previous value are deleted
+func PutBlob(storage Storage, id string, r io.Reader, chunkSize int, expiration time.Duration) (int64, error) {
+	previous, err := getManifest(storage, id)
+	if err != nil && !storage.IsNotExist(err) {
+		return 0, err
+	}
+
+	var manifest blobManifest
+
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := readChunk(r, chunk)
+		if n > 0 {
+			if err := storage.Put(chunkKey(id, manifest.Chunks), string(chunk[:n]), expiration); err != nil {
+				return manifest.Size, err
+			}
+
+			manifest.Chunks++
+			manifest.Size += int64(n)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest.Size, err
+		}
+	}
+
+	value, err := json.Marshal(manifest)
+	if err != nil {
+		return manifest.Size, err
+	}
+
+	if err := storage.Put(id, string(value), expiration); err != nil {
+		return manifest.Size, err
+	}
+
+	for i := manifest.Chunks; i < previous.Chunks; i++ {
+		if err := storage.Delete(chunkKey(id, i)); err != nil && !storage.IsNotExist(err) {
+			return manifest.Size, err
+		}
+	}
+
+	return manifest.Size, nil
+}
+
+// readChunk Fills chunk from r, returns how many bytes it read and io.EOF once r is consumed
+// unlike io.ReadFull a truncated r fails with its own error rather than ending the last chunk
+func readChunk(r io.Reader, chunk []byte) (int, error) {
+	n := 0
+	for n < len(chunk) {
+		m, err := r.Read(chunk[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// GetBlob Returns io.Reader for the value of blob id reassembled from its chunks, read one at a time,
+// with its size or error if it fails
+func GetBlob(storage Storage, id string) (io.Reader, int64, error) {
+	manifest, err := getManifest(storage, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &blobReader{storage: storage, id: id, chunks: manifest.Chunks}, manifest.Size, nil
+}
+
+// DeleteBlob Deletes the chunks then the manifest of blob id, returns error if it fails
+func DeleteBlob(storage Storage, id string) error {
+	manifest, err := getManifest(storage, id)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < manifest.Chunks; i++ {
+		if err := storage.Delete(chunkKey(id, i)); err != nil && !storage.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return storage.Delete(id)
+}
+
+// blobReader reads the chunks of a blob in order, getting each one once the previous is consumed
+type blobReader struct {
+	storage Storage
+	id      string
+	chunks  int
+	next    int
+	current io.Reader
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	for {
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			if err == io.EOF {
+				r.current = nil
+				err = nil
+			}
+
+			if n > 0 || err != nil {
+				return n, err
+			}
+		}
+
+		if r.next == r.chunks {
+			return 0, io.EOF
+		}
+
+		chunk, err := r.storage.Get(chunkKey(r.id, r.next))
+		if err != nil {
+			return 0, fmt.Errorf("chunk %d of blob %s: %s", r.next, r.id, err)
+		}
+
+		r.current = chunk
+		r.next++
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestBlob(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value := make([]byte, 2500)
+	if _, err := rand.Read(value); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// a shorter value leaves no chunk of the longer one behind
+	for _, size := range []int{2500, 1000} {
+		written, err := PutBlob(storage, "a blob", bytes.NewReader(value[:size]), 1000, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if written != int64(size) {
+			t.Fatalf("expected: %d, found : %d", size, written)
+		}
+
+		r, length, err := GetBlob(storage, "a blob")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if length != int64(size) || !bytes.Equal(chk, value[:size]) {
+			t.Fatalf("expected the %d bytes written, found : %d bytes", size, len(chk))
+		}
+	}
+
+	if count, _ := storage.CountPattern("a blob#*"); count != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, count)
+	}
+
+	if err := DeleteBlob(storage, "a blob"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if count, _ := storage.CountPattern("a blob*"); count != 0 {
+		t.Fatalf("expected: %d, found : %d", 0, count)
+	}
+
+	if _, _, err := GetBlob(storage, "a blob"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
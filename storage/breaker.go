@@ -0,0 +1,404 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("storage unavailable: circuit breaker open")
+
+// states of a circuit breaker
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breakerStorage struct {
+	Storage
+
+	threshold int
+	cooldown  time.Duration
+
+	*breakerState
+}
+
+// breakerState is shared by a breaker and its copies bound to a context
+type breakerState struct {
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreakerStorage Decorator for remote storage
+// after threshold consecutive failures fails every request for cooldown, then lets a single request through
+// to probe the storage, closing again when it succeeds
+func NewBreakerStorage(storage Storage, threshold int, cooldown time.Duration) *breakerStorage {
+	return &breakerStorage{
+		Storage:      storage,
+		threshold:    threshold,
+		cooldown:     cooldown,
+		breakerState: &breakerState{},
+	}
+}
+
+// breakerStorage.WithContext Returns the breaker over storage bound to ctx, tripping along this one
+func (s *breakerStorage) WithContext(ctx context.Context) Storage {
+	return &breakerStorage{
+		Storage:      WithContext(ctx, s.Storage),
+		threshold:    s.threshold,
+		cooldown:     s.cooldown,
+		breakerState: s.breakerState,
+	}
+}
+
+// breakerStorage.Rejected Returns the operations the decorated storage rejects
+func (s *breakerStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+// allow Returns errCircuitOpen if the request must not reach the storage
+func (s *breakerStorage) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case breakerOpen:
+		if time.Since(s.openedAt) < s.cooldown {
+			return errCircuitOpen
+		}
+
+		s.state = breakerHalfOpen
+		s.probing = true
+		logger.Infof("circuit breaker half-open, probing %s storage", s.Storage.Type())
+	case breakerHalfOpen:
+		if s.probing {
+			return errCircuitOpen
+		}
+
+		s.probing = true
+	}
+
+	return nil
+}
+
+// record Updates the breaker with the outcome of a request, errors about the stored values are not failures
+func (s *breakerStorage) record(err error) {
+	failed := isFailure(s.Storage, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.probing = false
+
+	if !failed {
+		if s.state != breakerClosed {
+			logger.Infof("circuit breaker closed, %s storage recovered", s.Storage.Type())
+		}
+
+		s.state = breakerClosed
+		s.failures = 0
+		return
+	}
+
+	s.failures++
+	if s.state == breakerHalfOpen || s.failures >= s.threshold {
+		if s.state != breakerOpen {
+			logger.Warnf("circuit breaker open for %s after %d failures of %s storage: %s", s.cooldown, s.failures, s.Storage.Type(), err)
+		}
+
+		s.state = breakerOpen
+		s.openedAt = time.Now()
+	}
+}
+
+// call Runs op against the storage unless the breaker is open
+func (s *breakerStorage) call(op func() error) error {
+	if err := s.allow(); err != nil {
+		return err
+	}
+
+	err := op()
+	s.record(err)
+
+	return err
+}
+
+// breakerStorage.IsUnavailable Checks if error is for a request short-circuited by the breaker
+func (s *breakerStorage) IsUnavailable(err error) bool {
+	return err == errCircuitOpen || s.Storage.IsUnavailable(err)
+}
+
+// breakerStorage.Put Saves an entry by key with timeout unless the breaker is open, returns error if it fails
+func (s *breakerStorage) Put(key string, value string, expiration time.Duration) error {
+	return s.call(func() error {
+		return s.Storage.Put(key, value, expiration)
+	})
+}
+
+// breakerStorage.PutMany Saves every entry by key with timeout unless the breaker is open, returns a PutManyError of the keys that failed
+func (s *breakerStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return s.call(func() error {
+		return s.Storage.PutMany(entries, expiration)
+	})
+}
+
+// breakerStorage.PutWithMetadata Saves an entry by key with timeout along metadata unless the breaker is open, returns error if it fails
+func (s *breakerStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	return s.call(func() error {
+		return s.Storage.PutWithMetadata(key, value, metadata, expiration)
+	})
+}
+
+// breakerStorage.GetMetadata Returns the metadata stored along the value of a key unless the breaker is open or error if it fails
+func (s *breakerStorage) GetMetadata(key string) (metadata Metadata, err error) {
+	err = s.call(func() error {
+		metadata, err = s.Storage.GetMetadata(key)
+		return err
+	})
+
+	return metadata, err
+}
+
+// breakerStorage.Get Returns io.Reader for a key unless the breaker is open or error if it fails
+func (s *breakerStorage) Get(key string) (r io.Reader, err error) {
+	err = s.call(func() error {
+		r, err = s.Storage.Get(key)
+		return err
+	})
+
+	return r, err
+}
+
+// breakerStorage.GetMany Returns the values of the existing keys unless the breaker is open or error if it fails
+func (s *breakerStorage) GetMany(keys []string) (values map[string][]byte, err error) {
+	err = s.call(func() error {
+		values, err = s.Storage.GetMany(keys)
+		return err
+	})
+
+	return values, err
+}
+
+// breakerStorage.GetStale Returns io.Reader for a key and whether it is stale unless the breaker is open or error if it fails
+func (s *breakerStorage) GetStale(key string) (r io.Reader, stale bool, err error) {
+	err = s.call(func() error {
+		r, stale, err = s.Storage.GetStale(key)
+		return err
+	})
+
+	return r, stale, err
+}
+
+// breakerStorage.GetVersioned Returns io.Reader for a key with its version unless the breaker is open or error if it fails
+func (s *breakerStorage) GetVersioned(key string) (r io.Reader, version int64, err error) {
+	err = s.call(func() error {
+		r, version, err = s.Storage.GetVersioned(key)
+		return err
+	})
+
+	return r, version, err
+}
+
+// breakerStorage.GetAndTouch Returns io.Reader for a key sliding its expiration unless the breaker is open, or error if it fails
+func (s *breakerStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (r io.Reader, err error) {
+	err = s.call(func() error {
+		r, err = s.Storage.GetAndTouch(key, window, threshold)
+		return err
+	})
+
+	return r, err
+}
+
+// breakerStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, unless the breaker is open or error if it fails
+func (s *breakerStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) (value []byte, created bool, err error) {
+	err = s.call(func() error {
+		value, created, err = s.Storage.GetOrCreate(key, defaultValue, expiration)
+		return err
+	})
+
+	return value, created, err
+}
+
+// breakerStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold unless the breaker is open, returns whether it was saved or error if it fails
+func (s *breakerStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (saved bool, err error) {
+	err = s.call(func() error {
+		saved, err = s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// breakerStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue unless the breaker is open, returns whether it was saved or error if it fails
+func (s *breakerStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (saved bool, err error) {
+	err = s.call(func() error {
+		saved, err = s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// breakerStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists unless the breaker is open, returns whether it was saved or error if it fails
+func (s *breakerStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (saved bool, err error) {
+	err = s.call(func() error {
+		saved, err = s.Storage.PutIfEmpty(prefix, key, value, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// breakerStorage.PutIfVersion Saves an entry by key with timeout if its version is version unless the breaker is open, returns the new version or error if it fails
+func (s *breakerStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (newVersion int64, err error) {
+	err = s.call(func() error {
+		newVersion, err = s.Storage.PutIfVersion(key, value, version, expiration)
+		return err
+	})
+
+	return newVersion, err
+}
+
+// breakerStorage.DeleteIfVersion Deletes an entry by key if its version is version unless the breaker is open, returns error if it fails
+func (s *breakerStorage) DeleteIfVersion(key string, version int64) error {
+	return s.call(func() error {
+		return s.Storage.DeleteIfVersion(key, version)
+	})
+}
+
+// breakerStorage.Exists Returns whether a key exists unless the breaker is open or error if it fails
+func (s *breakerStorage) Exists(key string) (exists bool, err error) {
+	err = s.call(func() error {
+		exists, err = s.Storage.Exists(key)
+		return err
+	})
+
+	return exists, err
+}
+
+// breakerStorage.TTL Returns the time left before a key expires unless the breaker is open or error if it fails
+func (s *breakerStorage) TTL(key string) (ttl time.Duration, err error) {
+	err = s.call(func() error {
+		ttl, err = s.Storage.TTL(key)
+		return err
+	})
+
+	return ttl, err
+}
+
+// breakerStorage.ExistsMany Returns whether each key exists unless the breaker is open or error if it fails
+func (s *breakerStorage) ExistsMany(keys []string) (exists map[string]bool, err error) {
+	err = s.call(func() error {
+		exists, err = s.Storage.ExistsMany(keys)
+		return err
+	})
+
+	return exists, err
+}
+
+// breakerStorage.GetPattern Returns io.Reader for a pattern unless the breaker is open or error if it fails
+func (s *breakerStorage) GetPattern(pattern string, limit int, offset int) (r io.Reader, err error) {
+	err = s.call(func() error {
+		r, err = s.Storage.GetPattern(pattern, limit, offset)
+		return err
+	})
+
+	return r, err
+}
+
+// breakerStorage.Keys Returns the keys matching a pattern unless the breaker is open or error if it fails
+func (s *breakerStorage) Keys(pattern string) (keys []string, err error) {
+	err = s.call(func() error {
+		keys, err = s.Storage.Keys(pattern)
+		return err
+	})
+
+	return keys, err
+}
+
+// breakerStorage.CountPattern Returns count of entries matching a pattern unless the breaker is open or error if it fails
+func (s *breakerStorage) CountPattern(pattern string) (count int, err error) {
+	err = s.call(func() error {
+		count, err = s.Storage.CountPattern(pattern)
+		return err
+	})
+
+	return count, err
+}
+
+// breakerStorage.ExpirePattern Updates expiration of entries matching a pattern unless the breaker is open, returns count updated or error if it fails
+func (s *breakerStorage) ExpirePattern(pattern string, expiration time.Duration) (updated int, err error) {
+	err = s.call(func() error {
+		updated, err = s.Storage.ExpirePattern(pattern, expiration)
+		return err
+	})
+
+	return updated, err
+}
+
+// breakerStorage.Delete Deletes an entry by key unless the breaker is open, returns error if it fails
+func (s *breakerStorage) Delete(key string) error {
+	return s.call(func() error {
+		return s.Storage.Delete(key)
+	})
+}
+
+// breakerStorage.DeleteAll Deletes all entries unless the breaker is open, returns error if it fails
+func (s *breakerStorage) DeleteAll() error {
+	return s.call(func() error {
+		return s.Storage.DeleteAll()
+	})
+}
+
+// breakerStorage.PurgeExpired Deletes all expired entries unless the breaker is open, returns the keys purged or error if it fails
+func (s *breakerStorage) PurgeExpired() (purged []string, err error) {
+	err = s.call(func() error {
+		purged, err = s.Storage.PurgeExpired()
+		return err
+	})
+
+	return purged, err
+}
+
+// breakerStorage.Push Appends an element to the JSON array stored by key unless the breaker is open, returns error if it fails
+func (s *breakerStorage) Push(key string, element string) error {
+	return s.call(func() error {
+		return s.Storage.Push(key, element)
+	})
+}
+
+// breakerStorage.Pop Removes and returns the last element of the JSON array stored by key unless the breaker is open or error if it fails
+func (s *breakerStorage) Pop(key string) (element string, err error) {
+	err = s.call(func() error {
+		element, err = s.Storage.Pop(key)
+		return err
+	})
+
+	return element, err
+}
+
+// breakerStorage.Increment Adds delta to the integer stored by key unless the breaker is open, returns the new value or error if it fails
+func (s *breakerStorage) Increment(key string, delta int64) (counter int64, err error) {
+	err = s.call(func() error {
+		counter, err = s.Storage.Increment(key, delta)
+		return err
+	})
+
+	return counter, err
+}
+
+// breakerStorage.Decrement Subtracts delta from the integer stored by key unless the breaker is open, returns the new value or error if it fails
+func (s *breakerStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
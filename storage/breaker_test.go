@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+type failingStorage struct {
+	Storage
+
+	fail  bool
+	calls int
+}
+
+func (s *failingStorage) Get(key string) (io.Reader, error) {
+	s.calls++
+	if s.fail {
+		return nil, errors.New("backend down")
+	}
+
+	return s.Storage.Get(key)
+}
+
+func TestBreakerStorage_Transitions(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := memory.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	backend := &failingStorage{Storage: memory, fail: true}
+	storage := NewBreakerStorage(backend, 2, 50*time.Millisecond)
+
+	assertState := func(expected int) {
+		t.Helper()
+
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+
+		if storage.state != expected {
+			t.Fatalf("expected: %d, found : %d", expected, storage.state)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		assertState(breakerClosed)
+		if _, err := storage.Get("a key"); err == nil || storage.IsUnavailable(err) {
+			t.Fatalf("expected the backend error, found : %v", err)
+		}
+	}
+
+	assertState(breakerOpen)
+
+	if _, err := storage.Get("a key"); !storage.IsUnavailable(err) {
+		t.Fatalf("expected unavailable, found : %v", err)
+	}
+
+	if backend.calls != 2 {
+		t.Fatalf("expected: %d, found : %d", 2, backend.calls)
+	}
+
+	// a failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := storage.Get("a key"); err == nil || storage.IsUnavailable(err) {
+		t.Fatalf("expected the backend error, found : %v", err)
+	}
+
+	assertState(breakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	backend.fail = false
+
+	if err := storage.allow(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	assertState(breakerHalfOpen)
+
+	if _, err := storage.Get("another key"); !storage.IsUnavailable(err) {
+		t.Fatalf("expected unavailable while probing, found : %v", err)
+	}
+
+	storage.record(nil)
+
+	assertState(breakerClosed)
+
+	if _, err := storage.Get("a key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Get("missing key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	assertState(breakerClosed)
+}
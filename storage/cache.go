@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// CachedGetter is implemented by storages serving reads from a cache
+type CachedGetter interface {
+	// GetCached Returns io.Reader for a key, whether it was a cache hit, or error if it fails
+	GetCached(key string) (io.Reader, bool, error)
+}
+
+type cacheEntry struct {
+	key      string
+	value    []byte
+	cachedAt time.Time
+}
+
+type cacheStorage struct {
+	Storage
+
+	size   int
+	maxAge time.Duration
+
+	*lru
+}
+
+// lru is shared by a cache and its copies bound to a context
+type lru struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewCacheStorage Decorator for storage
+// keeps up to size values read from storage in a LRU cache for at most maxAge
+func NewCacheStorage(storage Storage, size int, maxAge time.Duration) *cacheStorage {
+	return &cacheStorage{
+		Storage: storage,
+		size:    size,
+		maxAge:  maxAge,
+		lru: &lru{
+			entries: map[string]*list.Element{},
+			order:   list.New(),
+		},
+	}
+}
+
+// cacheStorage.WithContext Returns the cache over storage bound to ctx, sharing the cached values
+func (s *cacheStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// cacheStorage.Rejected Returns the operations the decorated storage rejects
+func (s *cacheStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+func (s *cacheStorage) lookup(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Since(entry.cachedAt) > s.maxAge {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+
+	return entry.value, true
+}
+
+func (s *cacheStorage) store(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+	}
+
+	s.entries[key] = s.order.PushFront(&cacheEntry{key: key, value: value, cachedAt: time.Now()})
+
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (s *cacheStorage) invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+		delete(s.entries, key)
+	}
+}
+
+func (s *cacheStorage) invalidateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = map[string]*list.Element{}
+	s.order.Init()
+}
+
+// cacheStorage.GetCached Returns io.Reader for a key, whether it was a cache hit, or error if it fails
+func (s *cacheStorage) GetCached(key string) (io.Reader, bool, error) {
+	if value, ok := s.lookup(key); ok {
+		return bytes.NewReader(value), true, nil
+	}
+
+	r, err := s.Storage.Get(key)
+	if err != nil {
+		return r, false, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return bytes.NewReader(nil), false, err
+	}
+
+	s.store(key, value)
+
+	return bytes.NewReader(value), false, nil
+}
+
+// cacheStorage.Get Returns io.Reader for a key or error if it fails
+func (s *cacheStorage) Get(key string) (io.Reader, error) {
+	r, _, err := s.GetCached(key)
+
+	return r, err
+}
+
+// cacheStorage.GetMany Returns the values of the existing keys, cached ones from the cache, or error if it fails
+func (s *cacheStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// cacheStorage.Put Saves an entry by key with timeout invalidating its cached value, returns error if it fails
+func (s *cacheStorage) Put(key string, value string, expiration time.Duration) error {
+	defer s.invalidate(key)
+
+	return s.Storage.Put(key, value, expiration)
+}
+
+// cacheStorage.PutMany Saves every entry by key with timeout invalidating their cached values, returns a PutManyError of the keys that failed
+func (s *cacheStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	defer func() {
+		for key := range entries {
+			s.invalidate(key)
+		}
+	}()
+
+	return s.Storage.PutMany(entries, expiration)
+}
+
+// cacheStorage.PutWithMetadata Saves an entry by key with timeout along metadata invalidating its cached value, returns error if it fails
+func (s *cacheStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	defer s.invalidate(key)
+
+	return s.Storage.PutWithMetadata(key, value, metadata, expiration)
+}
+
+// cacheStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold invalidating its cached value, returns whether it was saved or error if it fails
+func (s *cacheStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+}
+
+// cacheStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue invalidating its cached value, returns whether it was saved or error if it fails
+func (s *cacheStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+}
+
+// cacheStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists invalidating its cached value, returns whether it was saved or error if it fails
+func (s *cacheStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfEmpty(prefix, key, value, expiration)
+}
+
+// cacheStorage.PutIfVersion Saves an entry by key with timeout if its version is version invalidating its cached value, returns the new version or error if it fails
+func (s *cacheStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfVersion(key, value, version, expiration)
+}
+
+// cacheStorage.DeleteIfVersion Deletes an entry by key if its version is version invalidating its cached value, returns error if it fails
+func (s *cacheStorage) DeleteIfVersion(key string, version int64) error {
+	defer s.invalidate(key)
+
+	return s.Storage.DeleteIfVersion(key, version)
+}
+
+// cacheStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing invalidating its cached value, and whether it was created or error if it fails
+func (s *cacheStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.GetOrCreate(key, defaultValue, expiration)
+}
+
+// cacheStorage.ExpirePattern Updates expiration of entries matching a pattern invalidating the cache, returns count updated or error if it fails
+func (s *cacheStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	defer s.invalidateAll()
+
+	return s.Storage.ExpirePattern(pattern, expiration)
+}
+
+// cacheStorage.Delete Deletes an entry by key invalidating its cached value, returns error if it fails
+func (s *cacheStorage) Delete(key string) error {
+	defer s.invalidate(key)
+
+	return s.Storage.Delete(key)
+}
+
+// cacheStorage.Push Appends an element to the JSON array stored by key invalidating its cached value, returns error if it fails
+func (s *cacheStorage) Push(key string, element string) error {
+	defer s.invalidate(key)
+
+	return s.Storage.Push(key, element)
+}
+
+// cacheStorage.Pop Removes and returns the last element of the JSON array stored by key invalidating its cached value or error if it fails
+func (s *cacheStorage) Pop(key string) (string, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.Pop(key)
+}
+
+// cacheStorage.Increment Adds delta to the integer stored by key invalidating its cached value, returns the new value or error if it fails
+func (s *cacheStorage) Increment(key string, delta int64) (int64, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.Increment(key, delta)
+}
+
+// cacheStorage.Decrement Subtracts delta from the integer stored by key invalidating its cached value, returns the new value or error if it fails
+func (s *cacheStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// cacheStorage.DeleteAll Deletes all entries invalidating the cache, returns error if it fails
+func (s *cacheStorage) DeleteAll() error {
+	defer s.invalidateAll()
+
+	return s.Storage.DeleteAll()
+}
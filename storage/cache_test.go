@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestCacheStorage_GetCached(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage := NewCacheStorage(memory, 1, time.Duration(time.Minute))
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, expected := range []bool{false, true} {
+		r, hit, err := storage.GetCached("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if hit != expected {
+			t.Fatalf("expected: %t, found : %t", expected, hit)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != "a value" {
+			t.Fatalf("expected: %s, found : %s", "a value", chk)
+		}
+	}
+
+	_, hit, err := storage.GetCached("another key")
+	if err != nil || hit {
+		t.Fatalf("expected miss, found : %t, %v", hit, err)
+	}
+
+	_, hit, err = storage.GetCached("a key")
+	if err != nil || hit {
+		t.Fatalf("expected miss after eviction, found : %t, %v", hit, err)
+	}
+
+	err = storage.Put("a key", "a new value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, hit, err := storage.GetCached("a key")
+	if err != nil || hit {
+		t.Fatalf("expected miss after put, found : %t, %v", hit, err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "a new value" {
+		t.Fatalf("expected: %s, found : %s", "a new value", chk)
+	}
+}
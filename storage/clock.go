@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"time"
+)
+
+// storageClock tells the time entries are written and expire by
+var storageClock = newClock()
+
+// clock Reads the wall clock once, advancing it by the time elapsed since on the monotonic clock,
+// so steps of the system clock, e.g. by NTP, neither expire entries early nor keep them late
+type clock struct {
+	start   time.Time
+	elapsed func() time.Duration
+}
+
+// newClock Returns a clock starting from the wall clock now
+func newClock() clock {
+	start := time.Now()
+
+	return clock{
+		start:   start.Round(0),
+		elapsed: func() time.Duration { return time.Since(start) },
+	}
+}
+
+// clock.now Returns the current time
+func (c clock) now() time.Time {
+	return c.start.Add(c.elapsed())
+}
+
+// now Returns the current time of the storage clock
+func now() time.Time {
+	return storageClock.now()
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock_Now(t *testing.T) {
+	c := newClock()
+
+	if drift := time.Since(c.now()); drift < 0 || drift > time.Second {
+		t.Fatalf("expected the wall clock, found : %s", c.now())
+	}
+}
+
+func TestMemoryStorage_ClockSteps(t *testing.T) {
+	defer func() {
+		storageClock = newClock()
+	}()
+
+	// the system clock stepped back, or forward, by step since the storage clock started
+	for _, step := range []time.Duration{-time.Hour, time.Hour} {
+		elapsed := time.Duration(0)
+		storageClock = clock{
+			start:   time.Now().Add(-step).Round(0),
+			elapsed: func() time.Duration { return elapsed },
+		}
+
+		tmpDir := boostrapMemory(t)
+
+		storage, err := NewMemoryStorage(tmpDir)
+
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		err = storage.Put("a key", "a value", time.Minute)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		elapsed = 30 * time.Second
+
+		if _, err := storage.Get("a key"); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		ttl, err := storage.TTL("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if ttl != 30*time.Second {
+			t.Fatalf("expected: %s, found : %s", 30*time.Second, ttl)
+		}
+
+		elapsed = 61 * time.Second
+
+		if _, err := storage.Get("a key"); !storage.IsNotExist(err) {
+			t.Fatalf("expected: %s, found : %v", errNotExists, err)
+		}
+
+		storage.Flush()
+	}
+}
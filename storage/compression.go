@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// every value is stored behind a flag recording whether it was compressed,
+// compressed values being gzip encoded in base64 to stay safe in JSON listings
+const (
+	compressionNone = 'r'
+	compressionGzip = 'z'
+)
+
+var errCompressed = errors.New("push, pop and increments are not supported on compressed values")
+
+type compressedStorage struct {
+	Storage
+
+	threshold int
+}
+
+// NewCompressedStorage Decorator for storage
+// compresses values of at least threshold bytes when it makes them smaller, storing smaller ones as they are
+func NewCompressedStorage(storage Storage, threshold int) *compressedStorage {
+	return &compressedStorage{
+		Storage:   storage,
+		threshold: threshold,
+	}
+}
+
+// compressedStorage.WithContext Returns the storage bound to ctx compressing values as this one
+func (s *compressedStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// compressedStorage.Rejected Returns the operations the decorated storage rejects
+func (s *compressedStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+// compress Returns value flagged as compressed if over threshold and smaller once compressed, as it is otherwise
+func (s *compressedStorage) compress(value string) (string, error) {
+	if len(value) < s.threshold {
+		return string(compressionNone) + value, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressionGzip)
+
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	zw := gzip.NewWriter(encoder)
+	if _, err := zw.Write([]byte(value)); err != nil {
+		return "", err
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	if buf.Len() > len(value) {
+		return string(compressionNone) + value, nil
+	}
+
+	return buf.String(), nil
+}
+
+// decompress Returns the original of a value flagged by compress
+func (s *compressedStorage) decompress(key string, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("cannot decompress value (%s): missing compression flag", key)
+	}
+
+	switch value[0] {
+	case compressionNone:
+		return value[1:], nil
+	case compressionGzip:
+		zr, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(value[1:])))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress value (%s): %s", key, err)
+		}
+
+		plain, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress value (%s): %s", key, err)
+		}
+
+		return plain, nil
+	}
+
+	return nil, fmt.Errorf("cannot decompress value (%s): unknown compression flag %q", key, value[0])
+}
+
+// decompressReader Returns io.Reader for the original of the value read from r
+func (s *compressedStorage) decompressReader(key string, r io.Reader) (io.Reader, error) {
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := s.decompress(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(plain), nil
+}
+
+// compressedStorage.Put Saves an entry by key with timeout compressing its value if worth it, returns error if it fails
+func (s *compressedStorage) Put(key string, value string, expiration time.Duration) error {
+	stored, err := s.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.Put(key, stored, expiration)
+}
+
+// compressedStorage.PutMany Saves every entry by key with timeout compressing values above the threshold, returns a PutManyError of the keys that failed
+func (s *compressedStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// compressedStorage.PutWithMetadata Saves an entry by key with timeout along metadata compressing its value, returns error if it fails
+func (s *compressedStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	stored, err := s.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.PutWithMetadata(key, stored, metadata, expiration)
+}
+
+// compressedStorage.PutIfTTLBelow Saves an entry by key with timeout compressing its value if its remaining TTL is under threshold, returns whether it was saved or error if it fails
+func (s *compressedStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	stored, err := s.compress(value)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Storage.PutIfTTLBelow(key, stored, threshold, expiration)
+}
+
+// compressedStorage.CompareAndSwap Saves an entry by key with timeout compressing its value if its original value is oldValue, returns whether it was saved or error if it fails
+func (s *compressedStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	stored, err := s.compress(newValue)
+	if err != nil {
+		return false, err
+	}
+
+	return compareAndSwapStored(s.Storage, key, oldValue, stored, expiration, s.decompress)
+}
+
+// compressedStorage.PutIfEmpty Saves an entry by key with timeout compressing its value if no key starting with prefix exists, returns whether it was saved or error if it fails
+func (s *compressedStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	stored, err := s.compress(value)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Storage.PutIfEmpty(prefix, key, stored, expiration)
+}
+
+// compressedStorage.PutIfVersion Saves an entry by key with timeout compressing its value if its version is version, returns the new version or error if it fails
+func (s *compressedStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	stored, err := s.compress(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Storage.PutIfVersion(key, stored, version, expiration)
+}
+
+// compressedStorage.Get Returns io.Reader for the decompressed value of a key or error if it fails
+func (s *compressedStorage) Get(key string) (io.Reader, error) {
+	r, err := s.Storage.Get(key)
+	if err != nil {
+		return r, err
+	}
+
+	return s.decompressReader(key, r)
+}
+
+// compressedStorage.GetMany Returns the decompressed values of the existing keys or error if it fails
+func (s *compressedStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// compressedStorage.GetStale Returns io.Reader for the decompressed value of a key, whether it is stale or error if it fails
+func (s *compressedStorage) GetStale(key string) (io.Reader, bool, error) {
+	r, stale, err := s.Storage.GetStale(key)
+	if err != nil {
+		return r, stale, err
+	}
+
+	r, err = s.decompressReader(key, r)
+
+	return r, stale, err
+}
+
+// compressedStorage.GetVersioned Returns io.Reader for the decompressed value of a key with its version or error if it fails
+func (s *compressedStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	r, version, err := s.Storage.GetVersioned(key)
+	if err != nil {
+		return r, version, err
+	}
+
+	r, err = s.decompressReader(key, r)
+
+	return r, version, err
+}
+
+// compressedStorage.GetAndTouch Returns io.Reader for the decompressed value of a key sliding its expiration, or error if it fails
+func (s *compressedStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	r, err := s.Storage.GetAndTouch(key, window, threshold)
+	if err != nil {
+		return r, err
+	}
+
+	return s.decompressReader(key, r)
+}
+
+// compressedStorage.GetOrCreate Returns decompressed value for a key, creating it with defaultValue compressed if missing, or error if it fails
+func (s *compressedStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	stored, err := s.compress(defaultValue)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, created, err := s.Storage.GetOrCreate(key, stored, expiration)
+	if err != nil {
+		return value, created, err
+	}
+
+	plain, err := s.decompress(key, value)
+
+	return plain, created, err
+}
+
+// compressedStorage.GetPattern Returns io.Reader for the listing of entries matching a pattern with decompressed values or error if it fails
+func (s *compressedStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	r, err := s.Storage.GetPattern(pattern, limit, offset)
+	if err != nil {
+		return r, err
+	}
+
+	var listing []map[string]string
+	if err := json.NewDecoder(r).Decode(&listing); err != nil {
+		return nil, err
+	}
+
+	ret := make([]entry, 0, len(listing))
+	for _, item := range listing {
+		for key, value := range item {
+			plain, err := s.decompress(key, []byte(value))
+			if err != nil {
+				return nil, err
+			}
+
+			ret = append(ret, entry{Key: key, Value: plain})
+		}
+	}
+
+	listed, err := patternReader(ret)
+
+	return consistentAs(r, listed), err
+}
+
+// compressedStorage.Push Fails, elements of compressed values cannot be appended in place
+func (s *compressedStorage) Push(key string, element string) error {
+	return errCompressed
+}
+
+// compressedStorage.Pop Fails, elements of compressed values cannot be removed in place
+func (s *compressedStorage) Pop(key string) (string, error) {
+	return "", errCompressed
+}
+
+// compressedStorage.Increment Fails, compressed values cannot be incremented in place
+func (s *compressedStorage) Increment(key string, delta int64) (int64, error) {
+	return 0, errCompressed
+}
+
+// compressedStorage.Decrement Fails, compressed values cannot be decremented in place
+func (s *compressedStorage) Decrement(key string, delta int64) (int64, error) {
+	return 0, errCompressed
+}
+
+// compressedStorage.IsConflict Checks if error is a conflict with the stored value
+func (s *compressedStorage) IsConflict(err error) bool {
+	return err == errCompressed || s.Storage.IsConflict(err)
+}
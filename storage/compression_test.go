@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedStorage_Threshold(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage := NewCompressedStorage(memory, 64)
+
+	values := map[string]string{
+		"small key": "a value",
+		"large key": strings.Repeat("a repetitive value ", 100),
+	}
+
+	for key, value := range values {
+		if err := storage.Put(key, value, time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for key, flag := range map[string]byte{"small key": compressionNone, "large key": compressionGzip} {
+		r, err := memory.Get(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		stored, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if stored[0] != flag {
+			t.Fatalf("expected: %c, found : %c", flag, stored[0])
+		}
+
+		if flag == compressionGzip && len(stored) >= len(values[key]) {
+			t.Fatalf("expected compressed value shorter than %d, found : %d", len(values[key]), len(stored))
+		}
+
+		r, err = storage.Get(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != values[key] {
+			t.Fatalf("expected: %s, found : %s", values[key], chk)
+		}
+	}
+
+	r, err := storage.GetPattern("small*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"small key":"a value"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// values are sealed with AES-256-GCM under a key derived per entry from the master key, the key name and a random salt
+const (
+	encryptionSaltSize = 16
+	encryptionKeySize  = 32
+	encryptionInfo     = "keyvaluestorage value: "
+)
+
+var errEncrypted = errors.New("push, pop and increments are not supported on encrypted values")
+
+type encryptedStorage struct {
+	Storage
+
+	masterKey []byte
+}
+
+// NewEncryptedStorage Decorator for storage
+// stores values encrypted with a key derived by HKDF from masterKey and the key name,
+// keeping only the salt and nonce along with the ciphertext
+func NewEncryptedStorage(storage Storage, masterKey []byte) (*encryptedStorage, error) {
+	if len(masterKey) < encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be at least %d bytes", encryptionKeySize)
+	}
+
+	return &encryptedStorage{
+		Storage:   storage,
+		masterKey: masterKey,
+	}, nil
+}
+
+// encryptedStorage.WithContext Returns the storage bound to ctx encrypting with the same key
+func (s *encryptedStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// encryptedStorage.Rejected Returns the operations the decorated storage rejects
+func (s *encryptedStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+func (s *encryptedStorage) aead(key string, salt []byte) (cipher.AEAD, error) {
+	derived, err := hkdf.Key(sha256.New, s.masterKey, salt, encryptionInfo+key, encryptionKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt Returns base64 of salt, nonce and ciphertext of value sealed for key
+func (s *encryptedStorage) encrypt(key string, value []byte) (string, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	aead, err := s.aead(key, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(salt, nonce...)
+	sealed = aead.Seal(sealed, nonce, value, []byte(key))
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt Returns the plaintext of a value sealed for key, or error if it was tampered with
+func (s *encryptedStorage) decrypt(key string, value []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode encrypted value (%s): %s", key, err)
+	}
+
+	if len(sealed) < encryptionSaltSize {
+		return nil, fmt.Errorf("cannot decrypt value (%s): too short", key)
+	}
+
+	aead, err := s.aead(key, sealed[:encryptionSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[encryptionSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("cannot decrypt value (%s): too short", key)
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt value (%s): %s", key, err)
+	}
+
+	return plain, nil
+}
+
+// encryptedStorage.Put Saves an entry by key with timeout encrypting its value, returns error if it fails
+func (s *encryptedStorage) Put(key string, value string, expiration time.Duration) error {
+	sealed, err := s.encrypt(key, []byte(value))
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.Put(key, sealed, expiration)
+}
+
+// encryptedStorage.PutMany Saves every entry by key with timeout encrypted, returns a PutManyError of the keys that failed
+func (s *encryptedStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// encryptedStorage.PutWithMetadata Saves an entry by key with timeout encrypting its value, metadata being stored in clear, returns error if it fails
+func (s *encryptedStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	sealed, err := s.encrypt(key, []byte(value))
+	if err != nil {
+		return err
+	}
+
+	return s.Storage.PutWithMetadata(key, sealed, metadata, expiration)
+}
+
+// encryptedStorage.PutIfTTLBelow Saves an entry by key with timeout encrypting its value if its remaining TTL is under threshold, returns whether it was saved or error if it fails
+func (s *encryptedStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	sealed, err := s.encrypt(key, []byte(value))
+	if err != nil {
+		return false, err
+	}
+
+	return s.Storage.PutIfTTLBelow(key, sealed, threshold, expiration)
+}
+
+// encryptedStorage.CompareAndSwap Saves an entry by key with timeout encrypting its value if its decrypted value is oldValue, returns whether it was saved or error if it fails
+func (s *encryptedStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	sealed, err := s.encrypt(key, []byte(newValue))
+	if err != nil {
+		return false, err
+	}
+
+	return compareAndSwapStored(s.Storage, key, oldValue, sealed, expiration, s.decrypt)
+}
+
+// encryptedStorage.PutIfEmpty Saves an entry by key with timeout encrypting its value if no key starting with prefix exists, returns whether it was saved or error if it fails
+func (s *encryptedStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	sealed, err := s.encrypt(key, []byte(value))
+	if err != nil {
+		return false, err
+	}
+
+	return s.Storage.PutIfEmpty(prefix, key, sealed, expiration)
+}
+
+// encryptedStorage.PutIfVersion Saves an entry by key with timeout encrypting its value if its version is version, returns the new version or error if it fails
+func (s *encryptedStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	sealed, err := s.encrypt(key, []byte(value))
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Storage.PutIfVersion(key, sealed, version, expiration)
+}
+
+// encryptedStorage.Get Returns io.Reader for the decrypted value of a key or error if it fails
+func (s *encryptedStorage) Get(key string) (io.Reader, error) {
+	r, err := s.Storage.Get(key)
+	if err != nil {
+		return r, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := s.decrypt(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(plain), nil
+}
+
+// encryptedStorage.GetMany Returns the decrypted values of the existing keys or error if it fails
+func (s *encryptedStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// encryptedStorage.GetStale Returns io.Reader for the decrypted value of a key, whether it is stale or error if it fails
+func (s *encryptedStorage) GetStale(key string) (io.Reader, bool, error) {
+	r, stale, err := s.Storage.GetStale(key)
+	if err != nil {
+		return r, stale, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, stale, err
+	}
+
+	plain, err := s.decrypt(key, value)
+	if err != nil {
+		return nil, stale, err
+	}
+
+	return bytes.NewReader(plain), stale, nil
+}
+
+// encryptedStorage.GetVersioned Returns io.Reader for the decrypted value of a key with its version or error if it fails
+func (s *encryptedStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	r, version, err := s.Storage.GetVersioned(key)
+	if err != nil {
+		return r, version, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	plain, err := s.decrypt(key, value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.NewReader(plain), version, nil
+}
+
+// encryptedStorage.GetAndTouch Returns io.Reader for the decrypted value of a key sliding its expiration, or error if it fails
+func (s *encryptedStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	r, err := s.Storage.GetAndTouch(key, window, threshold)
+	if err != nil {
+		return r, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := s.decrypt(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(plain), nil
+}
+
+// encryptedStorage.GetOrCreate Returns decrypted value for a key, creating it with defaultValue encrypted if missing, or error if it fails
+func (s *encryptedStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	sealed, err := s.encrypt(key, []byte(defaultValue))
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, created, err := s.Storage.GetOrCreate(key, sealed, expiration)
+	if err != nil {
+		return value, created, err
+	}
+
+	plain, err := s.decrypt(key, value)
+
+	return plain, created, err
+}
+
+// encryptedStorage.GetPattern Returns io.Reader for the listing of entries matching a pattern with decrypted values or error if it fails
+func (s *encryptedStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	r, err := s.Storage.GetPattern(pattern, limit, offset)
+	if err != nil {
+		return r, err
+	}
+
+	var listing []map[string]string
+	if err := json.NewDecoder(r).Decode(&listing); err != nil {
+		return nil, err
+	}
+
+	ret := make([]entry, 0, len(listing))
+	for _, item := range listing {
+		for key, value := range item {
+			plain, err := s.decrypt(key, []byte(value))
+			if err != nil {
+				return nil, err
+			}
+
+			ret = append(ret, entry{Key: key, Value: plain})
+		}
+	}
+
+	listed, err := patternReader(ret)
+
+	return consistentAs(r, listed), err
+}
+
+// encryptedStorage.Push Fails, elements of encrypted values cannot be appended in place
+func (s *encryptedStorage) Push(key string, element string) error {
+	return errEncrypted
+}
+
+// encryptedStorage.Pop Fails, elements of encrypted values cannot be removed in place
+func (s *encryptedStorage) Pop(key string) (string, error) {
+	return "", errEncrypted
+}
+
+// encryptedStorage.Increment Fails, encrypted values cannot be incremented in place
+func (s *encryptedStorage) Increment(key string, delta int64) (int64, error) {
+	return 0, errEncrypted
+}
+
+// encryptedStorage.Decrement Fails, encrypted values cannot be decremented in place
+func (s *encryptedStorage) Decrement(key string, delta int64) (int64, error) {
+	return 0, errEncrypted
+}
+
+// encryptedStorage.IsConflict Checks if error is a conflict with the stored value
+func (s *encryptedStorage) IsConflict(err error) bool {
+	return err == errEncrypted || s.Storage.IsConflict(err)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestEncryptedStorage_PerKeyCiphertexts(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage, err := NewEncryptedStorage(memory, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"a key", "another key"} {
+		err = storage.Put(key, "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	ciphertexts := map[string]bool{}
+	for _, key := range []string{"a key", "another key"} {
+		r, err := memory.Get(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if bytes.Contains(chk, []byte("a value")) || ciphertexts[string(chk)] {
+			t.Fatalf("unexpected ciphertext: %s", chk)
+		}
+
+		ciphertexts[string(chk)] = true
+
+		r, err = storage.Get(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err = ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != "a value" {
+			t.Fatalf("expected: %s, found : %s", "a value", chk)
+		}
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"a key":"a value"},{"another key":"a value"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestEncryptedStorage_SwappedValue(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage, err := NewEncryptedStorage(memory, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := memory.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = memory.Put("another key", string(sealed), time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("another key")
+	if err == nil {
+		t.Fatalf("err expected decrypting a value sealed for another key")
+	}
+
+	_, err = NewEncryptedStorage(memory, []byte("short"))
+	if err == nil {
+		t.Fatalf("err expected with a short master key")
+	}
+}
+
+func TestEncryptedStorage_CompareAndSwap(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage, err := NewEncryptedStorage(memory, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// values are compared decrypted, the ciphertexts of a value differing
+	if swapped, err := storage.CompareAndSwap("a key", "another value", "a new value", time.Duration(-1)); err != nil || swapped {
+		t.Fatalf("expected: %v, found : %v (%v)", false, swapped, err)
+	}
+
+	if swapped, err := storage.CompareAndSwap("a key", "a value", "a new value", time.Duration(-1)); err != nil || !swapped {
+		t.Fatalf("expected: %v, found : %v (%v)", true, swapped, err)
+	}
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(value) != "a new value" {
+		t.Fatalf("expected: %s, found : %s", "a new value", value)
+	}
+}
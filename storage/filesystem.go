@@ -8,23 +8,120 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// consecutive write failures after which the fs storage switches to its fallback dir
+const fallbackAfterFailures = 3
+
 type fileSystemStorage struct {
-	storageDir string
-	locks      map[string]*sync.Mutex
+	dirMu         sync.RWMutex
+	storageDir    string
+	fallbackDir   string
+	writeFailures int
+
+	typedKeys      bool
+	creationTimes  bool
+	maxAge         time.Duration
+	grace          time.Duration
+	patternReaders int
+	relaxedScans   bool
+
+	locks       *keyedLocker
+	maintenance *maintenance
+	eviction    *evictionRoute
+	index       *keyIndex
+	opLog       *opLog
+	quit        chan bool
 }
 
 // NewFileSystemStorage Factory for fs storage
 // saves db to `storageDir/*`
-func NewFileSystemStorage(storageDir string) (*fileSystemStorage, error) {
-	return &fileSystemStorage{
-		storageDir: storageDir,
-		locks:      map[string]*sync.Mutex{},
-	}, nil
+func NewFileSystemStorage(storageDir string, options ...OptionFn) (*fileSystemStorage, error) {
+	config := newConfig(options)
+
+	for _, dir := range []string{storageDir, config.fallbackDir} {
+		if err := checkStorageDir(dir); dir != "" && err != nil {
+			return nil, err
+		}
+	}
+
+	storage := &fileSystemStorage{
+		storageDir:  storageDir,
+		fallbackDir: config.fallbackDir,
+		typedKeys:   config.typedKeys,
+		maxAge:      config.maxAge,
+		grace:       config.expiryGrace,
+		locks:       newKeyedLocker(config.fifoWrites),
+		maintenance: newMaintenance(config.maxMaintenance),
+		eviction:    &evictionRoute{},
+
+		patternReaders: config.patternReaders,
+		relaxedScans:   config.relaxedScans,
+		creationTimes:  config.creationTimes,
+	}
+
+	if config.opLog != "" {
+		if err := checkOpLogPath(config.opLog, storageDir); err != nil {
+			return nil, err
+		}
+
+		opLog, err := openOpLog(config.opLog)
+		if err != nil {
+			return nil, err
+		}
+
+		storage.opLog = opLog
+	}
+
+	if !config.keyIndex && config.evictionInterval <= 0 {
+		return storage, nil
+	}
+
+	if config.keyIndex {
+		storage.index = newKeyIndex()
+
+		if err := storage.reconcileIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	storage.quit = make(chan bool)
+
+	go func() {
+		// a nil channel never receives, leaving off what is not configured
+		var reconcile, eviction <-chan time.Time
+
+		if config.keyIndex {
+			ticker := time.NewTicker(config.reconcileInterval)
+			defer ticker.Stop()
+
+			reconcile = ticker.C
+		}
+
+		if config.evictionInterval > 0 {
+			ticker := time.NewTicker(config.evictionInterval)
+			defer ticker.Stop()
+
+			eviction = ticker.C
+		}
+
+		for {
+			select {
+			case <-reconcile:
+				storage.maintenance.run("fs storage key index", storage.reconcileIndex)
+			case <-eviction:
+				storage.maintenance.run("fs storage eviction", storage.evict)
+			case <-storage.quit:
+				return
+			}
+		}
+	}()
+
+	return storage, nil
 }
 
 // fileSystemStorage.Type Returns type of the storage
@@ -41,74 +138,685 @@ func (s *fileSystemStorage) IsNotExist(err error) bool {
 	return err == errNotExists
 }
 
-func (s *fileSystemStorage) lockAll() {
-	for key := range s.locks {
-		s.locks[key].Lock()
+// fileSystemStorage.IsConflict Returns if err is for an operation incompatible with the stored value
+func (s *fileSystemStorage) IsConflict(err error) bool {
+	return isConflict(err)
+}
+
+// fileSystemStorage.IsVersionMismatch Returns if err is for a conditional write against another version of the entry
+func (s *fileSystemStorage) IsVersionMismatch(err error) bool {
+	return err == errVersionMismatch
+}
+
+// fileSystemStorage.IsForbidden Returns if err is for an operation not allowed on the storage, none is
+func (s *fileSystemStorage) IsForbidden(err error) bool {
+	return false
+}
+
+// fileSystemStorage.IsUnavailable Returns if err is for the storage being unavailable, it never is
+func (s *fileSystemStorage) IsUnavailable(err error) bool {
+	return false
+}
+
+// fileSystemStorage.HeldLocks Returns currently held key locks with how long they have been held
+func (s *fileSystemStorage) HeldLocks() map[string]time.Duration {
+	return s.locks.Held()
+}
+
+// fileSystemStorage.Health Reports the storage degraded once it switched to its fallback dir
+func (s *fileSystemStorage) Health() Health {
+	if dir := s.dir(); s.fallbackDir != "" && dir == s.fallbackDir {
+		return Health{Degraded: true, Reason: fmt.Sprintf("fs storage switched to fallback dir %s", dir)}
 	}
+
+	return Health{}
 }
 
-func (s *fileSystemStorage) unlockAll() {
-	for key := range s.locks {
-		s.locks[key].Unlock()
+// fileSystemStorage.Get Returns io.Reader for a key or error if it fails
+func (s *fileSystemStorage) Get(key string) (io.Reader, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	entry, err := s.getEntry(key)
+	if err != nil {
+		return bytes.NewReader(nil), err
 	}
+
+	return bytes.NewReader(entry.Value), nil
+}
+
+// fileSystemStorage.GetMany Returns the values of the existing keys or error if it fails
+func (s *fileSystemStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
 }
 
-func (s *fileSystemStorage) lock(key string) {
-	if _, ok := s.locks[key]; !ok {
-		s.locks[key] = &sync.Mutex{}
+// fileSystemStorage.GetStale Returns io.Reader for a key, still served during the grace period after it expired,
+// whether it is stale for having expired or error if it fails
+func (s *fileSystemStorage) GetStale(key string) (io.Reader, bool, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	entry, stale, err := s.getStaleEntry(key)
+	if err != nil {
+		return bytes.NewReader(nil), false, err
 	}
 
-	s.locks[key].Lock()
+	return bytes.NewReader(entry.Value), stale, nil
 }
 
-func (s *fileSystemStorage) unlock(key string) {
-	s.locks[key].Unlock()
+// fileSystemStorage.GetVersioned Returns io.Reader for a key with its version or error if it fails
+func (s *fileSystemStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	entry, err := s.getEntry(key)
+	if err != nil {
+		return bytes.NewReader(nil), 0, err
+	}
+
+	return bytes.NewReader(entry.Value), entry.Version, nil
 }
 
-// fileSystemStorage.Get Returns io.Reader for a key or error if it fails
-func (s *fileSystemStorage) Get(key string) (io.Reader, error) {
-	r := bytes.NewReader(nil)
+// fileSystemStorage.GetMetadata Returns the metadata stored along the value of a key, with its creation time if recorded,
+// stale ones included, or error if it fails
+func (s *fileSystemStorage) GetMetadata(key string) (Metadata, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
 
-	s.lock(key)
-	defer s.unlock(key)
+	entry, _, err := s.getStaleEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return withCreatedAt(s.creationTimes, entry), nil
+}
 
-	key = md5Hash(key)
+// fileSystemStorage.GetAndTouch Returns io.Reader for a key sliding its expiration window from now if less than threshold is left, or error if it fails
+func (s *fileSystemStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
 
-	b, err := s.getStorageData(key)
+	current, err := s.getEntry(key)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	// the entry is rewritten only when slid, threshold bounds the writes
+	if expiration, slid := slideExpiration(current.Expiration, window, threshold); slid {
+		current.Expiration = expiration
+		if err := s.putEntry(current); err != nil {
+			return bytes.NewReader(nil), err
+		}
+	}
+
+	return bytes.NewReader(current.Value), nil
+}
+
+// fileSystemStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, and whether it was created or error if it fails
+func (s *fileSystemStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == nil {
+		return current.Value, false, nil
+	} else if err != errNotExists {
+		return nil, false, err
+	}
+
+	newEntry := entry{
+		Key:        key,
+		Value:      []byte(defaultValue),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    now().UnixNano(),
+		Version:    1,
+	}
+
+	if err := s.putEntry(newEntry); err != nil {
+		return nil, false, err
+	}
+
+	return newEntry.Value, true, nil
+}
+
+// fileSystemStorage.PutIfTTLBelow Saves an entry by key with timeout only if missing or its remaining TTL is under threshold,
+// keeping its metadata, returns whether it was saved or error if it fails
+func (s *fileSystemStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == nil && !ttlBelow(current.Expiration, threshold) {
+		return false, nil
+	} else if err == errNotExists {
+		current = entry{Key: key}
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return false, err
+	}
+
+	newEntry := entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   current.Metadata,
+	}
+
+	if err := s.putEntry(newEntry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fileSystemStorage.CompareAndSwap Saves an entry by key with timeout only if its value is oldValue, keeping its metadata, returns whether it was saved or error if it fails
+func (s *fileSystemStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == errNotExists {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if string(current.Value) != oldValue {
+		return false, nil
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return false, err
+	}
+
+	newEntry := entry{
+		Key:        key,
+		Value:      []byte(newValue),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   current.Metadata,
+	}
+
+	if err := s.putEntry(newEntry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fileSystemStorage.PutIfVersion Saves an entry by key with timeout only if its version is version, 0 for a missing key, keeping its metadata,
+// returns the new version or error if it fails
+func (s *fileSystemStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == errNotExists {
+		current = entry{Key: key}
+	} else if err != nil {
+		return 0, err
+	}
+
+	if current.Version != version {
+		return 0, errVersionMismatch
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return 0, err
+	}
+
+	newEntry := entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    version + 1,
+		Metadata:   current.Metadata,
+	}
+
+	if err := s.putEntry(newEntry); err != nil {
+		return 0, err
+	}
+
+	return newEntry.Version, nil
+}
+
+// fileSystemStorage.PutIfEmpty Saves an entry by key with timeout only if no key starting with prefix exists, key being one of them,
+// returns whether it was saved or error if it fails
+func (s *fileSystemStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	keys, err := s.getAllStorageKeys()
 	if err != nil {
-		return r, err
+		return false, err
+	}
+
+	for _, storageKey := range keys {
+		b, err := s.getStorageData(storageKey)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		var metadata entryMetadata
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(metadata.Key, prefix) && !s.expired(metadata.Expiration, metadata.Created) {
+			return false, nil
+		}
+	}
+
+	newEntry := entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    now().UnixNano(),
+		Version:    1,
+	}
+
+	if err := s.putEntry(newEntry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *fileSystemStorage) exists(key string) (bool, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	b, err := s.getStorageData(md5Hash(key))
+	if err == errNotExists {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if len(b) == 0 {
+		return false, nil
+	}
+
+	var metadata entryMetadata
+	err = json.Unmarshal(b, &metadata)
+	if err != nil {
+		return false, err
+	}
+
+	return !s.expired(metadata.Expiration, metadata.Created), nil
+}
+
+// fileSystemStorage.Exists Returns whether a key exists and is not expired, peeking its expiration only, or error if it fails
+func (s *fileSystemStorage) Exists(key string) (bool, error) {
+	return s.exists(key)
+}
+
+// fileSystemStorage.TTL Returns the time left before a key expires, -1 if it never does, peeking its expiration only, or error if it fails
+func (s *fileSystemStorage) TTL(key string) (time.Duration, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	b, err := s.getStorageData(md5Hash(key))
+	if err != nil {
+		return 0, err
 	}
 
 	if len(b) == 0 {
-		return r, errNotExists
+		return 0, errNotExists
+	}
+
+	var metadata entryMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return 0, err
+	}
+
+	if s.expired(metadata.Expiration, metadata.Created) {
+		return 0, errNotExists
+	}
+
+	return remainingTTL(metadata.Expiration, metadata.Created, s.maxAge), nil
+}
+
+// fileSystemStorage.ExistsMany Returns whether each key exists or error if it fails
+func (s *fileSystemStorage) ExistsMany(keys []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		ok, err := s.exists(key)
+		if err != nil {
+			return nil, err
+		}
+
+		exists[key] = ok
+	}
+
+	return exists, nil
+}
+
+// fileSystemStorage.Get Returns io.Reader for a pattern or error if it fails, skipping offset entries and
+// returning at most limit of them unless limit is 0: pages follow the key order
+func (s *fileSystemStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	listing, _, err := s.getPattern(pattern, limit, offset, false)
+
+	return listing, err
+}
+
+// fileSystemStorage.GetPatternCounted Returns the page of the listing of a pattern along how many entries match it,
+// counted by the same scan, or error if it fails
+func (s *fileSystemStorage) GetPatternCounted(pattern string, limit int, offset int) (io.Reader, int, error) {
+	return s.getPattern(pattern, limit, offset, true)
+}
+
+// getPattern Returns the page of the listing of a pattern, along how many entries match it if count
+func (s *fileSystemStorage) getPattern(pattern string, limit int, offset int, count bool) (io.Reader, int, error) {
+	if s.relaxedScans {
+		return s.getPatternRelaxed(pattern, limit, offset, count)
 	}
 
+	var listing io.Reader = bytes.NewReader(nil)
+	var total int
+	err := s.scan(func() error {
+		keys, err := s.getPatternStorageKeys(pattern)
+		if err != nil {
+			return err
+		}
+
+		var page []entry
+		page, total = s.readPatternPage(pattern, keys, limit, offset, count)
+		listing, err = patternReader(page)
+
+		return err
+	})
+
+	return listing, total, err
+}
+
+// scan Runs fn sharing every key with readers and writers of single keys, running it again excluding
+// every key if a write overlapped it, so that scans are consistent without blocking each other
+func (s *fileSystemStorage) scan(fn func() error) error {
+	s.locks.RLockAll()
+	done := s.locks.WritesDone()
+	err := fn()
+	quiet := s.locks.QuietSince(done)
+	s.locks.RUnlockAll()
+
+	if quiet {
+		return err
+	}
+
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	return fn()
+}
+
+// getPatternRelaxed Returns the listing of a pattern scanned along writes of single keys,
+// marked as inconsistent if any of them overlapped the scan
+func (s *fileSystemStorage) getPatternRelaxed(pattern string, limit int, offset int, count bool) (io.Reader, int, error) {
+	s.locks.RLockAll()
+	defer s.locks.RUnlockAll()
+
+	done := s.locks.WritesDone()
+
+	keys, err := s.getPatternStorageKeys(pattern)
+	if err != nil {
+		return bytes.NewReader(nil), 0, err
+	}
+
+	page, total := s.readPatternPage(pattern, keys, limit, offset, count)
+	listing, err := patternReader(page)
+	if err != nil || s.locks.QuietSince(done) {
+		return listing, total, err
+	}
+
+	return inconsistentReader{listing}, total, nil
+}
+
+// readPatternPage Returns the unexpired entries matching pattern stored under keys, by key, skipping offset
+// entries and returning at most limit of them unless limit is 0, along how many match in all if count:
+// with KeyIndex, keys are ordered by key and no further file is read once limit are found unless counting,
+// otherwise every file is read to sort the entries; no file is read twice
+func (s *fileSystemStorage) readPatternPage(pattern string, keys []string, limit int, offset int, count bool) ([]entry, int) {
+	if limit <= 0 && offset <= 0 {
+		entries := s.readPatternEntries(pattern, keys)
+
+		return entries, len(entries)
+	}
+
+	if s.index == nil {
+		entries := s.readPatternEntries(pattern, keys)
+		total := len(entries)
+
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+
+		if offset >= len(entries) {
+			return []entry{}, total
+		}
+
+		entries = entries[offset:]
+		if limit > 0 && limit < len(entries) {
+			entries = entries[:limit]
+		}
+
+		return entries, total
+	}
+
+	ret := make([]entry, 0)
+	total := 0
+	for _, key := range keys {
+		entry, ok := s.readPatternEntry(pattern, key)
+		if !ok {
+			continue
+		}
+
+		total++
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		// past the page, entries are only counted
+		if limit > 0 && len(ret) == limit {
+			continue
+		}
+
+		ret = append(ret, entry)
+		if !count && len(ret) == limit {
+			break
+		}
+	}
+
+	return ret, total
+}
+
+// readPatternEntries Returns the unexpired entries matching pattern stored under keys,
+// reading up to patternReaders files at once
+func (s *fileSystemStorage) readPatternEntries(pattern string, keys []string) []entry {
+	entries := make([]entry, len(keys))
+	found := make([]bool, len(keys))
+
+	readers := s.patternReaders
+	if readers > len(keys) {
+		readers = len(keys)
+	}
+
+	if readers <= 1 {
+		for i, key := range keys {
+			entries[i], found[i] = s.readPatternEntry(pattern, key)
+		}
+	} else {
+		next := make(chan int)
+
+		var wg sync.WaitGroup
+		for w := 0; w < readers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// every reader fills slots of its own
+				for i := range next {
+					entries[i], found[i] = s.readPatternEntry(pattern, keys[i])
+				}
+			}()
+		}
+
+		for i := range keys {
+			next <- i
+		}
+
+		close(next)
+		wg.Wait()
+	}
+
+	ret := make([]entry, 0, len(keys))
+	for i, entry := range entries {
+		if found[i] {
+			ret = append(ret, entry)
+		}
+	}
+
+	return ret
+}
+
+// readPatternEntry Returns the entry stored under key, and whether it is unexpired and matches pattern
+func (s *fileSystemStorage) readPatternEntry(pattern string, key string) (entry, bool) {
 	var entry entry
-	err = json.Unmarshal(b, &entry)
+
+	b, err := s.getStorageData(key)
+	if err != nil || len(b) == 0 {
+		return entry, false
+	}
+
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return entry, false
+	}
+
+	if ok, err := filepath.Match(pattern, entry.Key); !ok || err != nil {
+		return entry, false
+	}
+
+	return entry, !s.expired(entry.Expiration, entry.Created)
+}
+
+// fileSystemStorage.Keys Returns the sorted keys matching a pattern or error if it fails,
+// read from the files without decoding their values
+func (s *fileSystemStorage) Keys(pattern string) ([]string, error) {
+	var keys []string
+	err := s.scan(func() (err error) {
+		keys, err = s.keys(pattern)
+		return err
+	})
+
+	return keys, err
+}
+
+// keys Returns the sorted keys matching a pattern or error if it fails, caller must hold a lock of every key
+func (s *fileSystemStorage) keys(pattern string) ([]string, error) {
+	storageKeys, err := s.getPatternStorageKeys(pattern)
 	if err != nil {
-		return r, err
+		return nil, err
 	}
 
-	if !isExpired(entry.Expiration) {
-		return bytes.NewReader(entry.Value), nil
+	keys := make([]string, 0)
+	for _, storageKey := range storageKeys {
+		b, err := s.getStorageData(storageKey)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		var metadata entryMetadata
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			continue
+		}
+
+		if ok, err := filepath.Match(pattern, metadata.Key); !ok || err != nil {
+			continue
+		}
+
+		if !s.expired(metadata.Expiration, metadata.Created) {
+			keys = append(keys, metadata.Key)
+		}
 	}
 
-	return r, errNotExists
+	sort.Strings(keys)
+
+	return keys, nil
 }
 
-// fileSystemStorage.Get Returns io.Reader for a pattern or error if it fails
-func (s *fileSystemStorage) GetPattern(pattern string) (io.Reader, error) {
-	r := bytes.NewReader(nil)
+// fileSystemStorage.CountPattern Returns count of entries matching a pattern or error if it fails
+func (s *fileSystemStorage) CountPattern(pattern string) (int, error) {
+	var count int
+	err := s.scan(func() (err error) {
+		count, err = s.countPattern(pattern)
+		return err
+	})
+
+	return count, err
+}
 
-	s.lockAll()
-	defer s.unlockAll()
+// countPattern Returns count of entries matching a pattern or error if it fails, caller must hold a lock of every key
+func (s *fileSystemStorage) countPattern(pattern string) (int, error) {
+	keys, err := s.getPatternStorageKeys(pattern)
+	if err != nil {
+		return 0, err
+	}
 
-	keys, err := s.getAllStorageKeys()
+	count := 0
+	for _, key := range keys {
+		b, err := s.getStorageData(key)
+		if err != nil {
+			continue
+		}
+
+		if len(b) == 0 {
+			continue
+		}
+
+		var metadata entryMetadata
+		err = json.Unmarshal(b, &metadata)
+		if err != nil {
+			continue
+		}
+
+		if ok, err := filepath.Match(pattern, metadata.Key); !ok || err != nil {
+			continue
+		}
+
+		if !s.expired(metadata.Expiration, metadata.Created) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// fileSystemStorage.ExpirePattern Updates expiration of entries matching a pattern, returns count updated or error if it fails
+func (s *fileSystemStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	keys, err := s.getPatternStorageKeys(pattern)
 	if err != nil {
-		return r, err
+		return 0, err
 	}
 
-	ret := make([]string, 0, len(keys))
+	newExpiration := expirationTime(expiration)
+
+	updated := 0
 	for _, key := range keys {
 		b, err := s.getStorageData(key)
 		if err != nil {
@@ -129,30 +837,64 @@ func (s *fileSystemStorage) GetPattern(pattern string) (io.Reader, error) {
 			continue
 		}
 
-		if !isExpired(entry.Expiration) {
-			ret = append(ret, fmt.Sprintf(`{"%s":"%s"}`, entry.Key, entry.Value))
+		if s.expired(entry.Expiration, entry.Created) {
+			continue
 		}
-	}
 
-	r = bytes.NewReader([]byte(fmt.Sprintf("[%s]", strings.Join(ret, ","))))
+		entry.Expiration = newExpiration
 
-	return r, nil
+		dumped, err := json.Marshal(entry)
+		if err != nil {
+			return updated, err
+		}
+
+		if err := s.dumpToStorage(entry.Key, dumped); err != nil {
+			return updated, err
+		}
+
+		updated++
+	}
+
+	return updated, nil
 }
 
 // fileSystemStorage.Delete Deletes an entry by key, returns error if it fails
 func (s *fileSystemStorage) Delete(key string) error {
-	s.lock(key)
-	defer s.unlock(key)
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
 
-	key = md5Hash(key)
+	if err := s.deleteStorage(md5Hash(key)); err != nil {
+		return err
+	}
+
+	return s.opLog.record(opDelete, key, nil)
+}
+
+// fileSystemStorage.DeleteIfVersion Deletes an entry by key only if its version is version, returns error if it fails
+func (s *fileSystemStorage) DeleteIfVersion(key string, version int64) error {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err != nil {
+		return err
+	}
 
-	return s.deleteStorage(key)
+	if current.Version != version {
+		return errVersionMismatch
+	}
+
+	if err := s.deleteStorage(md5Hash(key)); err != nil {
+		return err
+	}
+
+	return s.opLog.record(opDelete, key, nil)
 }
 
 // fileSystemStorage.DeleteAll Deletes all entries, returns error if it fails
 func (s *fileSystemStorage) DeleteAll() error {
-	s.lockAll()
-	defer s.unlockAll()
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
 
 	keys, err := s.getAllStorageKeys()
 	if err != nil {
@@ -163,51 +905,368 @@ func (s *fileSystemStorage) DeleteAll() error {
 		s.deleteStorage(key)
 	}
 
-	return nil
+	return s.opLog.record(opDeleteAll, "", nil)
+}
+
+// fileSystemStorage.PurgeExpired Deletes all expired entries past the grace period, returns the keys purged or error if it fails
+func (s *fileSystemStorage) PurgeExpired() ([]string, error) {
+	// the directory is scanned locking no key, every expired entry is locked and checked again before deleting it
+	keys, err := s.getAllStorageKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, storageKey := range keys {
+		metadata, ok := s.expiredMetadata(storageKey)
+		if !ok {
+			continue
+		}
+
+		deleted, err := s.purgeExpired(storageKey, metadata.Key)
+		if err != nil {
+			return purged, err
+		}
+
+		if deleted {
+			purged = append(purged, metadata.Key)
+		}
+	}
+
+	return purged, nil
+}
+
+// expiredMetadata Returns the metadata of the entry stored in storageKey and whether it expired past the grace period
+func (s *fileSystemStorage) expiredMetadata(storageKey string) (entryMetadata, bool) {
+	var metadata entryMetadata
+
+	b, err := s.getStorageData(storageKey)
+	if err != nil || len(b) == 0 {
+		return metadata, false
+	}
+
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return metadata, false
+	}
+
+	return metadata, s.expired(metadata.Expiration, metadata.Created) && !s.stale(metadata.Expiration, metadata.Created)
+}
+
+// purgeExpired Deletes the entry of key stored in storageKey unless it was written again since it was found expired,
+// returns whether it was deleted or error if it fails
+func (s *fileSystemStorage) purgeExpired(storageKey string, key string) (bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	if metadata, ok := s.expiredMetadata(storageKey); !ok || metadata.Key != key {
+		return false, nil
+	}
+
+	if err := s.deleteStorage(storageKey); err != nil && err != errNotExists {
+		return false, err
+	}
+
+	if err := s.opLog.record(opDelete, key, nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fileSystemStorage.routeEvictions Purges the expired entries evicted in background through storage
+func (s *fileSystemStorage) routeEvictions(storage Storage) {
+	s.eviction.set(storage)
+}
+
+// evict Purges expired entries through the storage decorating this one, run in background every eviction interval
+func (s *fileSystemStorage) evict() error {
+	purged, err := s.eviction.purge(s)
+	if len(purged) > 0 {
+		logger.Debugf("fs storage evicted %d expired entries", len(purged))
+	}
+
+	return err
 }
 
 // fileSystemStorage.Put Saves an entry by key with timeout, returns error if it fails
 func (s *fileSystemStorage) Put(key string, value string, expiration time.Duration) error {
-	s.lock(key)
-	defer s.unlock(key)
+	return s.PutWithMetadata(key, value, nil, expiration)
+}
+
+// fileSystemStorage.PutMany Saves every entry by key with timeout, returns a PutManyError of the keys that failed
+func (s *fileSystemStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
 
-	var newExpiration int64
-	if expiration != noExpiration {
-		newExpiration = time.Now().Add(expiration).UnixNano()
+// fileSystemStorage.PutWithMetadata Saves an entry by key with timeout along metadata, returns error if it fails
+func (s *fileSystemStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	// an unreadable entry is overwritten unless its type must be checked
+	current, err := s.getEntry(key)
+	if err != nil && err != errNotExists && s.typedKeys {
+		return err
+	} else if err != nil {
+		current = entry{Key: key}
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return err
 	}
 
 	newEntry := entry{
 		Key:        key,
 		Value:      []byte(value),
-		Expiration: newExpiration,
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   metadata,
 	}
 
-	dumped, err := json.Marshal(newEntry)
+	return s.putEntry(newEntry)
+}
+
+// fileSystemStorage.Push Appends an element to the JSON array stored by key, returns error if it fails
+func (s *fileSystemStorage) Push(key string, element string) error {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == errNotExists {
+		current = entry{Key: key, Created: now().UnixNano()}
+	} else if err != nil {
+		return err
+	}
+
+	if err := s.checkType(current, typeList); err != nil {
+		return err
+	}
+
+	current.Value, err = pushElement(current.Value, element)
 	if err != nil {
 		return err
 	}
 
-	return s.dumpToStorage(key, dumped)
+	current.Type = typeList
+	current.Version++
+
+	return s.putEntry(current)
+}
+
+// fileSystemStorage.Pop Removes and returns the last element of the JSON array stored by key or error if it fails
+func (s *fileSystemStorage) Pop(key string) (string, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.checkType(current, typeList); err != nil {
+		return "", err
+	}
+
+	var element string
+	current.Value, element, err = popElement(current.Value)
+	if err != nil {
+		return "", err
+	}
+
+	current.Version++
+
+	return element, s.putEntry(current)
+}
+
+// fileSystemStorage.Increment Adds delta to the integer stored by key, a missing key counting as 0, returns the new value or error if it fails
+func (s *fileSystemStorage) Increment(key string, delta int64) (int64, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, err := s.getEntry(key)
+	if err == errNotExists {
+		current = entry{Key: key, Created: now().UnixNano()}
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := s.checkType(current, typeCounter); err != nil {
+		return 0, err
+	}
+
+	value, counter, err := incrementValue(current.Value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	current.Value = value
+	current.Type = typeCounter
+	current.Version++
+
+	if err := s.putEntry(current); err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
+
+// fileSystemStorage.Decrement Subtracts delta from the integer stored by key, returns the new value or error if it fails
+func (s *fileSystemStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
 }
 
 // fileSystemStorage.Flush Flushes storage
 func (s *fileSystemStorage) Flush() {
+	if s.quit != nil {
+		s.quit <- true
+	}
+
+	if err := s.opLog.close(); err != nil {
+		logger.Debugf("error in fs storage operation log: %s", err)
+	}
+}
+
+// checkType Returns errWrongType if typed keys are enforced and current holds another type than valueType
+func (s *fileSystemStorage) checkType(current entry, valueType string) error {
+	if !s.typedKeys {
+		return nil
+	}
+
+	return checkType(current, valueType)
+}
+
+// expired Returns whether an entry is past its expiration or older than the max age
+func (s *fileSystemStorage) expired(expiration int64, created int64) bool {
+	return isExpired(expiration) || isTooOld(created, s.maxAge)
+}
+
+// stale Returns whether an entry expired within the grace period, never if older than the max age
+func (s *fileSystemStorage) stale(expiration int64, created int64) bool {
+	return isStale(expiration, s.grace) && !isTooOld(created, s.maxAge)
+}
+
+// reconcileIndex Rebuilds the key index from the storage dir, reading only files not indexed yet
+func (s *fileSystemStorage) reconcileIndex() error {
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	fileNames, err := s.getAllStorageKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]string, len(fileNames))
+	for _, fileName := range fileNames {
+		if key, ok := s.index.get(fileName); ok {
+			keys[fileName] = key
+			continue
+		}
+
+		b, err := s.getStorageData(fileName)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		var metadata entryMetadata
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			continue
+		}
+
+		keys[fileName] = metadata.Key
+	}
+
+	s.index.replace(keys)
+
+	return nil
+}
+
+// getPatternStorageKeys Returns file names possibly holding keys matching pattern, ordered by key with KeyIndex
+// and by file name otherwise
+func (s *fileSystemStorage) getPatternStorageKeys(pattern string) ([]string, error) {
+	if s.index == nil {
+		return s.getAllStorageKeys()
+	}
+
+	return s.index.match(pattern), nil
+}
+
+// getEntry Returns the not expired entry for key, caller must hold the key lock
+func (s *fileSystemStorage) getEntry(key string) (entry, error) {
+	entry, stale, err := s.getStaleEntry(key)
+	if err == nil && stale {
+		return entry, errNotExists
+	}
+
+	return entry, err
+}
+
+// getStaleEntry Returns the entry for key, not expired or within the grace period, and whether it expired,
+// caller must hold the key lock
+func (s *fileSystemStorage) getStaleEntry(key string) (entry, bool, error) {
+	var entry entry
+
+	b, err := s.getStorageData(md5Hash(key))
+	if err != nil {
+		return entry, false, err
+	}
+
+	if len(b) == 0 {
+		return entry, false, errNotExists
+	}
+
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		return entry, false, err
+	}
+
+	if !s.expired(entry.Expiration, entry.Created) {
+		return entry, false, nil
+	} else if s.stale(entry.Expiration, entry.Created) {
+		return entry, true, nil
+	}
 
+	return entry, false, errNotExists
+}
+
+// putEntry Saves entry, caller must hold the key lock
+func (s *fileSystemStorage) putEntry(entry entry) error {
+	dumped, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.dumpToStorage(entry.Key, dumped)
+}
+
+// dir Returns the storage dir currently in use
+func (s *fileSystemStorage) dir() string {
+	s.dirMu.RLock()
+	defer s.dirMu.RUnlock()
+
+	return s.storageDir
 }
 
 func (s *fileSystemStorage) getAllStorageKeys() ([]string, error) {
-	if err := os.Mkdir(s.storageDir, 0700); err != nil && !os.IsExist(err) {
+	storageDir := s.dir()
+	if err := os.Mkdir(storageDir, 0700); err != nil && !os.IsExist(err) {
 		return []string{}, err
 	}
 
-	files, err := ioutil.ReadDir(s.storageDir)
+	files, err := ioutil.ReadDir(storageDir)
 	if err != nil {
 		return []string{}, err
 	}
 
 	r := make([]string, 0)
 	for _, file := range files {
-		if !file.IsDir() {
+		if !file.IsDir() && !reservedFileNames[file.Name()] {
 			r = append(r, file.Name())
 		}
 	}
@@ -216,11 +1275,13 @@ func (s *fileSystemStorage) getAllStorageKeys() ([]string, error) {
 }
 
 func (s *fileSystemStorage) getStorageData(key string) ([]byte, error) {
-	f, err := getReader(s.storageDir, key)
+	f, err := getReader(s.dir(), key)
 	if err != nil {
 		return nil, err
 	}
 
+	defer f.Close()
+
 	err = f.Sync()
 	if err != nil {
 		return []byte(""), err
@@ -236,11 +1297,16 @@ func (s *fileSystemStorage) getStorageData(key string) ([]byte, error) {
 }
 
 func (s *fileSystemStorage) deleteStorage(key string) error {
-	if err := os.Mkdir(s.storageDir, 0700); err != nil && !os.IsExist(err) {
+	storageDir := s.dir()
+	if err := os.Mkdir(storageDir, 0700); err != nil && !os.IsExist(err) {
 		return err
 	}
 
-	storagePath := filepath.Join(s.storageDir, key)
+	storagePath := filepath.Join(storageDir, key)
+
+	if s.index != nil {
+		s.index.remove(key)
+	}
 
 	if err := os.Remove(storagePath); err != nil {
 		if !os.IsNotExist(err) {
@@ -253,14 +1319,61 @@ func (s *fileSystemStorage) deleteStorage(key string) error {
 	return nil
 }
 
+// dumpToStorage Writes data for key, recording it in the operation log if any
 func (s *fileSystemStorage) dumpToStorage(key string, data []byte) error {
-	key = md5Hash(key)
+	if err := s.dumpToDir(key, data); err != nil {
+		return err
+	}
+
+	return s.opLog.record(opPut, key, data)
+}
+
+// dumpToDir Writes data for key, switching to the fallback dir after persistent write failures
+func (s *fileSystemStorage) dumpToDir(key string, data []byte) error {
+	err := s.writeStorage(key, data)
+	if s.fallbackDir == "" {
+		return err
+	}
 
-	f, err := getWriter(s.storageDir, key)
+	s.dirMu.Lock()
+	if err == nil {
+		s.writeFailures = 0
+		s.dirMu.Unlock()
+		return nil
+	}
+
+	s.writeFailures++
+	switched := s.writeFailures >= fallbackAfterFailures && s.storageDir != s.fallbackDir
+	if switched {
+		logger.Warnf("fs storage switching from %s to fallback %s after %d write failures: %s", s.storageDir, s.fallbackDir, s.writeFailures, err)
+		s.storageDir = s.fallbackDir
+		s.writeFailures = 0
+
+		if s.index != nil {
+			s.index.replace(map[string]string{})
+		}
+	}
+	s.dirMu.Unlock()
+
+	if !switched {
+		return err
+	}
+
+	return s.writeStorage(key, data)
+}
+
+func (s *fileSystemStorage) writeStorage(key string, data []byte) error {
+	fileName := md5Hash(key)
+
+	f, err := getWriter(s.dir(), fileName)
 	if err != nil {
 		return err
 	}
 
+	if s.index != nil {
+		s.index.set(fileName, key)
+	}
+
 	err = f.Truncate(0)
 	if err != nil {
 		return err
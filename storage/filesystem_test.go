@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -279,7 +283,7 @@ func TestFileSystemStorage_GetPattern(t *testing.T) {
 		t.Fatalf("err not expected: %s", err)
 	}
 
-	r, err := storage.GetPattern("another*")
+	r, err := storage.GetPattern("another*", 0, 0)
 	if err != nil {
 		t.Fatalf("err not expected: %s", err)
 	}
@@ -327,7 +331,7 @@ func TestFileSystemStorage_GetPatternEmpty(t *testing.T) {
 		t.Fatalf("err not expected: %s", err)
 	}
 
-	r, err := storage.GetPattern("a*glob?")
+	r, err := storage.GetPattern("a*glob?", 0, 0)
 	if err != nil {
 		t.Fatalf("err not expected: %s", err)
 	}
@@ -341,3 +345,1669 @@ func TestFileSystemStorage_GetPatternEmpty(t *testing.T) {
 		t.Fatalf("expected: %s, found : %s", "[]", chk)
 	}
 }
+
+func TestFileSystemStorage_ExpirePattern(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	updated, err := storage.ExpirePattern("another*", time.Duration(time.Hour))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if updated != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, updated)
+	}
+
+	for key, expectExpiration := range map[string]bool{"another key": true, "a key": false} {
+		b, err := storage.getStorageData(md5Hash(key))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		var entry entry
+		err = json.Unmarshal(b, &entry)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk := entry.Expiration != 0; chk != expectExpiration {
+			t.Fatalf("expected: %t, found : %t", expectExpiration, chk)
+		}
+	}
+}
+
+func TestFileSystemStorage_GetPatternReservedFileName(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, memoryCacheFile), []byte(`{"key":"bogus","value":"Ym9ndXM=","expiration":0}`), 0600)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != `[{"a key":"a value"}]` {
+		t.Fatalf("expected: %s, found : %s", `[{"a key":"a value"}]`, chk)
+	}
+}
+
+func TestFileSystemStorage_Keys(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	keys, err := storage.Keys("*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, found : %v", keys)
+	}
+
+	for _, key := range []string{"b key", "a key", "another key"} {
+		err = storage.Put(key, "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	err = storage.Put("an expired key", "a value", time.Nanosecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	keys, err = storage.Keys("a*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := []string{"a key", "another key"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, keys)
+	}
+}
+
+func TestFileSystemStorage_CountPattern(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for pattern, expected := range map[string]int{"*": 2, "a*": 2, "another*": 1, "an expired*": 0, "none*": 0} {
+		chk, err := storage.CountPattern(pattern)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("pattern %s expected: %d, found : %d", pattern, expected, chk)
+		}
+	}
+}
+
+func TestFileSystemStorage_Exists(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expected := range map[string]bool{"a key": true, "an expired key": false, "a missing key": false} {
+		chk, err := storage.Exists(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("expected: %v, found : %v", expected, chk)
+		}
+	}
+}
+
+func TestFileSystemStorage_TTL(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expiration := range map[string]time.Duration{"a key": time.Duration(-1), "an expiring key": time.Minute, "an expired key": 0} {
+		err = storage.Put(key, "a value", expiration)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	ttl, err := storage.TTL("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl != time.Duration(-1) {
+		t.Fatalf("expected: %s, found : %s", time.Duration(-1), ttl)
+	}
+
+	ttl, err = storage.TTL("an expiring key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("expected: about %s, found : %s", time.Minute, ttl)
+	}
+
+	for _, key := range []string{"an expired key", "a missing key"} {
+		if _, err := storage.TTL(key); !storage.IsNotExist(err) {
+			t.Fatalf("expected not exists, found : %v", err)
+		}
+	}
+}
+
+func TestFileSystemStorage_GetPatternRoundTrip(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	values := map[string]string{
+		`a "key"`:      "he said \"hi\"\n",
+		"a\\key\nwith": `{"injected":"value"}","other":"`,
+	}
+
+	for key, value := range values {
+		err = storage.Put(key, value, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	var listing []map[string]string
+	if err := json.NewDecoder(r).Decode(&listing); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk := map[string]string{}
+	for _, entry := range listing {
+		for key, value := range entry {
+			chk[key] = value
+		}
+	}
+
+	if !reflect.DeepEqual(chk, values) {
+		t.Fatalf("expected: %q, found : %q", values, chk)
+	}
+}
+
+func TestFileSystemStorage_ExistsMany(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := storage.ExistsMany([]string{"a key", "an expired key", "a missing key"})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := map[string]bool{"a key": true, "an expired key": false, "a missing key": false}
+	if !reflect.DeepEqual(chk, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, chk)
+	}
+}
+
+func TestFileSystemStorage_PushPop(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, element := range []string{"1", `"two"`, `{"three":3}`} {
+		err = storage.Push("a list", element)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for _, expected := range []string{`{"three":3}`, `"two"`, "1"} {
+		chk, err := storage.Pop("a list")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("expected: %s, found : %s", expected, chk)
+		}
+	}
+
+	_, err = storage.Pop("a list")
+	if err != errNotExists {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a key", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
+
+func TestFileSystemStorage_GetOrCreate(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, created, err := storage.GetOrCreate("a key", "{}", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !created || string(chk) != "{}" {
+		t.Fatalf("expected: %s created, found : %s (%t)", "{}", chk, created)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, created, err = storage.GetOrCreate("a key", "{}", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if created || string(chk) != "a value" {
+		t.Fatalf("expected: %s existing, found : %s (%t)", "a value", chk, created)
+	}
+}
+
+func TestFileSystemStorage_GetPatternKeyIndex(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, KeyIndex(50*time.Millisecond))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	assertPattern := func(expected string) {
+		r, err := storage.GetPattern("a*", 0, 0)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != expected {
+			t.Fatalf("expected: %s, found : %s", expected, chk)
+		}
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	assertPattern(`[{"a key":"a value"}]`)
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	assertPattern(`[]`)
+
+	external, err := NewFileSystemStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = external.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	assertPattern(`[{"another key":"another value"}]`)
+}
+
+func TestFileSystemStorage_GetPatternSorted(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "d", "b"} {
+		err = storage.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"a":"a"},{"b":"b"},{"c":"c"},{"d":"d"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestFileSystemStorage_GetPatternPaged(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "e", "d", "b"} {
+		err = storage.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	memoryDir, err := ioutil.TempDir("", "keyvaluestorage-paged")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(memoryDir)
+
+	memory, err := NewMemoryStorage(memoryDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "e", "d", "b"} {
+		err = memory.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	// pages follow the key order, as the ones of memory
+	for offset := 0; offset < 6; offset += 2 {
+		r, err := storage.GetPattern("*", 2, offset)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		page, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		r, err = memory.GetPattern("*", 2, offset)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		expected, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(page) != string(expected) {
+			t.Fatalf("expected: %s, found : %s", expected, page)
+		}
+
+		if offset == 2 && string(page) != `[{"c":"c"},{"d":"d"}]` {
+			t.Fatalf("expected: %s, found : %s", `[{"c":"c"},{"d":"d"}]`, page)
+		}
+	}
+
+	indexed, err := NewFileSystemStorage(tmpDir, KeyIndex(time.Minute))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer indexed.Flush()
+
+	r, err := indexed.GetPattern("*", 2, 2)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != `[{"c":"c"},{"d":"d"}]` {
+		t.Fatalf("expected: %s, found : %s", `[{"c":"c"},{"d":"d"}]`, chk)
+	}
+}
+
+func TestFileSystemStorage_GetPatternCounted(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "e", "d", "b"} {
+		err = storage.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	err = storage.Put("an expired key", "a value", 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	indexed, err := NewFileSystemStorage(tmpDir, KeyIndex(time.Minute))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer indexed.Flush()
+
+	for _, tc := range []struct {
+		limit    int
+		offset   int
+		expected string
+	}{
+		{2, 2, `[{"c":"c"},{"d":"d"}]`},
+		{2, 4, `[{"e":"e"}]`},
+		{2, 6, `[]`},
+		{0, 0, `[{"a":"a"},{"b":"b"},{"c":"c"},{"d":"d"},{"e":"e"}]`},
+	} {
+		for _, strg := range []*fileSystemStorage{storage, indexed} {
+			r, total, err := strg.GetPatternCounted("*", tc.limit, tc.offset)
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			chk, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+
+			if string(chk) != tc.expected {
+				t.Fatalf("expected: %s, found : %s", tc.expected, chk)
+			}
+
+			if total != 5 {
+				t.Fatalf("expected: %d, found : %d", 5, total)
+			}
+		}
+	}
+}
+
+func TestFileSystemStorage_ClosesFiles(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("open files cannot be counted: %s", err)
+	}
+
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	fds, err = ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, err := storage.Exists("a key"); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if _, err := storage.CountPattern("*"); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	chk, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// a few may be opened meanwhile by the runtime, not one per read
+	if len(chk) > len(fds)+10 {
+		t.Fatalf("expected about %d open files, found : %d", len(fds), len(chk))
+	}
+}
+
+func TestFileSystemStorage_FallbackDir(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	primary := filepath.Join(tmpDir, "primary")
+	fallback := filepath.Join(tmpDir, "fallback")
+	for _, dir := range []string{primary, fallback} {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	storage, err := NewFileSystemStorage(primary, FallbackDir(fallback))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// the primary dir is gone and its path unusable as a dir
+	if err := os.RemoveAll(primary); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := ioutil.WriteFile(primary, nil, 0600); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 1; i < fallbackAfterFailures; i++ {
+		err = storage.Put("another key", "another value", time.Duration(-1))
+		if err == nil {
+			t.Fatalf("err expected writing to an unusable primary dir")
+		}
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.Get("another key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "another value" {
+		t.Fatalf("expected: %s, found : %s", "another value", chk)
+	}
+
+	if _, err := os.Stat(filepath.Join(fallback, md5Hash("another key"))); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	os.Remove(primary)
+	os.RemoveAll(fallback)
+}
+
+func TestFileSystemStorage_PutIfTTLBelow(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, tc := range []struct {
+		threshold time.Duration
+		expected  string
+		saved     bool
+	}{
+		{time.Minute, "first", true},
+		{30 * time.Second, "first", false},
+		{10 * time.Minute, "refreshed", true},
+	} {
+		saved, err := storage.PutIfTTLBelow("a key", tc.expected, tc.threshold, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if saved != tc.saved {
+			t.Fatalf("expected: %t, found : %t", tc.saved, saved)
+		}
+
+		r, err := storage.Get("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != tc.expected {
+			t.Fatalf("expected: %s, found : %s", tc.expected, chk)
+		}
+	}
+
+	err = storage.Put("a key", "permanent", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	saved, err := storage.PutIfTTLBelow("a key", "refreshed", time.Hour, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if saved {
+		t.Fatalf("expected a key without expiration not to be refreshed")
+	}
+}
+
+func TestFileSystemStorage_CreationTimes(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, CreationTimes())
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	createdAt := func() string {
+		metadata, err := storage.GetMetadata("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		return metadata[CreatedAtHeader]
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	created := createdAt()
+	if _, err := time.Parse(time.RFC3339Nano, created); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err = storage.CompareAndSwap("a key", "another value", "1", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err = storage.Increment("a key", 1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if updated := createdAt(); updated != created {
+		t.Fatalf("expected: %s, found : %s", created, updated)
+	}
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if recreated := createdAt(); recreated == created {
+		t.Fatalf("expected a new creation time, found : %s", recreated)
+	}
+}
+
+func TestFileSystemStorage_TypedKeys(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, TypedKeys())
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a string", "[]", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a list", "1")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a string", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	_, err = storage.Pop("a string")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a list", "a value", time.Duration(-1))
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a string", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a list", "2")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Delete("a list")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a list", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// counters are tagged at their first increment, strings only hold what was put
+	_, err = storage.Increment("a counter", 1)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a counter", "1", time.Duration(-1))
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Push("a counter", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a number", "1", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Increment("a number", 1)
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	counter, err := storage.Decrement("a counter", 3)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if counter != -2 {
+		t.Fatalf("expected: %d, found : %d", -2, counter)
+	}
+}
+
+func TestFileSystemStorage_MaxAge(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, MaxAge(50*time.Millisecond))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an old key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = storage.Put("a fresh key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("an old key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+
+	_, err = storage.Get("a fresh key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	count, err := storage.CountPattern("*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, count)
+	}
+}
+
+func TestFileSystemStorage_MaxAgeOverwritten(t *testing.T) {
+	defer func() {
+		storageClock = newClock()
+	}()
+
+	elapsed := time.Duration(0)
+	storageClock = clock{
+		start:   time.Now().Round(0),
+		elapsed: func() time.Duration { return elapsed },
+	}
+
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, MaxAge(time.Minute))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	// overwriting a key keeps the time it was created at
+	for _, step := range []time.Duration{0, 40 * time.Second} {
+		elapsed = step
+
+		err = storage.Put("a key", "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	elapsed = 61 * time.Second
+
+	_, err = storage.Get("a key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+}
+
+func TestFileSystemStorage_GetAndTouch(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.GetAndTouch("a key", time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", chk)
+	}
+
+	// under threshold nothing is slid
+	_, err = storage.GetAndTouch("another key", time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, err = storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("another key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+}
+
+func TestFileSystemStorage_PutIfVersion(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	version, err := storage.PutIfVersion("a key", "a value", 0, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if version != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, version)
+	}
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.PutIfVersion("a key", "a stale value", 1, time.Duration(-1))
+	if !storage.IsVersionMismatch(err) {
+		t.Fatalf("expected version mismatch, found : %v", err)
+	}
+
+	r, version, err := storage.GetVersioned("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "another value" || version != 2 {
+		t.Fatalf("expected: %s at %d, found : %s at %d", "another value", 2, chk, version)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 1); !storage.IsVersionMismatch(err) {
+		t.Fatalf("expected version mismatch, found : %v", err)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 2); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 2); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
+
+func TestFileSystemStorage_StorageDirIsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "keyvaluestorage")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err = NewFileSystemStorage(f.Name())
+	if err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Fatalf("expected a not a directory error, found : %v", err)
+	}
+}
+
+func TestFileSystemStorage_PutWithMetadata(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	metadata := Metadata{"Content-Disposition": "attachment; filename=report.pdf"}
+	err = storage.PutWithMetadata("a key", "a value", metadata, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := storage.GetMetadata("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !reflect.DeepEqual(chk, metadata) {
+		t.Fatalf("expected: %v, found : %v", metadata, chk)
+	}
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err = storage.GetMetadata("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(chk) != 0 {
+		t.Fatalf("expected no metadata, found : %v", chk)
+	}
+
+	_, err = storage.GetMetadata("a missing key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected not exist, found : %v", err)
+	}
+}
+
+func TestFileSystemStorage_ConditionalPutsKeepMetadata(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	metadata := Metadata{"Content-Type": "text/plain", LastWriterHeader: "anonymous"}
+	err = storage.PutWithMetadata("a key", "a value", metadata, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.PutIfVersion("a key", "another value", 1, time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if swapped, err := storage.CompareAndSwap("a key", "another value", "a swapped value", time.Hour); err != nil || !swapped {
+		t.Fatalf("expected swapped, found : %v, %v", swapped, err)
+	}
+
+	if refreshed, err := storage.PutIfTTLBelow("a key", "a refreshed value", 2*time.Hour, time.Hour); err != nil || !refreshed {
+		t.Fatalf("expected refreshed, found : %v, %v", refreshed, err)
+	}
+
+	chk, err := storage.GetMetadata("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !reflect.DeepEqual(chk, metadata) {
+		t.Fatalf("expected: %v, found : %v", metadata, chk)
+	}
+}
+
+func TestFileSystemStorage_PurgeExpired(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expiration := range map[string]time.Duration{"an expired key": 0, "another expired key": 0, "a key": time.Duration(-1)} {
+		err = storage.Put(key, "a value", expiration)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	purged, err := storage.PurgeExpired()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(purged) != 2 {
+		t.Fatalf("expected: %d, found : %d", 2, len(purged))
+	}
+
+	files, err := storage.getAllStorageKeys()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(files) != 1 || files[0] != md5Hash("a key") {
+		t.Fatalf("expected: %v, found : %v", []string{md5Hash("a key")}, files)
+	}
+}
+
+func TestFileSystemStorage_PurgeExpiredLocksNoOtherKey(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expiration := range map[string]time.Duration{"an expired key": 0, "a key": time.Duration(-1)} {
+		err = storage.Put(key, "a value", expiration)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	// a write in progress on another key does not block the purge
+	storage.locks.Lock("a key")
+	defer storage.locks.Unlock("a key")
+
+	done := make(chan []string)
+	go func() {
+		purged, err := storage.PurgeExpired()
+		if err != nil {
+			t.Errorf("err not expected: %s", err)
+		}
+
+		done <- purged
+	}()
+
+	select {
+	case purged := <-done:
+		if !reflect.DeepEqual(purged, []string{"an expired key"}) {
+			t.Fatalf("expected: %v, found : %v", []string{"an expired key"}, purged)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the purge not to wait for the lock of another key")
+	}
+}
+
+func TestFileSystemStorage_PutIfEmpty(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// an expired key does not keep the prefix from being empty
+	err = storage.Put("jobs/an expired key", "a value", 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	tests := []struct {
+		prefix  string
+		key     string
+		created bool
+	}{
+		{"jobs/", "jobs/leader", true},
+		{"jobs/", "jobs/another leader", false},
+		{"", "leader", false},
+	}
+
+	for _, test := range tests {
+		created, err := storage.PutIfEmpty(test.prefix, test.key, "me", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if created != test.created {
+			t.Fatalf("expected: %t, found : %t", test.created, created)
+		}
+	}
+
+	r, err := storage.Get("jobs/leader")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, _ := ioutil.ReadAll(r)
+	if string(value) != "me" {
+		t.Fatalf("expected: %s, found : %s", "me", value)
+	}
+}
+
+func TestFileSystemStorage_GetStale(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, ExpiryGrace(300*time.Millisecond))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "a value", 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Get("an expired key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	r, stale, err := storage.GetStale("an expired key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !stale {
+		t.Fatalf("expected stale")
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(value) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", value)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+
+	if _, _, err := storage.GetStale("an expired key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	purged, err := storage.PurgeExpired()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(purged) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(purged))
+	}
+}
+
+func TestFileSystemStorage_EvictionInterval(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, EvictionInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	for key, expiration := range map[string]time.Duration{"an expired key": 0, "a key": time.Duration(-1)} {
+		if err := storage.Put(key, "a value", expiration); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	var files []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if files, err = storage.getAllStorageKeys(); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if len(files) == 1 {
+			break
+		}
+	}
+
+	if len(files) != 1 || files[0] != md5Hash("a key") {
+		t.Fatalf("expected: %v, found : %v", []string{md5Hash("a key")}, files)
+	}
+}
+
+func TestFileSystemStorage_PatternReaders(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	serial, err := NewFileSystemStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	parallel, err := NewFileSystemStorage(tmpDir, PatternReaders(8))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		expiration := time.Duration(-1)
+		if i%10 == 0 {
+			expiration = 0
+		}
+
+		if err := serial.Put(fmt.Sprintf("key %03d", i), fmt.Sprintf("value %d", i), expiration); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	listings := make([]string, 0, 2)
+	for _, storage := range []*fileSystemStorage{serial, parallel} {
+		r, err := storage.GetPattern("key *", 0, 0)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		listing, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		listings = append(listings, string(listing))
+	}
+
+	if listings[0] != listings[1] {
+		t.Fatalf("expected: %s, found : %s", listings[0], listings[1])
+	}
+
+	var entries []map[string]string
+	if err := json.Unmarshal([]byte(listings[1]), &entries); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(entries) != 90 {
+		t.Fatalf("expected: %d, found : %d", 90, len(entries))
+	}
+}
+
+func TestFileSystemStorage_RelaxedScans(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir, RelaxedScans())
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Put("a key", "a value", -1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !IsConsistent(r) {
+		t.Fatalf("expected: %s, found : %s", "consistent listing", "inconsistent")
+	}
+
+	// a write in progress along the scan, which does not wait for it
+	storage.locks.Lock("another key")
+
+	r, err = storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage.locks.Unlock("another key")
+
+	if IsConsistent(r) {
+		t.Fatalf("expected: %s, found : %s", "inconsistent listing", "consistent")
+	}
+
+	listing, _ := ioutil.ReadAll(r)
+	if string(listing) != `[{"a key":"a value"}]` {
+		t.Fatalf("expected: %s, found : %s", `[{"a key":"a value"}]`, listing)
+	}
+}
+
+func BenchmarkFileSystemStorage_GetPattern(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-pattern")
+	if err != nil {
+		b.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+	if err != nil {
+		b.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		if err := storage.Put(fmt.Sprintf("key %d", i), strings.Repeat("a value ", 64), time.Duration(-1)); err != nil {
+			b.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for _, readers := range []int{1, 4, 16} {
+		storage, err := NewFileSystemStorage(tmpDir, PatternReaders(readers))
+		if err != nil {
+			b.Fatalf("err not expected: %s", err)
+		}
+
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.GetPattern("key *", 0, 0); err != nil {
+					b.Fatalf("err not expected: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSystemStorage_CompareAndSwap(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if swapped, err := storage.CompareAndSwap("a key", "", "a value", time.Duration(-1)); err != nil || swapped {
+		t.Fatalf("expected: %v, found : %v (%v)", false, swapped, err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// writers racing from the same value, only one swaps it
+	var mu sync.Mutex
+	var winners []string
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+
+			swapped, err := storage.CompareAndSwap("a key", "a value", value, time.Duration(-1))
+			if err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+
+			if swapped {
+				mu.Lock()
+				winners = append(winners, value)
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("value %d", i))
+	}
+
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(winners))
+	}
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(value) != winners[0] {
+		t.Fatalf("expected: %s, found : %s", winners[0], value)
+	}
+}
+
+func TestFileSystemStorage_Increment(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// a missing key counts as 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := storage.Increment("a counter", 2); err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	counter, err := storage.Decrement("a counter", 1)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if counter != 99 {
+		t.Fatalf("expected: %d, found : %d", 99, counter)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Increment("a key", 1); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+
+	if err := storage.Put("a key", "9223372036854775807", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Increment("a key", 1); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
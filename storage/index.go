@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// keyIndex maps fs storage file names to the keys they hold
+type keyIndex struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		keys: map[string]string{},
+	}
+}
+
+func (i *keyIndex) get(fileName string) (string, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	key, ok := i.keys[fileName]
+
+	return key, ok
+}
+
+func (i *keyIndex) set(fileName string, key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.keys[fileName] = key
+}
+
+func (i *keyIndex) remove(fileName string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.keys, fileName)
+}
+
+func (i *keyIndex) replace(keys map[string]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.keys = keys
+}
+
+// match Returns file names holding keys matching pattern, ordered by key
+func (i *keyIndex) match(pattern string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	r := make([]string, 0)
+	for fileName, key := range i.keys {
+		if ok, err := filepath.Match(pattern, key); ok && err == nil {
+			r = append(r, fileName)
+		}
+	}
+
+	sort.Slice(r, func(a, b int) bool {
+		return i.keys[r[a]] < i.keys[r[b]]
+	})
+
+	return r
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"time"
+)
+
+// LastWriterHeader is the metadata recording the actor that last put a key
+const LastWriterHeader = "X-Last-Writer"
+
+type lastWriterStorage struct {
+	Storage
+
+	actor string
+}
+
+// NewLastWriterStorage Decorator for storage
+// records actor as the last writer in the metadata of every key it puts
+func NewLastWriterStorage(storage Storage, actor string) *lastWriterStorage {
+	return &lastWriterStorage{
+		Storage: storage,
+		actor:   actor,
+	}
+}
+
+// lastWriterStorage.Put Saves an entry by key with timeout recording the actor, returns error if it fails
+func (s *lastWriterStorage) Put(key string, value string, expiration time.Duration) error {
+	return s.PutWithMetadata(key, value, nil, expiration)
+}
+
+// lastWriterStorage.PutMany Saves every entry by key with timeout along the actor, returns a PutManyError of the keys that failed
+func (s *lastWriterStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// lastWriterStorage.PutWithMetadata Saves an entry by key with timeout along metadata and the actor, returns error if it fails
+func (s *lastWriterStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	written := make(Metadata, len(metadata)+1)
+	for name, v := range metadata {
+		written[name] = v
+	}
+
+	written[LastWriterHeader] = s.actor
+
+	return s.Storage.PutWithMetadata(key, value, written, expiration)
+}
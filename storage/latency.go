@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+)
+
+type latencyStorage struct {
+	Storage
+
+	latency time.Duration
+	jitter  time.Duration
+}
+
+// NewLatencyStorage Decorator for storage, meant for benchmarking only
+// delays every operation by latency plus a random duration up to jitter
+func NewLatencyStorage(storage Storage, latency time.Duration, jitter time.Duration) *latencyStorage {
+	return &latencyStorage{
+		Storage: storage,
+		latency: latency,
+		jitter:  jitter,
+	}
+}
+
+// latencyStorage.WithContext Returns the storage bound to ctx delaying operations as this one
+func (s *latencyStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// latencyStorage.Rejected Returns the operations the decorated storage rejects
+func (s *latencyStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+func (s *latencyStorage) delay() {
+	delay := s.latency
+	if s.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+
+	time.Sleep(delay)
+}
+
+// latencyStorage.Put Saves an entry by key with timeout after a delay, returns error if it fails
+func (s *latencyStorage) Put(key string, value string, expiration time.Duration) error {
+	s.delay()
+
+	return s.Storage.Put(key, value, expiration)
+}
+
+// latencyStorage.PutMany Saves every entry by key with timeout after a single delay, returns a PutManyError of the keys that failed
+func (s *latencyStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	s.delay()
+
+	return s.Storage.PutMany(entries, expiration)
+}
+
+// latencyStorage.PutWithMetadata Saves an entry by key with timeout along metadata after a delay, returns error if it fails
+func (s *latencyStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	s.delay()
+
+	return s.Storage.PutWithMetadata(key, value, metadata, expiration)
+}
+
+// latencyStorage.GetMetadata Returns the metadata stored along the value of a key after a delay or error if it fails
+func (s *latencyStorage) GetMetadata(key string) (Metadata, error) {
+	s.delay()
+
+	return s.Storage.GetMetadata(key)
+}
+
+// latencyStorage.Get Returns io.Reader for a key after a delay or error if it fails
+func (s *latencyStorage) Get(key string) (io.Reader, error) {
+	s.delay()
+
+	return s.Storage.Get(key)
+}
+
+// latencyStorage.GetMany Returns the values of the existing keys after a single delay or error if it fails
+func (s *latencyStorage) GetMany(keys []string) (map[string][]byte, error) {
+	s.delay()
+
+	return s.Storage.GetMany(keys)
+}
+
+// latencyStorage.GetStale Returns io.Reader for a key after a delay and whether it is stale or error if it fails
+func (s *latencyStorage) GetStale(key string) (io.Reader, bool, error) {
+	s.delay()
+
+	return s.Storage.GetStale(key)
+}
+
+// latencyStorage.GetAndTouch Returns io.Reader for a key after a delay sliding its expiration, or error if it fails
+func (s *latencyStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	s.delay()
+
+	return s.Storage.GetAndTouch(key, window, threshold)
+}
+
+// latencyStorage.GetOrCreate Returns value for a key after a delay, creating it with defaultValue if missing, and whether it was created or error if it fails
+func (s *latencyStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	s.delay()
+
+	return s.Storage.GetOrCreate(key, defaultValue, expiration)
+}
+
+// latencyStorage.PutIfTTLBelow Saves an entry by key with timeout after a delay if its remaining TTL is under threshold, returns whether it was saved or error if it fails
+func (s *latencyStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	s.delay()
+
+	return s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+}
+
+// latencyStorage.CompareAndSwap Saves an entry by key with timeout after a delay if its value is oldValue, returns whether it was saved or error if it fails
+func (s *latencyStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	s.delay()
+
+	return s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+}
+
+// latencyStorage.PutIfEmpty Saves an entry by key with timeout after a delay if no key starting with prefix exists, returns whether it was saved or error if it fails
+func (s *latencyStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	s.delay()
+
+	return s.Storage.PutIfEmpty(prefix, key, value, expiration)
+}
+
+// latencyStorage.GetVersioned Returns io.Reader for a key with its version after a delay or error if it fails
+func (s *latencyStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	s.delay()
+
+	return s.Storage.GetVersioned(key)
+}
+
+// latencyStorage.PutIfVersion Saves an entry by key with timeout after a delay if its version is version, returns the new version or error if it fails
+func (s *latencyStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	s.delay()
+
+	return s.Storage.PutIfVersion(key, value, version, expiration)
+}
+
+// latencyStorage.DeleteIfVersion Deletes an entry by key after a delay if its version is version, returns error if it fails
+func (s *latencyStorage) DeleteIfVersion(key string, version int64) error {
+	s.delay()
+
+	return s.Storage.DeleteIfVersion(key, version)
+}
+
+// latencyStorage.Exists Returns whether a key exists after a delay or error if it fails
+func (s *latencyStorage) Exists(key string) (bool, error) {
+	s.delay()
+
+	return s.Storage.Exists(key)
+}
+
+// latencyStorage.TTL Returns the time left before a key expires after a delay or error if it fails
+func (s *latencyStorage) TTL(key string) (time.Duration, error) {
+	s.delay()
+
+	return s.Storage.TTL(key)
+}
+
+// latencyStorage.ExistsMany Returns whether each key exists after a delay or error if it fails
+func (s *latencyStorage) ExistsMany(keys []string) (map[string]bool, error) {
+	s.delay()
+
+	return s.Storage.ExistsMany(keys)
+}
+
+// latencyStorage.GetPattern Returns io.Reader for a pattern after a delay or error if it fails
+func (s *latencyStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	s.delay()
+
+	return s.Storage.GetPattern(pattern, limit, offset)
+}
+
+// latencyStorage.Keys Returns the keys matching a pattern after a delay or error if it fails
+func (s *latencyStorage) Keys(pattern string) ([]string, error) {
+	s.delay()
+
+	return s.Storage.Keys(pattern)
+}
+
+// latencyStorage.CountPattern Returns count of entries matching a pattern after a delay or error if it fails
+func (s *latencyStorage) CountPattern(pattern string) (int, error) {
+	s.delay()
+
+	return s.Storage.CountPattern(pattern)
+}
+
+// latencyStorage.ExpirePattern Updates expiration of entries matching a pattern after a delay, returns count updated or error if it fails
+func (s *latencyStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	s.delay()
+
+	return s.Storage.ExpirePattern(pattern, expiration)
+}
+
+// latencyStorage.Delete Deletes an entry by key after a delay, returns error if it fails
+func (s *latencyStorage) Delete(key string) error {
+	s.delay()
+
+	return s.Storage.Delete(key)
+}
+
+// latencyStorage.Push Appends an element to the JSON array stored by key after a delay, returns error if it fails
+func (s *latencyStorage) Push(key string, element string) error {
+	s.delay()
+
+	return s.Storage.Push(key, element)
+}
+
+// latencyStorage.Pop Removes and returns the last element of the JSON array stored by key after a delay or error if it fails
+func (s *latencyStorage) Pop(key string) (string, error) {
+	s.delay()
+
+	return s.Storage.Pop(key)
+}
+
+// latencyStorage.Increment Adds delta to the integer stored by key after a delay, returns the new value or error if it fails
+func (s *latencyStorage) Increment(key string, delta int64) (int64, error) {
+	s.delay()
+
+	return s.Storage.Increment(key, delta)
+}
+
+// latencyStorage.Decrement Subtracts delta from the integer stored by key after a delay, returns the new value or error if it fails
+func (s *latencyStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// latencyStorage.DeleteAll Deletes all entries after a delay, returns error if it fails
+func (s *latencyStorage) DeleteAll() error {
+	s.delay()
+
+	return s.Storage.DeleteAll()
+}
+
+// latencyStorage.PurgeExpired Deletes all expired entries after a delay, returns the keys purged or error if it fails
+func (s *latencyStorage) PurgeExpired() ([]string, error) {
+	s.delay()
+
+	return s.Storage.PurgeExpired()
+}
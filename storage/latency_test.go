@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLatencyStorage_Delay(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	latency := 50 * time.Millisecond
+	storage := NewLatencyStorage(memory, latency, 10*time.Millisecond)
+
+	start := time.Now()
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Fatalf("expected at least: %s, found : %s", latency, elapsed)
+	}
+
+	start = time.Now()
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Fatalf("expected at least: %s, found : %s", latency, elapsed)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", chk)
+	}
+}
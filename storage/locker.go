@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// allKeys identifies the lock over every key in held locks
+const allKeys = "*"
+
+// keyedLocker hands out a read/write lock per key, lockAll excludes every key at once
+// a key lock is evicted once no goroutine holds or waits for it
+type keyedLocker struct {
+	// writes of single keys started and done, for scans sharing every key to tell whether one overlapped them
+	writesStarted uint64
+	writesDone    uint64
+
+	all   sync.RWMutex
+	mu    sync.Mutex
+	locks map[string]*keyLock
+	held  map[string]*heldLock
+	fifo  bool
+}
+
+// rwLocker is a lock shared by readers and exclusive to a writer
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+type keyLock struct {
+	rwLocker
+	refs int
+}
+
+// heldLock is since when a key has been locked by holders, readers sharing it
+type heldLock struct {
+	since   time.Time
+	holders int
+}
+
+func newKeyedLocker(fifo bool) *keyedLocker {
+	return &keyedLocker{
+		locks: map[string]*keyLock{},
+		held:  map[string]*heldLock{},
+		fifo:  fifo,
+	}
+}
+
+func (l *keyedLocker) acquired(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held, ok := l.held[key]; ok {
+		held.holders++
+		return
+	}
+
+	l.held[key] = &heldLock{since: time.Now(), holders: 1}
+}
+
+func (l *keyedLocker) released(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held := l.held[key]
+	held.holders--
+	if held.holders == 0 {
+		delete(l.held, key)
+	}
+}
+
+// Held Returns currently held locks by key with how long they have been held
+func (l *keyedLocker) Held() map[string]time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held := make(map[string]time.Duration, len(l.held))
+	for key, lock := range l.held {
+		held[key] = time.Since(lock.since)
+	}
+
+	return held
+}
+
+// acquire Returns the lock for key referenced by the caller
+func (l *keyedLocker) acquire(key string) rwLocker {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		if l.fifo {
+			lock.rwLocker = &fifoMutex{}
+		} else {
+			// a pending writer blocks new readers, so that a hot key cannot starve writers
+			lock.rwLocker = &sync.RWMutex{}
+		}
+
+		l.locks[key] = lock
+	}
+
+	lock.refs++
+
+	return lock.rwLocker
+}
+
+// release Drops the caller reference to the lock for key, evicting it when unreferenced
+func (l *keyedLocker) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock := l.locks[key]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// Lock Locks key
+func (l *keyedLocker) Lock(key string) {
+	l.all.RLock()
+	l.acquire(key).Lock()
+	l.acquired(key)
+	atomic.AddUint64(&l.writesStarted, 1)
+}
+
+// Unlock Unlocks key
+func (l *keyedLocker) Unlock(key string) {
+	l.mu.Lock()
+	lock := l.locks[key].rwLocker
+	l.mu.Unlock()
+
+	atomic.AddUint64(&l.writesDone, 1)
+	l.released(key)
+	lock.Unlock()
+	l.release(key)
+	l.all.RUnlock()
+}
+
+// RLock Locks key for reading, shared with other readers
+func (l *keyedLocker) RLock(key string) {
+	l.all.RLock()
+	l.acquire(key).RLock()
+	l.acquired(key)
+}
+
+// RUnlock Unlocks key for reading
+func (l *keyedLocker) RUnlock(key string) {
+	l.mu.Lock()
+	lock := l.locks[key].rwLocker
+	l.mu.Unlock()
+
+	l.released(key)
+	lock.RUnlock()
+	l.release(key)
+	l.all.RUnlock()
+}
+
+// LockAll Locks every key
+func (l *keyedLocker) LockAll() {
+	l.all.Lock()
+	l.acquired(allKeys)
+}
+
+// UnlockAll Unlocks every key
+func (l *keyedLocker) UnlockAll() {
+	l.released(allKeys)
+	l.all.Unlock()
+}
+
+// RLockAll Locks every key for reading, shared with readers and writers of single keys, excluding LockAll only
+func (l *keyedLocker) RLockAll() {
+	l.all.RLock()
+	l.acquired(allKeys)
+}
+
+// RUnlockAll Unlocks every key for reading
+func (l *keyedLocker) RUnlockAll() {
+	l.released(allKeys)
+	l.all.RUnlock()
+}
+
+// WritesDone Returns how many writes of single keys are done, to be checked with QuietSince
+func (l *keyedLocker) WritesDone() uint64 {
+	return atomic.LoadUint64(&l.writesDone)
+}
+
+// QuietSince Returns whether no write of a single key was done since WritesDone returned done, nor is in progress
+func (l *keyedLocker) QuietSince(done uint64) bool {
+	// loading done first, a write done in between is seen started but not done
+	current := atomic.LoadUint64(&l.writesDone)
+
+	return current == done && atomic.LoadUint64(&l.writesStarted) == current
+}
+
+// fifoMutex is a mutex granted to waiters strictly in arrival order, readers being exclusive as writers
+type fifoMutex struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters []chan struct{}
+}
+
+func (m *fifoMutex) Lock() {
+	m.mu.Lock()
+	if !m.locked {
+		m.locked = true
+		m.mu.Unlock()
+		return
+	}
+
+	wait := make(chan struct{})
+	m.waiters = append(m.waiters, wait)
+	m.mu.Unlock()
+
+	<-wait
+}
+
+func (m *fifoMutex) Unlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.waiters) == 0 {
+		m.locked = false
+		return
+	}
+
+	// ownership is handed over to the first waiter, locked stays true
+	close(m.waiters[0])
+	m.waiters = m.waiters[1:]
+}
+
+func (m *fifoMutex) RLock() {
+	m.Lock()
+}
+
+func (m *fifoMutex) RUnlock() {
+	m.Unlock()
+}
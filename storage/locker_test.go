@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (m *fifoMutex) queued() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.waiters)
+}
+
+func TestMemoryStorage_PutFIFOWrites(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, FIFOWrites())
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage.locks.Lock("a key")
+	lock := storage.locks.locks["a key"].rwLocker.(*fifoMutex)
+
+	writers := 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+
+			if err := storage.Put("a key", value, time.Duration(-1)); err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+		}(fmt.Sprintf("value %d", i))
+
+		for lock.queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	storage.locks.Unlock("a key")
+	wg.Wait()
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := fmt.Sprintf("value %d", writers-1)
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestMemoryStorage_HeldLocks(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage.locks.Lock("a key")
+	time.Sleep(10 * time.Millisecond)
+
+	held := storage.HeldLocks()
+	if chk, ok := held["a key"]; !ok || chk <= 0 {
+		t.Fatalf("expected held lock, found : %v", held)
+	}
+
+	storage.locks.Unlock("a key")
+
+	held = storage.HeldLocks()
+	if len(held) != 0 {
+		t.Fatalf("expected no held locks, found : %v", held)
+	}
+}
+
+func TestMemoryStorage_LocksEviction(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// a flush waiting for every key would block the writes while "a key" is held
+	storage.ticker.Stop()
+
+	storage.locks.Lock("a key")
+
+	for i := 0; i < 1000000; i++ {
+		err = storage.Put(fmt.Sprintf("key %d", i), "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	if chk := len(storage.locks.locks); chk != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, chk)
+	}
+
+	storage.locks.Unlock("a key")
+
+	if chk := len(storage.locks.locks); chk != 0 {
+		t.Fatalf("expected: %d, found : %d", 0, chk)
+	}
+}
+
+func TestMemoryStorage_ReadersShareKeyLock(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage.locks.RLock("a key")
+	defer storage.locks.RUnlock("a key")
+
+	done := make(chan error)
+	go func() {
+		_, err := storage.Get("a key")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Get not to wait for another reader")
+	}
+}
+
+func TestMemoryStorage_WritersNotStarvedByReaders(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "value 0", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				if _, err := storage.Get("a key"); err != nil {
+					t.Errorf("err not expected: %s", err)
+					return
+				}
+			}
+		}()
+	}
+
+	writes := 20
+	for i := 1; i <= writes; i++ {
+		done := make(chan error)
+		go func(value string) {
+			done <- storage.Put("a key", value, time.Duration(-1))
+		}(fmt.Sprintf("value %d", i))
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("err not expected: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("writer starved by readers at write %d", i)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := fmt.Sprintf("value %d", writes)
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestKeyedLocker_ConcurrentKeys(t *testing.T) {
+	locker := newKeyedLocker(false)
+
+	// readers of a key share its lock
+	locker.RLock("a key")
+
+	done := make(chan struct{})
+	go func() {
+		locker.RLock("a key")
+		locker.RUnlock("a key")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected readers to share the lock of a key")
+	}
+
+	locker.RUnlock("a key")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				locker.Lock(key)
+				locker.Unlock(key)
+
+				locker.RLock(key)
+				locker.RUnlock(key)
+			}
+		}(fmt.Sprintf("key %d", i))
+	}
+
+	wg.Wait()
+
+	// released key locks are evicted, the locks do not grow with the keys ever locked
+	if len(locker.locks) != 0 || len(locker.held) != 0 {
+		t.Fatalf("expected: %d, found : %d locks, %d held", 0, len(locker.locks), len(locker.held))
+	}
+}
+
+func TestMemoryStorage_ScansShareKeyLocks(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	assertScansShareKeyLocks(t, storage, storage.locks)
+
+	// scans run along writes of new keys
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(key string) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if err := storage.Put(fmt.Sprintf("%s %d", key, j), "a value", time.Duration(-1)); err != nil {
+					t.Errorf("err not expected: %s", err)
+				}
+			}
+		}(fmt.Sprintf("key %d", i))
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if _, err := storage.GetPattern("key*", 0, 0); err != nil {
+					t.Errorf("err not expected: %s", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	count, err := storage.CountPattern("key*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if count != 500 {
+		t.Fatalf("expected: %d, found : %d", 500, count)
+	}
+}
+
+func TestFileSystemStorage_ScansShareKeyLocks(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	storage, err := NewFileSystemStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	assertScansShareKeyLocks(t, storage, storage.locks)
+}
+
+// assertScansShareKeyLocks Asserts listings, key names and counts do not wait for a reader of a key
+func assertScansShareKeyLocks(t *testing.T, storage Storage, locks *keyedLocker) {
+	err := storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	locks.RLock("a key")
+	defer locks.RUnlock("a key")
+
+	done := make(chan error)
+	go func() {
+		if _, err := storage.GetPattern("*", 0, 0); err != nil {
+			done <- err
+			return
+		}
+
+		if _, err := storage.Keys("*"); err != nil {
+			done <- err
+			return
+		}
+
+		_, err := storage.CountPattern("*")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected scans not to wait for a reader")
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import "sync"
+
+// maintenance Bounds how many heavy background tasks of a storage, as snapshot flushes
+// and index reconciles, run at once
+type maintenance struct {
+	slots chan struct{}
+}
+
+func newMaintenance(max int) *maintenance {
+	if max < 1 {
+		max = 1
+	}
+
+	return &maintenance{
+		slots: make(chan struct{}, max),
+	}
+}
+
+// maintenance.run Runs task once a slot is free, logging its error
+func (m *maintenance) run(name string, task func() error) {
+	m.slots <- struct{}{}
+	defer func() {
+		<-m.slots
+	}()
+
+	if err := task(); err != nil {
+		logger.Warnf("error in %s: %s", name, err)
+	}
+}
+
+// evictionRoute Routes the background evictions of a storage through the storage decorating it, so decorators
+// as the eviction webhook see the keys purged
+type evictionRoute struct {
+	mu      sync.Mutex
+	through Storage
+}
+
+// evictionRouter is implemented by storages evicting expired entries in background
+type evictionRouter interface {
+	// routeEvictions Purges the expired entries evicted in background through storage
+	routeEvictions(storage Storage)
+}
+
+// evictionRoute.set Routes the evictions through storage
+func (r *evictionRoute) set(storage Storage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.through = storage
+}
+
+// evictionRoute.purge Purges the expired entries through the storage routed to, own if none was
+func (r *evictionRoute) purge(own Storage) ([]string, error) {
+	r.mu.Lock()
+	through := r.through
+	r.mu.Unlock()
+
+	if through == nil {
+		through = own
+	}
+
+	return through.PurgeExpired()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaintenance_NoOverlap(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	var active, maxActive int32
+	track := func(task func() error) func() error {
+		return func() error {
+			current := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+
+			for {
+				seen := atomic.LoadInt32(&maxActive)
+				if current <= seen || atomic.CompareAndSwapInt32(&maxActive, seen, current) {
+					break
+				}
+			}
+
+			return task()
+		}
+	}
+
+	sweep := func() error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			storage.maintenance.run("flush", track(storage.dumpToFilesystem))
+		}()
+		go func() {
+			defer wg.Done()
+			storage.maintenance.run("sweep", track(sweep))
+		}()
+	}
+
+	wg.Wait()
+
+	if maxActive != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, maxActive)
+	}
+}
+
+func TestMemoryStorage_PutDuringMaintenance(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 0; i < 100000; i++ {
+		storage.data[fmt.Sprintf("key %d", i)] = entry{Key: fmt.Sprintf("key %d", i), Value: []byte("a value"), Expiration: -1}
+	}
+
+	start := time.Now()
+	if err := storage.dumpToFilesystem(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+	dumpDuration := time.Since(start)
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				storage.maintenance.run("flush", storage.dumpToFilesystem)
+			}
+		}
+	}()
+
+	var slowest time.Duration
+	for i := 0; i < 200; i++ {
+		start := time.Now()
+		err = storage.Put("a key", "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > slowest {
+			slowest = elapsed
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	close(done)
+	wg.Wait()
+
+	if slowest >= dumpDuration {
+		t.Fatalf("expected put latency below: %s, found : %s", dumpDuration, slowest)
+	}
+}
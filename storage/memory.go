@@ -4,11 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,60 +15,101 @@ import (
 
 const memoryCacheFile = "memory.db"
 
-var logger *logrus.Logger
+var errFlushFailing = fmt.Errorf("memory storage snapshot keeps failing: writes are refused until it is persisted again")
 
 type memoryStorage struct {
-	storageDir   string
-	storageCache *os.File
-	locks        map[string]*sync.Mutex
-	data         map[string]entry
-	ticker       *time.Ticker
-	quit         chan bool
+	storageDir    string
+	storageCache  *os.File
+	locks         *keyedLocker
+	typedKeys     bool
+	creationTimes bool
+	maxAge        time.Duration
+	grace         time.Duration
+	data          map[string]entry
+	dataMu        sync.RWMutex
+	dumpMu        sync.Mutex
+	snapshot      snapshotState
+	maintenance   *maintenance
+	eviction      *evictionRoute
+	ticker        *time.Ticker
+	quit          chan bool
+
+	flushMu            sync.Mutex
+	flushFailures      int
+	flushFailuresTotal int64
+	lastFlushErr       error
+	maxFlushFailures   int
 }
 
 // NewMemoryStorage Factory for memory storage
 // saves db to `storageDir/memory.db`
-func NewMemoryStorage(storageDir string) (*memoryStorage, error) {
-	logger = logrus.New()
-	logger.Out = os.Stdout
+func NewMemoryStorage(storageDir string, options ...OptionFn) (*memoryStorage, error) {
+	config := newConfig(options)
 
-	storageCache, err := getWriter(storageDir, memoryCacheFile)
-	if err != nil {
+	if err := checkStorageDir(storageDir); err != nil {
 		return nil, err
 	}
 
-	cache, err := ioutil.ReadAll(storageCache)
+	storageCache, err := getWriter(storageDir, memoryCacheFile)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(cache) == 0 {
-		cache = []byte("{}")
+	info, err := storageCache.Stat()
+	if err != nil {
+		return nil, err
 	}
 
-	var data map[string]entry
-	err = json.Unmarshal(cache, &data)
+	data, err := loadSnapshot(storageCache, info.Size(), config.snapshotLoadTimeout)
 	if err != nil {
+		storageCache.Close()
 		return nil, err
 	}
 
 	storage := &memoryStorage{
-		storageDir:   storageDir,
-		storageCache: storageCache,
-		data:         data,
-		locks:        map[string]*sync.Mutex{},
-		ticker:       time.NewTicker(15 * time.Second),
-		quit:         make(chan bool),
+		storageDir:    storageDir,
+		storageCache:  storageCache,
+		data:          data,
+		locks:         newKeyedLocker(config.fifoWrites),
+		typedKeys:     config.typedKeys,
+		creationTimes: config.creationTimes,
+		maxAge:        config.maxAge,
+		grace:         config.expiryGrace,
+		maintenance:   newMaintenance(config.maxMaintenance),
+		eviction:      &evictionRoute{},
+		ticker:        time.NewTicker(15 * time.Second),
+		quit:          make(chan bool),
+
+		maxFlushFailures: config.maxFlushFailures,
 	}
 
+	storage.snapshot = newSnapshotState(data, info)
+
 	go func() {
+		// a nil channel never receives, leaving what is not configured off
+		var eviction, reload <-chan time.Time
+		if config.evictionInterval > 0 {
+			evictionTicker := time.NewTicker(config.evictionInterval)
+			defer evictionTicker.Stop()
+
+			eviction = evictionTicker.C
+		}
+
+		if config.snapshotReload > 0 {
+			reloadTicker := time.NewTicker(config.snapshotReload)
+			defer reloadTicker.Stop()
+
+			reload = reloadTicker.C
+		}
+
 		for {
 			select {
 			case <-storage.ticker.C:
-				err := storage.dumpToFilesystem()
-				if err != nil {
-					logger.Debugf("error in memory storage cache: %s", err)
-				}
+				storage.maintenance.run("memory storage cache", storage.flush)
+			case <-eviction:
+				storage.maintenance.run("memory storage eviction", storage.evict)
+			case <-reload:
+				storage.maintenance.run("memory storage snapshot reload", storage.reload)
 			case <-storage.quit:
 				storage.ticker.Stop()
 				return
@@ -94,156 +134,866 @@ func (s *memoryStorage) IsNotExist(err error) bool {
 	return err == errNotExists
 }
 
-func (s *memoryStorage) lockAll() {
-	for key := range s.locks {
-		s.locks[key].Lock()
-	}
+// memoryStorage.IsConflict Returns if err is for an operation incompatible with the stored value
+func (s *memoryStorage) IsConflict(err error) bool {
+	return isConflict(err)
 }
 
-func (s *memoryStorage) unlockAll() {
-	for key := range s.locks {
-		s.locks[key].Unlock()
-	}
+// memoryStorage.IsVersionMismatch Returns if err is for a conditional write against another version of the entry
+func (s *memoryStorage) IsVersionMismatch(err error) bool {
+	return err == errVersionMismatch
 }
 
-func (s *memoryStorage) lock(key string) {
-	if _, ok := s.locks[key]; !ok {
-		s.locks[key] = &sync.Mutex{}
-	}
+// memoryStorage.IsForbidden Returns if err is for an operation not allowed on the storage, none is
+func (s *memoryStorage) IsForbidden(err error) bool {
+	return false
+}
 
-	s.locks[key].Lock()
+// memoryStorage.IsUnavailable Returns if err is for writes being refused after too many failed snapshot flushes
+func (s *memoryStorage) IsUnavailable(err error) bool {
+	return err == errFlushFailing
 }
 
-func (s *memoryStorage) unlock(key string) {
-	s.locks[key].Unlock()
+// memoryStorage.HeldLocks Returns currently held key locks with how long they have been held
+func (s *memoryStorage) HeldLocks() map[string]time.Duration {
+	return s.locks.Held()
 }
 
 // memoryStorage.Get Returns io.Reader for a key or error if it fails
 func (s *memoryStorage) Get(key string) (io.Reader, error) {
 	r := bytes.NewReader(nil)
 
-	s.lock(key)
-	defer s.unlock(key)
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
 
-	if entry, ok := s.data[key]; !ok {
+	if entry, ok := s.getEntry(key); !ok {
 		return r, errNotExists
 
-	} else if !isExpired(entry.Expiration) {
+	} else if !s.expired(entry.Expiration, entry.Created) {
 		return bytes.NewReader(entry.Value), nil
 	}
 
 	return r, errNotExists
 }
 
-// memoryStorage.Get Returns io.Reader for a pattern or error if it fails
-func (s *memoryStorage) GetPattern(pattern string) (io.Reader, error) {
-	s.lockAll()
-	defer s.unlockAll()
+// memoryStorage.GetMany Returns the values of the existing keys or error if it fails
+func (s *memoryStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// memoryStorage.GetStale Returns io.Reader for a key, still served during the grace period after it expired,
+// whether it is stale for having expired or error if it fails
+func (s *memoryStorage) GetStale(key string) (io.Reader, bool, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || (s.expired(current.Expiration, current.Created) && !s.stale(current.Expiration, current.Created)) {
+		return bytes.NewReader(nil), false, errNotExists
+	}
+
+	return bytes.NewReader(current.Value), s.expired(current.Expiration, current.Created), nil
+}
+
+// memoryStorage.GetVersioned Returns io.Reader for a key with its version or error if it fails
+func (s *memoryStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		return bytes.NewReader(nil), 0, errNotExists
+	}
+
+	return bytes.NewReader(current.Value), current.Version, nil
+}
+
+// memoryStorage.GetMetadata Returns the metadata stored along the value of a key, with its creation time if recorded,
+// stale ones included, or error if it fails
+func (s *memoryStorage) GetMetadata(key string) (Metadata, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || (s.expired(current.Expiration, current.Created) && !s.stale(current.Expiration, current.Created)) {
+		return nil, errNotExists
+	}
+
+	return withCreatedAt(s.creationTimes, current), nil
+}
+
+// memoryStorage.GetAndTouch Returns io.Reader for a key sliding its expiration window from now if less than threshold is left, or error if it fails
+func (s *memoryStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		return bytes.NewReader(nil), errNotExists
+	}
+
+	if expiration, slid := slideExpiration(current.Expiration, window, threshold); slid {
+		if err := s.checkFlushing(); err != nil {
+			return bytes.NewReader(nil), err
+		}
+
+		current.Expiration = expiration
+		s.setEntry(key, current)
+	}
+
+	return bytes.NewReader(current.Value), nil
+}
+
+// memoryStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, and whether it was created or error if it fails
+func (s *memoryStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	if entry, ok := s.getEntry(key); ok && !s.expired(entry.Expiration, entry.Created) {
+		return entry.Value, false, nil
+	}
+
+	if err := s.checkFlushing(); err != nil {
+		return nil, false, err
+	}
+
+	s.setEntry(key, entry{
+		Key:        key,
+		Value:      []byte(defaultValue),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    now().UnixNano(),
+		Version:    1,
+	})
+
+	return []byte(defaultValue), true, nil
+}
+
+// memoryStorage.PutIfTTLBelow Saves an entry by key with timeout only if missing or its remaining TTL is under threshold,
+// keeping its metadata, returns whether it was saved or error if it fails
+func (s *memoryStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	if err := s.checkFlushing(); err != nil {
+		return false, err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		current = entry{Key: key}
+	} else if !ttlBelow(current.Expiration, threshold) {
+		return false, nil
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return false, err
+	}
+
+	s.setEntry(key, entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   current.Metadata,
+	})
+
+	return true, nil
+}
+
+// memoryStorage.CompareAndSwap Saves an entry by key with timeout only if its value is oldValue, keeping its metadata, returns whether it was saved or error if it fails
+func (s *memoryStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	if err := s.checkFlushing(); err != nil {
+		return false, err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) || string(current.Value) != oldValue {
+		return false, nil
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return false, err
+	}
+
+	s.setEntry(key, entry{
+		Key:        key,
+		Value:      []byte(newValue),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   current.Metadata,
+	})
+
+	return true, nil
+}
+
+// memoryStorage.PutIfVersion Saves an entry by key with timeout only if its version is version, 0 for a missing key, keeping its metadata,
+// returns the new version or error if it fails
+func (s *memoryStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	if err := s.checkFlushing(); err != nil {
+		return 0, err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		current = entry{Key: key}
+	}
+
+	if current.Version != version {
+		return 0, errVersionMismatch
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return 0, err
+	}
+
+	s.setEntry(key, entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    version + 1,
+		Metadata:   current.Metadata,
+	})
+
+	return version + 1, nil
+}
+
+// memoryStorage.PutIfEmpty Saves an entry by key with timeout only if no key starting with prefix exists, key being one of them,
+// returns whether it was saved or error if it fails
+func (s *memoryStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	if err := s.checkFlushing(); err != nil {
+		return false, err
+	}
+
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
 
-	ret := make([]string, 0, len(s.data))
 	for _, entry := range s.data {
+		if strings.HasPrefix(entry.Key, prefix) && !s.expired(entry.Expiration, entry.Created) {
+			return false, nil
+		}
+	}
+
+	s.setEntry(key, entry{
+		Key:        key,
+		Value:      []byte(value),
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    now().UnixNano(),
+		Version:    1,
+	})
+
+	return true, nil
+}
+
+func (s *memoryStorage) exists(key string) bool {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	entry, ok := s.getEntry(key)
+
+	return ok && !s.expired(entry.Expiration, entry.Created)
+}
+
+// memoryStorage.Exists Returns whether a key exists and is not expired, without copying its value, or error if it fails
+func (s *memoryStorage) Exists(key string) (bool, error) {
+	return s.exists(key), nil
+}
+
+// memoryStorage.TTL Returns the time left before a key expires, -1 if it never does, or error if it fails
+func (s *memoryStorage) TTL(key string) (time.Duration, error) {
+	s.locks.RLock(key)
+	defer s.locks.RUnlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		return 0, errNotExists
+	}
+
+	return remainingTTL(current.Expiration, current.Created, s.maxAge), nil
+}
+
+// memoryStorage.ExistsMany Returns whether each key exists or error if it fails
+func (s *memoryStorage) ExistsMany(keys []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		exists[key] = s.exists(key)
+	}
+
+	return exists, nil
+}
+
+// memoryStorage.Get Returns io.Reader for a pattern or error if it fails, paged by key skipping offset entries
+// and returning at most limit of them unless limit is 0
+func (s *memoryStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	s.locks.RLockAll()
+	defer s.locks.RUnlockAll()
+
+	// writers of single keys wait for the scan, other readers share it
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+
+	keys := make([]string, 0, len(s.data))
+	for key, entry := range s.data {
 		if ok, err := filepath.Match(pattern, entry.Key); !ok || err != nil {
 			continue
 		}
 
-		if !isExpired(entry.Expiration) {
-			ret = append(ret, fmt.Sprintf(`{"%s":"%s"}`, entry.Key, entry.Value))
+		if !s.expired(entry.Expiration, entry.Created) {
+			keys = append(keys, key)
 		}
 	}
 
-	r := bytes.NewReader([]byte(fmt.Sprintf("[%s]", strings.Join(ret, ","))))
+	keys = pageKeys(keys, limit, offset)
+	ret := make([]entry, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, s.data[key])
+	}
 
-	return r, nil
+	return patternReader(ret)
+}
+
+// memoryStorage.Keys Returns the sorted keys matching a pattern or error if it fails
+func (s *memoryStorage) Keys(pattern string) ([]string, error) {
+	s.locks.RLockAll()
+	defer s.locks.RUnlockAll()
+
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+
+	keys := make([]string, 0)
+	for key, entry := range s.data {
+		if ok, err := filepath.Match(pattern, entry.Key); !ok || err != nil {
+			continue
+		}
+
+		if !s.expired(entry.Expiration, entry.Created) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// memoryStorage.CountPattern Returns count of entries matching a pattern or error if it fails
+func (s *memoryStorage) CountPattern(pattern string) (int, error) {
+	s.locks.RLockAll()
+	defer s.locks.RUnlockAll()
+
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+
+	count := 0
+	for _, entry := range s.data {
+		if ok, err := filepath.Match(pattern, entry.Key); !ok || err != nil {
+			continue
+		}
+
+		if !s.expired(entry.Expiration, entry.Created) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// memoryStorage.ExpirePattern Updates expiration of entries matching a pattern, returns count updated or error if it fails
+func (s *memoryStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	if err := s.checkFlushing(); err != nil {
+		return 0, err
+	}
+
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	newExpiration := expirationTime(expiration)
+
+	updated := 0
+	for key, entry := range s.data {
+		if ok, err := filepath.Match(pattern, entry.Key); !ok || err != nil {
+			continue
+		}
+
+		if s.expired(entry.Expiration, entry.Created) {
+			continue
+		}
+
+		entry.Expiration = newExpiration
+		s.setEntry(key, entry)
+		updated++
+	}
+
+	return updated, nil
 }
 
 // memoryStorage.Delete Deletes an entry by key, returns error if it fails
 func (s *memoryStorage) Delete(key string) error {
-	s.lock(key)
-	defer s.unlock(key)
+	if err := s.checkFlushing(); err != nil {
+		return err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
 
-	if _, ok := s.data[key]; !ok {
+	if _, ok := s.getEntry(key); !ok {
 		return errNotExists
 
 	}
 
-	delete(s.data, key)
+	s.deleteEntry(key)
+
+	return nil
+}
+
+// memoryStorage.DeleteIfVersion Deletes an entry by key only if its version is version, returns error if it fails
+func (s *memoryStorage) DeleteIfVersion(key string, version int64) error {
+	if err := s.checkFlushing(); err != nil {
+		return err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		return errNotExists
+	}
+
+	if current.Version != version {
+		return errVersionMismatch
+	}
+
+	s.deleteEntry(key)
 
 	return nil
 }
 
 // memoryStorage.DeleteAll Deletes all entries, returns error if it fails
 func (s *memoryStorage) DeleteAll() error {
-	s.lockAll()
-	defer s.unlockAll()
+	if err := s.checkFlushing(); err != nil {
+		return err
+	}
+
+	// persist the emptied state right away, flushes are blocked until it is
+	s.dumpMu.Lock()
+	defer s.dumpMu.Unlock()
 
+	s.locks.LockAll()
 	for key := range s.data {
-		delete(s.data, key)
+		s.deleteEntry(key)
 	}
+	s.locks.UnlockAll()
 
-	return nil
+	return s.recordFlush(s.writeSnapshot(map[string]entry{}))
+}
+
+// memoryStorage.PurgeExpired Deletes all expired entries past the grace period, returns the keys purged or error if it fails
+func (s *memoryStorage) PurgeExpired() ([]string, error) {
+	if err := s.checkFlushing(); err != nil {
+		return nil, err
+	}
+
+	s.locks.LockAll()
+	defer s.locks.UnlockAll()
+
+	purged := []string{}
+	for key, entry := range s.data {
+		if s.expired(entry.Expiration, entry.Created) && !s.stale(entry.Expiration, entry.Created) {
+			s.deleteEntry(key)
+			purged = append(purged, key)
+		}
+	}
+
+	return purged, nil
+}
+
+// memoryStorage.routeEvictions Purges the expired entries evicted in background through storage
+func (s *memoryStorage) routeEvictions(storage Storage) {
+	s.eviction.set(storage)
+}
+
+// evict Purges expired entries through the storage decorating this one, run in background every eviction interval
+func (s *memoryStorage) evict() error {
+	purged, err := s.eviction.purge(s)
+	if len(purged) > 0 {
+		logger.Debugf("memory storage evicted %d expired entries", len(purged))
+	}
+
+	return err
 }
 
 // memoryStorage.Put Saves an entry by key with timeout, returns error if it fails
 func (s *memoryStorage) Put(key string, value string, expiration time.Duration) error {
-	s.lock(key)
-	defer s.unlock(key)
+	return s.PutWithMetadata(key, value, nil, expiration)
+}
+
+// memoryStorage.PutMany Saves every entry by key with timeout, returns a PutManyError of the keys that failed
+func (s *memoryStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// memoryStorage.PutWithMetadata Saves an entry by key with timeout along metadata, returns error if it fails
+func (s *memoryStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	if err := s.checkFlushing(); err != nil {
+		return err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
 
-	var newExpiration int64
-	if expiration != noExpiration {
-		newExpiration = time.Now().Add(expiration).UnixNano()
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		current = entry{Key: key}
+	}
+
+	if err := s.checkType(current, typeString); err != nil {
+		return err
 	}
 
 	newEntry := entry{
 		Key:        key,
 		Value:      []byte(value),
-		Expiration: newExpiration,
+		Expiration: expirationTime(expiration),
+		Type:       typeString,
+		Created:    createdTime(current),
+		Version:    current.Version + 1,
+		Metadata:   metadata,
 	}
 
-	s.data[key] = newEntry
+	s.setEntry(key, newEntry)
 
 	return nil
 }
 
+// memoryStorage.Push Appends an element to the JSON array stored by key, returns error if it fails
+func (s *memoryStorage) Push(key string, element string) error {
+	if err := s.checkFlushing(); err != nil {
+		return err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		current = entry{Key: key, Created: now().UnixNano()}
+	}
+
+	if err := s.checkType(current, typeList); err != nil {
+		return err
+	}
+
+	value, err := pushElement(current.Value, element)
+	if err != nil {
+		return err
+	}
+
+	current.Value = value
+	current.Type = typeList
+	current.Version++
+	s.setEntry(key, current)
+
+	return nil
+}
+
+// memoryStorage.Pop Removes and returns the last element of the JSON array stored by key or error if it fails
+func (s *memoryStorage) Pop(key string) (string, error) {
+	if err := s.checkFlushing(); err != nil {
+		return "", err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		return "", errNotExists
+	}
+
+	if err := s.checkType(current, typeList); err != nil {
+		return "", err
+	}
+
+	value, element, err := popElement(current.Value)
+	if err != nil {
+		return "", err
+	}
+
+	current.Value = value
+	current.Version++
+	s.setEntry(key, current)
+
+	return element, nil
+}
+
+// memoryStorage.Increment Adds delta to the integer stored by key, a missing key counting as 0, returns the new value or error if it fails
+func (s *memoryStorage) Increment(key string, delta int64) (int64, error) {
+	if err := s.checkFlushing(); err != nil {
+		return 0, err
+	}
+
+	s.locks.Lock(key)
+	defer s.locks.Unlock(key)
+
+	current, ok := s.getEntry(key)
+	if !ok || s.expired(current.Expiration, current.Created) {
+		current = entry{Key: key, Created: now().UnixNano()}
+	}
+
+	if err := s.checkType(current, typeCounter); err != nil {
+		return 0, err
+	}
+
+	value, counter, err := incrementValue(current.Value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	current.Value = value
+	current.Type = typeCounter
+	current.Version++
+	s.setEntry(key, current)
+
+	return counter, nil
+}
+
+// memoryStorage.Decrement Subtracts delta from the integer stored by key, returns the new value or error if it fails
+func (s *memoryStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
 // memoryStorage.Flush Flushes storage
 func (s *memoryStorage) Flush() {
-	err := s.dumpToFilesystem()
-	if err != nil {
-		logger.Debugf("error in memory storage cache: %s", err)
+	if err := s.flush(); err != nil {
+		logger.Warnf("error in memory storage cache: %s", err)
 	}
 
 	s.quit <- true
 }
 
+// memoryStorage.Health Reports the storage degraded while its snapshot flushes fail
+func (s *memoryStorage) Health() Health {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	health := Health{
+		FlushFailures:      s.flushFailures,
+		FlushFailuresTotal: s.flushFailuresTotal,
+	}
+
+	if s.flushFailures > 0 {
+		health.Degraded = true
+		health.Reason = fmt.Sprintf("memory storage snapshot failed %d consecutive times: %s", s.flushFailures, s.lastFlushErr)
+	}
+
+	return health
+}
+
+// flush Persists the snapshot, tracking consecutive failures
+func (s *memoryStorage) flush() error {
+	return s.recordFlush(s.dumpToFilesystem())
+}
+
+// recordFlush Tracks the outcome of a snapshot write, returning err with the consecutive failures so far
+func (s *memoryStorage) recordFlush(err error) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	if err == nil {
+		s.flushFailures = 0
+		s.lastFlushErr = nil
+		return nil
+	}
+
+	s.flushFailures++
+	s.flushFailuresTotal++
+	s.lastFlushErr = err
+
+	return fmt.Errorf("%s (%d consecutive failures)", err, s.flushFailures)
+}
+
+// checkFlushing Returns errFlushFailing once snapshot flushes failed more than the configured consecutive times
+func (s *memoryStorage) checkFlushing() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	if s.maxFlushFailures > 0 && s.flushFailures >= s.maxFlushFailures {
+		return errFlushFailing
+	}
+
+	return nil
+}
+
+// checkType Returns errWrongType if typed keys are enforced and current holds another type than valueType
+func (s *memoryStorage) checkType(current entry, valueType string) error {
+	if !s.typedKeys {
+		return nil
+	}
+
+	return checkType(current, valueType)
+}
+
+// expired Returns whether an entry is past its expiration or older than the max age
+func (s *memoryStorage) expired(expiration int64, created int64) bool {
+	return isExpired(expiration) || isTooOld(created, s.maxAge)
+}
+
+// stale Returns whether an entry expired within the grace period, never if older than the max age
+func (s *memoryStorage) stale(expiration int64, created int64) bool {
+	return isStale(expiration, s.grace) && !isTooOld(created, s.maxAge)
+}
+
+// getEntry Returns the entry stored by key, caller must hold a lock of the key
+func (s *memoryStorage) getEntry(key string) (entry, bool) {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+
+	current, ok := s.data[key]
+
+	return current, ok
+}
+
+// setEntry Stores the entry by key, caller must hold the write lock of the key
+func (s *memoryStorage) setEntry(key string, current entry) {
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+
+	s.data[key] = current
+}
+
+// deleteEntry Deletes the entry stored by key, caller must hold the write lock of the key
+func (s *memoryStorage) deleteEntry(key string) {
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+
+	delete(s.data, key)
+}
+
+// dumpToFilesystem Writes a snapshot of data to the cache file,
+// the lock over every key is held only while copying data so requests are served during the write
 func (s *memoryStorage) dumpToFilesystem() error {
-	s.lockAll()
-	defer s.unlockAll()
+	s.dumpMu.Lock()
+	defer s.dumpMu.Unlock()
 
-	f, err := getWriter(s.storageDir, memoryCacheFile)
+	s.locks.LockAll()
+	snapshot := make(map[string]entry, len(s.data))
+	for key, entry := range s.data {
+		snapshot[key] = entry
+	}
+	s.locks.UnlockAll()
+
+	return s.writeSnapshot(snapshot)
+}
+
+// writeSnapshot Replaces the cache file with snapshot, recorded as the last one synced, dumpMu must be held
+func (s *memoryStorage) writeSnapshot(snapshot map[string]entry) error {
+	data, err := json.Marshal(snapshot)
 	if err != nil {
 		return err
 	}
 
-	err = f.Truncate(0)
+	if err := replaceSnapshot(s.storageDir, memoryCacheFile, data); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filepath.Join(s.storageDir, memoryCacheFile))
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Seek(0, 0)
+	s.snapshot = newSnapshotState(snapshot, info)
+
+	return nil
+}
+
+// reload Loads the cache file again if another process replaced it,
+// entries set or deleted since the last sync are kept over the ones of the file
+func (s *memoryStorage) reload() error {
+	s.dumpMu.Lock()
+	defer s.dumpMu.Unlock()
+
+	info, err := os.Stat(filepath.Join(s.storageDir, memoryCacheFile))
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
 		return err
 	}
 
-	data, err := json.Marshal(s.data)
+	if !s.snapshot.replaced(info) {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(s.storageDir, memoryCacheFile))
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write(data)
+	defer f.Close()
+
+	external, err := loadSnapshot(f, info.Size(), 0)
 	if err != nil {
 		return err
 	}
 
-	err = f.Sync()
+	// local changes are left out of the new state, so that they are still told apart from the file
+	state := newSnapshotState(external, info)
 
-	return err
+	s.locks.LockAll()
+	kept := 0
+	for key, current := range s.data {
+		if s.snapshot.changedLocally(key, current, true) {
+			delete(state.synced, key)
+			kept++
+			continue
+		}
+
+		if replacement, ok := external[key]; ok {
+			s.setEntry(key, replacement)
+		} else {
+			s.deleteEntry(key)
+		}
+	}
+
+	for key, replacement := range external {
+		if _, ok := s.getEntry(key); ok {
+			continue
+		}
+
+		if s.snapshot.changedLocally(key, entry{}, false) {
+			kept++
+			continue
+		}
+
+		s.setEntry(key, replacement)
+	}
+	s.locks.UnlockAll()
+
+	s.snapshot = state
+	logger.Infof("memory storage reloaded snapshot changed by another process")
+	if kept > 0 {
+		logger.Warnf("memory storage kept %d entries changed since the last flush over the reloaded snapshot", kept)
+	}
+
+	return nil
 }
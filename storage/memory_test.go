@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -270,7 +274,7 @@ func TestMemoryStorage_GetPattern(t *testing.T) {
 		t.Fatalf("err not expected: %s", err)
 	}
 
-	r, err := storage.GetPattern("another*")
+	r, err := storage.GetPattern("another*", 0, 0)
 	if err != nil {
 		t.Fatalf("err not expected: %s", err)
 	}
@@ -318,7 +322,7 @@ func TestMemoryStorage_GetPatternEmpty(t *testing.T) {
 		t.Fatalf("err not expected: %s", err)
 	}
 
-	r, err := storage.GetPattern("a*glob?")
+	r, err := storage.GetPattern("a*glob?", 0, 0)
 	if err != nil {
 		t.Fatalf("err not expected: %s", err)
 	}
@@ -332,3 +336,1112 @@ func TestMemoryStorage_GetPatternEmpty(t *testing.T) {
 		t.Fatalf("expected: %s, found : %s", "[]", chk)
 	}
 }
+
+func TestMemoryStorage_ExpirePattern(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	updated, err := storage.ExpirePattern("another*", time.Duration(time.Hour))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if updated != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, updated)
+	}
+
+	if chk := storage.data["another key"].Expiration; chk == 0 {
+		t.Fatalf("expected expiration set, found : %d", chk)
+	}
+
+	if chk := storage.data["a key"].Expiration; chk != 0 {
+		t.Fatalf("expected: %d, found : %d", 0, chk)
+	}
+}
+
+func TestMemoryStorage_Keys(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	keys, err := storage.Keys("*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, found : %v", keys)
+	}
+
+	for _, key := range []string{"b key", "a key", "another key"} {
+		err = storage.Put(key, "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	err = storage.Put("an expired key", "a value", time.Nanosecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	keys, err = storage.Keys("a*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := []string{"a key", "another key"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, keys)
+	}
+}
+
+func TestMemoryStorage_CountPattern(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for pattern, expected := range map[string]int{"*": 2, "a*": 2, "another*": 1, "an expired*": 0, "none*": 0} {
+		chk, err := storage.CountPattern(pattern)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("pattern %s expected: %d, found : %d", pattern, expected, chk)
+		}
+	}
+}
+
+func TestMemoryStorage_Exists(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expected := range map[string]bool{"a key": true, "an expired key": false, "a missing key": false} {
+		chk, err := storage.Exists(key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("expected: %v, found : %v", expected, chk)
+		}
+	}
+}
+
+func TestMemoryStorage_TTL(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expiration := range map[string]time.Duration{"a key": time.Duration(-1), "an expiring key": time.Minute, "an expired key": 0} {
+		err = storage.Put(key, "a value", expiration)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	ttl, err := storage.TTL("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl != time.Duration(-1) {
+		t.Fatalf("expected: %s, found : %s", time.Duration(-1), ttl)
+	}
+
+	ttl, err = storage.TTL("an expiring key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("expected: about %s, found : %s", time.Minute, ttl)
+	}
+
+	for _, key := range []string{"an expired key", "a missing key"} {
+		if _, err := storage.TTL(key); !storage.IsNotExist(err) {
+			t.Fatalf("expected not exists, found : %v", err)
+		}
+	}
+}
+
+func TestMemoryStorage_GetPatternRoundTrip(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	values := map[string]string{
+		`a "key"`:      "he said \"hi\"\n",
+		"a\\key\nwith": `{"injected":"value"}","other":"`,
+	}
+
+	for key, value := range values {
+		err = storage.Put(key, value, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	var listing []map[string]string
+	if err := json.NewDecoder(r).Decode(&listing); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk := map[string]string{}
+	for _, entry := range listing {
+		for key, value := range entry {
+			chk[key] = value
+		}
+	}
+
+	if !reflect.DeepEqual(chk, values) {
+		t.Fatalf("expected: %q, found : %q", values, chk)
+	}
+}
+
+func TestMemoryStorage_ExistsMany(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := storage.ExistsMany([]string{"a key", "an expired key", "a missing key"})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := map[string]bool{"a key": true, "an expired key": false, "a missing key": false}
+	if !reflect.DeepEqual(chk, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, chk)
+	}
+}
+
+func TestMemoryStorage_GetMany(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an expired key", "an expired value", time.Duration(0))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	values, err := storage.GetMany([]string{"a key", "an expired key", "a missing key"})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := map[string][]byte{"a key": []byte("a value")}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, values)
+	}
+}
+
+func TestMemoryStorage_PushPop(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, element := range []string{"1", `"two"`, `{"three":3}`} {
+		err = storage.Push("a list", element)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for _, expected := range []string{`{"three":3}`, `"two"`, "1"} {
+		chk, err := storage.Pop("a list")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk != expected {
+			t.Fatalf("expected: %s, found : %s", expected, chk)
+		}
+	}
+
+	_, err = storage.Pop("a list")
+	if err != errNotExists {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a key", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
+
+func TestMemoryStorage_GetOrCreate(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, created, err := storage.GetOrCreate("a key", "{}", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !created || string(chk) != "{}" {
+		t.Fatalf("expected: %s created, found : %s (%t)", "{}", chk, created)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, created, err = storage.GetOrCreate("a key", "{}", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if created || string(chk) != "a value" {
+		t.Fatalf("expected: %s existing, found : %s (%t)", "a value", chk, created)
+	}
+}
+
+func TestMemoryStorage_GetPatternSorted(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "d", "b"} {
+		err = storage.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"a":"a"},{"b":"b"},{"c":"c"},{"d":"d"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestMemoryStorage_GetPatternPaged(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"c", "a", "e", "d", "b"} {
+		err = storage.Put(key, key, time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit    int
+		offset   int
+		expected string
+	}{
+		{2, 0, `[{"a":"a"},{"b":"b"}]`},
+		{2, 2, `[{"c":"c"},{"d":"d"}]`},
+		{2, 4, `[{"e":"e"}]`},
+		{2, 5, `[]`},
+		{0, 3, `[{"d":"d"},{"e":"e"}]`},
+	} {
+		r, err := storage.GetPattern("*", tc.limit, tc.offset)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != tc.expected {
+			t.Fatalf("expected: %s, found : %s", tc.expected, chk)
+		}
+	}
+}
+
+func TestMemoryStorage_GetPatternEncoding(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put(`a "key"`, `<b>a "value"</b>`, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"a \"key\"":"\u003cb\u003ea \"value\"\u003c/b\u003e"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+}
+
+func TestMemoryStorage_PutIfTTLBelow(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, tc := range []struct {
+		threshold time.Duration
+		expected  string
+		saved     bool
+	}{
+		{time.Minute, "first", true},
+		{30 * time.Second, "first", false},
+		{10 * time.Minute, "refreshed", true},
+	} {
+		saved, err := storage.PutIfTTLBelow("a key", tc.expected, tc.threshold, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if saved != tc.saved {
+			t.Fatalf("expected: %t, found : %t", tc.saved, saved)
+		}
+
+		r, err := storage.Get("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		chk, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) != tc.expected {
+			t.Fatalf("expected: %s, found : %s", tc.expected, chk)
+		}
+	}
+
+	err = storage.Put("a key", "permanent", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	saved, err := storage.PutIfTTLBelow("a key", "refreshed", time.Hour, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if saved {
+		t.Fatalf("expected a key without expiration not to be refreshed")
+	}
+}
+
+func TestMemoryStorage_CreationTimes(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, CreationTimes())
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	createdAt := func() string {
+		metadata, err := storage.GetMetadata("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		return metadata[CreatedAtHeader]
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	created := createdAt()
+	if _, err := time.Parse(time.RFC3339Nano, created); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err = storage.CompareAndSwap("a key", "another value", "1", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err = storage.Increment("a key", 1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if updated := createdAt(); updated != created {
+		t.Fatalf("expected: %s, found : %s", created, updated)
+	}
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if recreated := createdAt(); recreated == created {
+		t.Fatalf("expected a new creation time, found : %s", recreated)
+	}
+}
+
+func TestMemoryStorage_TypedKeys(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, TypedKeys())
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a string", "[]", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a list", "1")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a string", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	_, err = storage.Pop("a string")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a list", "a value", time.Duration(-1))
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a string", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Push("a list", "2")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Delete("a list")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a list", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// counters are tagged at their first increment, strings only hold what was put
+	_, err = storage.Increment("a counter", 1)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a counter", "1", time.Duration(-1))
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Push("a counter", "1")
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	err = storage.Put("a number", "1", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Increment("a number", 1)
+	if !storage.IsConflict(err) {
+		t.Fatalf("expected: %s, found : %v", errWrongType, err)
+	}
+
+	counter, err := storage.Decrement("a counter", 3)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if counter != -2 {
+		t.Fatalf("expected: %d, found : %d", -2, counter)
+	}
+}
+
+func TestMemoryStorage_DeleteAllSnapshot(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = storage.Put(fmt.Sprintf("key %d", i), "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if err := storage.dumpToFilesystem(); err != nil {
+					t.Errorf("err not expected: %s", err)
+				}
+			}
+		}
+	}()
+
+	err = storage.DeleteAll()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	done <- true
+
+	chk, err := ioutil.ReadFile(filepath.Join(tmpDir, memoryCacheFile))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "{}" {
+		t.Fatalf("expected: %s, found : %s", "{}", chk)
+	}
+}
+
+func TestMemoryStorage_MaxAge(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, MaxAge(50*time.Millisecond))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("an old key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = storage.Put("a fresh key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("an old key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+
+	_, err = storage.Get("a fresh key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	count, err := storage.CountPattern("*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, count)
+	}
+}
+
+func TestMemoryStorage_MaxAgeOverwritten(t *testing.T) {
+	defer func() {
+		storageClock = newClock()
+	}()
+
+	elapsed := time.Duration(0)
+	storageClock = clock{
+		start:   time.Now().Round(0),
+		elapsed: func() time.Duration { return elapsed },
+	}
+
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, MaxAge(time.Minute))
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	// overwriting a key keeps the time it was created at
+	for _, step := range []time.Duration{0, 40 * time.Second} {
+		elapsed = step
+
+		err = storage.Put("a key", "a value", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	elapsed = 61 * time.Second
+
+	_, err = storage.Get("a key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+}
+
+func TestMemoryStorage_GetAndTouch(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("a key", "a value", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.Put("another key", "another value", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := storage.GetAndTouch("a key", time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", chk)
+	}
+
+	// under threshold nothing is slid
+	_, err = storage.GetAndTouch("another key", time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, err = storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.Get("another key")
+	if !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+}
+
+func TestMemoryStorage_PutIfVersion(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	version, err := storage.PutIfVersion("a key", "a value", 0, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if version != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, version)
+	}
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	_, err = storage.PutIfVersion("a key", "a stale value", 1, time.Duration(-1))
+	if !storage.IsVersionMismatch(err) {
+		t.Fatalf("expected version mismatch, found : %v", err)
+	}
+
+	r, version, err := storage.GetVersioned("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(chk) != "another value" || version != 2 {
+		t.Fatalf("expected: %s at %d, found : %s at %d", "another value", 2, chk, version)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 1); !storage.IsVersionMismatch(err) {
+		t.Fatalf("expected version mismatch, found : %v", err)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 2); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.DeleteIfVersion("a key", 2); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
+
+func TestMemoryStorage_ConditionalPutsKeepMetadata(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	metadata := Metadata{"Content-Type": "text/plain", LastWriterHeader: "anonymous"}
+	err = storage.PutWithMetadata("a key", "a value", metadata, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.PutIfVersion("a key", "another value", 1, time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if swapped, err := storage.CompareAndSwap("a key", "another value", "a swapped value", time.Hour); err != nil || !swapped {
+		t.Fatalf("expected swapped, found : %v, %v", swapped, err)
+	}
+
+	if refreshed, err := storage.PutIfTTLBelow("a key", "a refreshed value", 2*time.Hour, time.Hour); err != nil || !refreshed {
+		t.Fatalf("expected refreshed, found : %v, %v", refreshed, err)
+	}
+
+	chk, err := storage.GetMetadata("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if !reflect.DeepEqual(chk, metadata) {
+		t.Fatalf("expected: %v, found : %v", metadata, chk)
+	}
+}
+
+func TestMemoryStorage_StorageDirIsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "keyvaluestorage")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err = NewMemoryStorage(f.Name())
+	if err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Fatalf("expected a not a directory error, found : %v", err)
+	}
+}
+
+func TestMemoryStorage_EvictionInterval(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir, EvictionInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	for key, expiration := range map[string]time.Duration{"an expired key": 0, "a key": time.Duration(-1)} {
+		if err := storage.Put(key, "a value", expiration); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	evicted := func() bool {
+		storage.locks.LockAll()
+		defer storage.locks.UnlockAll()
+
+		_, ok := storage.data["an expired key"]
+
+		return !ok && len(storage.data) == 1
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && !evicted(); time.Sleep(10 * time.Millisecond) {
+	}
+
+	if !evicted() {
+		t.Fatalf("expected an expired key evicted")
+	}
+}
+
+func TestMemoryStorage_CompareAndSwap(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if swapped, err := storage.CompareAndSwap("a key", "", "a value", time.Duration(-1)); err != nil || swapped {
+		t.Fatalf("expected: %v, found : %v (%v)", false, swapped, err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// writers racing from the same value, only one swaps it
+	var mu sync.Mutex
+	var winners []string
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+
+			swapped, err := storage.CompareAndSwap("a key", "a value", value, time.Duration(-1))
+			if err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+
+			if swapped {
+				mu.Lock()
+				winners = append(winners, value)
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("value %d", i))
+	}
+
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(winners))
+	}
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(value) != winners[0] {
+		t.Fatalf("expected: %s, found : %s", winners[0], value)
+	}
+}
+
+func TestMemoryStorage_Increment(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	storage, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// a missing key counts as 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := storage.Increment("a counter", 2); err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	counter, err := storage.Decrement("a counter", 1)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if counter != 99 {
+		t.Fatalf("expected: %d, found : %d", 99, counter)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Increment("a key", 1); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+
+	if err := storage.Put("a key", "9223372036854775807", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Increment("a key", 1); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// operations recorded in the operation log
+const (
+	opPut       = "put"
+	opDelete    = "delete"
+	opDeleteAll = "delete_all"
+)
+
+// opRecord is a line of the operation log, Entry being the whole entry saved by a put
+type opRecord struct {
+	Time  time.Time       `json:"time"`
+	Op    string          `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Entry json.RawMessage `json:"entry,omitempty"`
+}
+
+// opLog is an append-only log of the mutations of a storage
+type opLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openOpLog(path string) (*opLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &opLog{f: f}, nil
+}
+
+// opLog.record Appends an operation on key, with the entry saved for a put
+func (l *opLog) record(op string, key string, entry []byte) error {
+	if l == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(opRecord{Time: time.Now().UTC(), Op: op, Key: key, Entry: entry})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot record %s (%s) in operation log: %s", op, key, err)
+	}
+
+	return l.f.Sync()
+}
+
+func (l *opLog) close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.f.Close()
+}
+
+// checkOpLogPath Returns error if the operation log would be scanned as an entry of storageDir
+func checkOpLogPath(path string, storageDir string) error {
+	logDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(storageDir)
+	if err != nil {
+		return err
+	}
+
+	if logDir == dir {
+		return fmt.Errorf("operation log (%s) must be outside the storage dir", path)
+	}
+
+	return nil
+}
+
+// Replay Rebuilds in the empty storageDir the fs storage state recorded in the operation log at logPath
+// as of to, returns the number of operations applied
+func Replay(logPath string, to time.Time, storageDir string) (int, error) {
+	if err := checkOpLogPath(logPath, storageDir); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	storage, err := NewFileSystemStorage(storageDir)
+	if err != nil {
+		return 0, err
+	}
+
+	if keys, err := storage.getAllStorageKeys(); err != nil {
+		return 0, err
+	} else if len(keys) > 0 {
+		return 0, fmt.Errorf("replay target %s is not empty", storageDir)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+
+	applied := 0
+	for line := 1; scanner.Scan(); line++ {
+		var record opRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return applied, fmt.Errorf("invalid operation log line %d: %s", line, err)
+		}
+
+		if record.Time.After(to) {
+			break
+		}
+
+		switch record.Op {
+		case opPut:
+			var e entry
+			if err := json.Unmarshal(record.Entry, &e); err != nil {
+				return applied, fmt.Errorf("invalid operation log line %d: %s", line, err)
+			}
+
+			err = storage.putEntry(e)
+		case opDelete:
+			if err = storage.Delete(record.Key); err == errNotExists {
+				err = nil
+			}
+		case opDeleteAll:
+			err = storage.DeleteAll()
+		default:
+			err = fmt.Errorf("unknown operation %q", record.Op)
+		}
+
+		if err != nil {
+			return applied, fmt.Errorf("cannot replay operation log line %d: %s", line, err)
+		}
+
+		applied++
+	}
+
+	return applied, scanner.Err()
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSystemStorage_Replay(t *testing.T) {
+	tmpDir := boostrapFilesystem(t)
+
+	logDir, err := ioutil.TempDir("", "keyvaluestorage-oplog")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(logDir)
+
+	logPath := filepath.Join(logDir, "oplog")
+
+	_, err = NewFileSystemStorage(tmpDir, OperationLog(filepath.Join(tmpDir, "oplog")))
+	if err == nil {
+		t.Fatalf("err expected with an operation log in the storage dir")
+	}
+
+	storage, err := NewFileSystemStorage(tmpDir, OperationLog(logPath))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, key := range []string{"a key", "another key", "a third key"} {
+		if err := storage.Put(key, "a value", time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	if err := storage.Delete("another key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Push("a list", `"an element"`); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	to := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := storage.Put("a key", "another value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.DeleteAll(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage.Flush()
+
+	replayDir, err := ioutil.TempDir("", "keyvaluestorage-replay")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(replayDir)
+
+	applied, err := Replay(logPath, to, replayDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if applied != 5 {
+		t.Fatalf("expected: %d, found : %d", 5, applied)
+	}
+
+	replayed, err := NewFileSystemStorage(replayDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	r, err := replayed.GetPattern("*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	chk, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := `[{"a key":"a value"},{"a list":"[\"an element\"]"},{"a third key":"a value"}]`
+	if string(chk) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, chk)
+	}
+
+	_, err = Replay(logPath, time.Now(), replayDir)
+	if err == nil {
+		t.Fatalf("err expected replaying in a not empty dir")
+	}
+}
@@ -0,0 +1,540 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeout of every request made to the proxied instance
+const proxyTimeout = 30 * time.Second
+
+// proxyConflictError is a 409 answered by the proxied instance
+type proxyConflictError string
+
+func (e proxyConflictError) Error() string {
+	return string(e)
+}
+
+var errProxyUnavailable = errors.New("proxied instance unavailable")
+
+var errProxyPurge = errors.New("expired keys must be purged on the proxied instance")
+
+var errProxyInit = errors.New("keys must be initialized on the proxied instance")
+
+// proxyForbiddenError is a 403 answered by the proxied instance
+type proxyForbiddenError string
+
+func (e proxyForbiddenError) Error() string {
+	return string(e)
+}
+
+type httpProxyStorage struct {
+	baseURL string
+	client  *http.Client
+
+	// requests to the proxied instance are aborted once ctx is done
+	ctx context.Context
+}
+
+// NewHTTPProxyStorage Factory for proxy storage
+// forwards every operation to the keyvaluestorage instance listening at baseURL
+func NewHTTPProxyStorage(baseURL string) (*httpProxyStorage, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url (%s): %s", baseURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy url (%s): scheme must be http or https", baseURL)
+	}
+
+	return &httpProxyStorage{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: proxyTimeout},
+		ctx:     context.Background(),
+	}, nil
+}
+
+// httpProxyStorage.WithContext Returns the storage sending its requests to the proxied instance bound to ctx
+func (s *httpProxyStorage) WithContext(ctx context.Context) Storage {
+	return &httpProxyStorage{
+		baseURL: s.baseURL,
+		client:  s.client,
+		ctx:     ctx,
+	}
+}
+
+// do Sends a request to the proxied instance, returning the body of 2xx responses
+// and errNotExists, proxyForbiddenError, proxyConflictError, errVersionMismatch or errProxyUnavailable for 404, 403, 409, 412 and 503,
+// the error of its context once it is done
+func (s *httpProxyStorage) do(method string, path string, query url.Values, body io.Reader) (*http.Response, []byte, error) {
+	return s.doWithHeader(method, path, query, http.Header{}, body)
+}
+
+// doWithHeader Sends a request with header to the proxied instance, as do
+func (s *httpProxyStorage) doWithHeader(method string, path string, query url.Values, header http.Header, body io.Reader) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(s.ctx, method, s.baseURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// the trace of the request, if any, goes on in the proxied instance
+	otel.GetTextMapPropagator().Inject(s.ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+
+		return nil, nil, err
+	}
+
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+
+		return nil, nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return res, nil, errNotExists
+	case res.StatusCode == http.StatusServiceUnavailable:
+		return res, nil, errProxyUnavailable
+	case res.StatusCode == http.StatusForbidden:
+		return res, nil, proxyForbiddenError(strings.TrimSpace(string(b)))
+	case res.StatusCode == http.StatusConflict:
+		return res, nil, proxyConflictError(strings.TrimSpace(string(b)))
+	case res.StatusCode == http.StatusPreconditionFailed:
+		return res, nil, errVersionMismatch
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return res, nil, fmt.Errorf("proxied %s %s failed: %s", method, path, res.Status)
+	}
+
+	return res, b, nil
+}
+
+// keyPath Returns the path of key on the proxied instance
+func keyPath(key string) string {
+	return "/keys/" + url.PathEscape(key)
+}
+
+// expirationQuery Returns `expire_in` in whole seconds for expiration, rounded up, none for no expiration
+func expirationQuery(query url.Values, expiration time.Duration) url.Values {
+	if expiration == noExpiration {
+		return query
+	}
+
+	seconds := int64(expiration / time.Second)
+	if expiration%time.Second > 0 {
+		seconds++
+	}
+
+	query.Set("expire_in", strconv.FormatInt(seconds, 10))
+
+	return query
+}
+
+// httpProxyStorage.Type Returns type of the storage
+func (s *httpProxyStorage) Type() string {
+	return "proxy"
+}
+
+// httpProxyStorage.IsNotExist Returns if err is for a key missing on the proxied instance
+func (s *httpProxyStorage) IsNotExist(err error) bool {
+	return err == errNotExists
+}
+
+// httpProxyStorage.IsConflict Returns if err is for an operation incompatible with the value stored on the proxied instance
+func (s *httpProxyStorage) IsConflict(err error) bool {
+	_, ok := err.(proxyConflictError)
+
+	return ok
+}
+
+// httpProxyStorage.IsVersionMismatch Returns if err is for a conditional write against another version of the entry on the proxied instance
+func (s *httpProxyStorage) IsVersionMismatch(err error) bool {
+	return err == errVersionMismatch
+}
+
+// httpProxyStorage.IsForbidden Returns if err is for an operation not allowed by the proxied instance
+func (s *httpProxyStorage) IsForbidden(err error) bool {
+	_, ok := err.(proxyForbiddenError)
+
+	return ok || err == errProxyPurge || err == errProxyInit
+}
+
+// httpProxyStorage.IsUnavailable Returns if err is for the proxied instance answering 503 or not answering before the context is done
+func (s *httpProxyStorage) IsUnavailable(err error) bool {
+	return err == errProxyUnavailable || err == context.DeadlineExceeded || err == context.Canceled
+}
+
+// httpProxyStorage.HeldLocks Returns no locks, they are held by the proxied instance
+func (s *httpProxyStorage) HeldLocks() map[string]time.Duration {
+	return map[string]time.Duration{}
+}
+
+// httpProxyStorage.Health Reports healthy, the health of the proxied instance is its own
+func (s *httpProxyStorage) Health() Health {
+	return Health{}
+}
+
+// httpProxyStorage.Get Returns io.Reader for a key or error if it fails
+func (s *httpProxyStorage) Get(key string) (io.Reader, error) {
+	_, b, err := s.do("GET", keyPath(key), url.Values{}, nil)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// httpProxyStorage.GetMany Returns the values of the existing keys, one request after the other to keep them byte for byte, or error if it fails
+func (s *httpProxyStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// httpProxyStorage.GetStale Returns io.Reader for a key on the proxied instance, whether it was answered with a Warning as stale
+// or error if it fails
+func (s *httpProxyStorage) GetStale(key string) (io.Reader, bool, error) {
+	res, b, err := s.do("GET", keyPath(key), url.Values{}, nil)
+	if err != nil {
+		return bytes.NewReader(nil), false, err
+	}
+
+	return bytes.NewReader(b), len(res.Header.Get("Warning")) > 0, nil
+}
+
+// httpProxyStorage.GetVersioned Returns io.Reader for a key with its version on the proxied instance or error if it fails
+func (s *httpProxyStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	res, b, err := s.do("GET", keyPath(key), url.Values{}, nil)
+	if err != nil {
+		return bytes.NewReader(nil), 0, err
+	}
+
+	version, err := strconv.ParseInt(res.Header.Get("X-Version"), 10, 64)
+	if err != nil {
+		return bytes.NewReader(nil), 0, fmt.Errorf("proxied GET %s returned an invalid version: %s", keyPath(key), err)
+	}
+
+	return bytes.NewReader(b), version, nil
+}
+
+// httpProxyStorage.GetMetadata Returns the metadata headers the proxied instance answers a HEAD of a key with, or error if it fails
+func (s *httpProxyStorage) GetMetadata(key string) (Metadata, error) {
+	res, _, err := s.do("HEAD", keyPath(key), url.Values{}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata Metadata
+	for _, name := range append([]string{CreatedAtHeader}, MetadataHeaders...) {
+		if value := res.Header.Get(name); value != "" {
+			if metadata == nil {
+				metadata = Metadata{}
+			}
+
+			metadata[name] = value
+		}
+	}
+
+	return metadata, nil
+}
+
+// httpProxyStorage.GetAndTouch Returns io.Reader for a key touched with the sliding window configured on the proxied instance, or error if it fails
+func (s *httpProxyStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	_, b, err := s.do("GET", keyPath(key), url.Values{"touch_on_get": {"true"}}, nil)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// httpProxyStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, and whether it was created or error if it fails
+func (s *httpProxyStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	query := expirationQuery(url.Values{"default_on_miss": {"true"}, "default": {defaultValue}}, expiration)
+
+	res, b, err := s.do("GET", keyPath(key), query, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, res.Header.Get("X-Created") == "true", nil
+}
+
+// httpProxyStorage.PutIfTTLBelow Saves an entry by key with timeout only if missing or its remaining TTL is under threshold, returns whether it was saved or error if it fails
+func (s *httpProxyStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	query := expirationQuery(url.Values{"refresh_if_ttl_below": {strconv.FormatInt(int64(threshold/time.Second), 10)}}, expiration)
+
+	res, _, err := s.do("PUT", keyPath(key), query, strings.NewReader(value))
+	if err != nil {
+		return false, err
+	}
+
+	return res.Header.Get("X-Refreshed") == "true", nil
+}
+
+// httpProxyStorage.CompareAndSwap Saves an entry by key with timeout only if its value is oldValue, matched by the proxied instance
+// against its ETag, returns whether it was saved or error if it fails
+func (s *httpProxyStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	header := http.Header{"If-Match": {fmt.Sprintf(`"%x"`, md5.Sum([]byte(oldValue)))}}
+
+	_, _, err := s.doWithHeader("PUT", keyPath(key), expirationQuery(url.Values{}, expiration), header, strings.NewReader(newValue))
+	if s.IsVersionMismatch(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// httpProxyStorage.PutIfEmpty Fails, checking emptiness is an admin operation of the proxied instance
+func (s *httpProxyStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	return false, errProxyInit
+}
+
+// httpProxyStorage.PutIfVersion Saves an entry by key with timeout only if its version is version, returns the new version or error if it fails
+func (s *httpProxyStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	header := http.Header{"If-Version-Match": {strconv.FormatInt(version, 10)}}
+
+	res, _, err := s.doWithHeader("PUT", keyPath(key), expirationQuery(url.Values{}, expiration), header, strings.NewReader(value))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(res.Header.Get("X-Version"), 10, 64)
+}
+
+// httpProxyStorage.Exists Returns whether a key exists answering a HEAD on the proxied instance or error if it fails
+func (s *httpProxyStorage) Exists(key string) (bool, error) {
+	_, _, err := s.do("HEAD", keyPath(key), url.Values{}, nil)
+	if s.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// httpProxyStorage.TTL Returns the time left before a key expires as answered in X-Expire-In by the proxied instance
+// to a HEAD, -1 if it never does, or error if it fails
+func (s *httpProxyStorage) TTL(key string) (time.Duration, error) {
+	res, _, err := s.do("HEAD", keyPath(key), url.Values{}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseInt(res.Header.Get("X-Expire-In"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if seconds < 0 {
+		return noExpiration, nil
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// httpProxyStorage.ExistsMany Returns whether each key exists or error if it fails
+func (s *httpProxyStorage) ExistsMany(keys []string) (map[string]bool, error) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	_, b, err := s.do("POST", "/keys/mexists", url.Values{}, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var exists map[string]bool
+	if err := json.Unmarshal(b, &exists); err != nil {
+		return nil, err
+	}
+
+	return exists, nil
+}
+
+// httpProxyStorage.GetPattern Returns io.Reader for a page of a pattern or error if it fails
+func (s *httpProxyStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	query := url.Values{"filter": {pattern}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+
+	resp, b, err := s.do("GET", "/keys", query, nil)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	if resp.Header.Get("X-Consistent") == "false" {
+		return inconsistentReader{bytes.NewReader(b)}, nil
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// httpProxyStorage.Keys Returns the keys matching a pattern or error if it fails
+func (s *httpProxyStorage) Keys(pattern string) ([]string, error) {
+	_, b, err := s.do("GET", "/keys", url.Values{"filter": {pattern}, "keys_only": {"true"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// httpProxyStorage.CountPattern Returns count of entries matching a pattern or error if it fails
+func (s *httpProxyStorage) CountPattern(pattern string) (int, error) {
+	_, b, err := s.do("GET", "/keys/count", url.Values{"filter": {pattern}}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(b))
+}
+
+// httpProxyStorage.ExpirePattern Updates expiration of entries matching a pattern, returns count updated or error if it fails
+func (s *httpProxyStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	if expiration == noExpiration {
+		return 0, fmt.Errorf("proxied expire requires an expiration")
+	}
+
+	_, b, err := s.do("PUT", "/keys/expire", expirationQuery(url.Values{"filter": {pattern}}, expiration), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(b))
+}
+
+// httpProxyStorage.Delete Deletes an entry by key, returns error if it fails
+func (s *httpProxyStorage) Delete(key string) error {
+	_, _, err := s.do("DELETE", keyPath(key), url.Values{}, nil)
+
+	return err
+}
+
+// httpProxyStorage.DeleteIfVersion Deletes an entry by key only if its version is version, returns error if it fails
+func (s *httpProxyStorage) DeleteIfVersion(key string, version int64) error {
+	header := http.Header{"If-Version-Match": {strconv.FormatInt(version, 10)}}
+
+	_, _, err := s.doWithHeader("DELETE", keyPath(key), url.Values{}, header, nil)
+
+	return err
+}
+
+// httpProxyStorage.DeleteAll Deletes all entries, returns error if it fails
+func (s *httpProxyStorage) DeleteAll() error {
+	_, _, err := s.do("DELETE", "/keys", url.Values{}, nil)
+
+	return err
+}
+
+// httpProxyStorage.PurgeExpired Fails, purging is an admin operation of the proxied instance
+func (s *httpProxyStorage) PurgeExpired() ([]string, error) {
+	return nil, errProxyPurge
+}
+
+// httpProxyStorage.Put Saves an entry by key with timeout, returns error if it fails
+func (s *httpProxyStorage) Put(key string, value string, expiration time.Duration) error {
+	_, _, err := s.do("PUT", keyPath(key), expirationQuery(url.Values{}, expiration), strings.NewReader(value))
+
+	return err
+}
+
+// httpProxyStorage.PutMany Saves every entry by key with timeout, returns a PutManyError of the keys that failed
+func (s *httpProxyStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// httpProxyStorage.PutWithMetadata Saves an entry by key with timeout along metadata sent as headers, returns error if it fails
+func (s *httpProxyStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	header := http.Header{}
+	for name, v := range metadata {
+		header.Set(name, v)
+	}
+
+	_, _, err := s.doWithHeader("PUT", keyPath(key), expirationQuery(url.Values{}, expiration), header, strings.NewReader(value))
+
+	return err
+}
+
+// httpProxyStorage.Push Appends an element to the JSON array stored by key, returns error if it fails
+func (s *httpProxyStorage) Push(key string, element string) error {
+	_, _, err := s.do("POST", keyPath(key)+"/push", url.Values{}, strings.NewReader(element))
+
+	return err
+}
+
+// httpProxyStorage.Pop Removes and returns the last element of the JSON array stored by key or error if it fails
+func (s *httpProxyStorage) Pop(key string) (string, error) {
+	_, b, err := s.do("POST", keyPath(key)+"/pop", url.Values{}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// httpProxyStorage.Increment Adds delta to the integer stored by key, a missing key counting as 0, returns the new value or error if it fails
+func (s *httpProxyStorage) Increment(key string, delta int64) (int64, error) {
+	_, b, err := s.do("POST", keyPath(key)+"/increment", url.Values{"by": {strconv.FormatInt(delta, 10)}}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// httpProxyStorage.Decrement Subtracts delta from the integer stored by key, returns the new value or error if it fails
+func (s *httpProxyStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// httpProxyStorage.Flush Releases idle connections to the proxied instance
+func (s *httpProxyStorage) Flush() {
+	s.client.CloseIdleConnections()
+}
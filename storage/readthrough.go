@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+type readThroughStorage struct {
+	Storage
+
+	cache Storage
+	ttl   time.Duration
+}
+
+// NewReadThroughStorage Decorator for storage
+// serves reads from cache, filling it on a miss with the value read from the authoritative storage for at most ttl,
+// writes go to storage then to cache, failures of cache only being logged
+func NewReadThroughStorage(storage Storage, cache Storage, ttl time.Duration) *readThroughStorage {
+	return &readThroughStorage{
+		Storage: storage,
+		cache:   cache,
+		ttl:     ttl,
+	}
+}
+
+// readThroughStorage.WithContext Returns the storage and its cache bound to ctx
+func (s *readThroughStorage) WithContext(ctx context.Context) Storage {
+	return &readThroughStorage{
+		Storage: WithContext(ctx, s.Storage),
+		cache:   WithContext(ctx, s.cache),
+		ttl:     s.ttl,
+	}
+}
+
+// readThroughStorage.Rejected Returns the operations the decorated storage rejects
+func (s *readThroughStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+// cacheExpiration Returns the timeout of a value cached for an entry saved with expiration, never over ttl
+func (s *readThroughStorage) cacheExpiration(expiration time.Duration) time.Duration {
+	if expiration == noExpiration || expiration > s.ttl {
+		return s.ttl
+	}
+
+	return expiration
+}
+
+// invalidate Deletes the cached value of key, to be read again from the authoritative storage
+func (s *readThroughStorage) invalidate(key string) {
+	if err := s.cache.Delete(key); err != nil && !s.cache.IsNotExist(err) {
+		logger.Warnf("read-through cache invalidation of %s failed: %s", key, err)
+	}
+}
+
+// readThroughStorage.GetCached Returns io.Reader for a key, whether it was a cache hit, or error if it fails
+func (s *readThroughStorage) GetCached(key string) (io.Reader, bool, error) {
+	r, err := s.cache.Get(key)
+	if err == nil {
+		return r, true, nil
+	}
+
+	if !s.cache.IsNotExist(err) {
+		logger.Warnf("read-through cache read of %s failed: %s", key, err)
+	}
+
+	if r, err = s.Storage.Get(key); err != nil {
+		return r, false, err
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return bytes.NewReader(nil), false, err
+	}
+
+	// the cached value never outlives the entry
+	expiration := s.ttl
+	if ttl, err := s.Storage.TTL(key); err == nil {
+		expiration = s.cacheExpiration(ttl)
+	}
+
+	if err := s.cache.Put(key, string(value), expiration); err != nil {
+		logger.Warnf("read-through cache fill of %s failed: %s", key, err)
+	}
+
+	return bytes.NewReader(value), false, nil
+}
+
+// readThroughStorage.Get Returns io.Reader for a key or error if it fails
+func (s *readThroughStorage) Get(key string) (io.Reader, error) {
+	r, _, err := s.GetCached(key)
+
+	return r, err
+}
+
+// readThroughStorage.GetMany Returns the values of the existing keys, reading through the cache, or error if it fails
+func (s *readThroughStorage) GetMany(keys []string) (map[string][]byte, error) {
+	return getMany(s, keys)
+}
+
+// readThroughStorage.Put Saves an entry by key with timeout then caches it, returns error if it fails
+func (s *readThroughStorage) Put(key string, value string, expiration time.Duration) error {
+	if err := s.Storage.Put(key, value, expiration); err != nil {
+		s.invalidate(key)
+		return err
+	}
+
+	if err := s.cache.Put(key, value, s.cacheExpiration(expiration)); err != nil {
+		logger.Warnf("read-through cache write of %s failed: %s", key, err)
+		s.invalidate(key)
+	}
+
+	return nil
+}
+
+// readThroughStorage.PutMany Saves every entry by key with timeout then caches each, returns a PutManyError of the keys that failed
+func (s *readThroughStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// readThroughStorage.PutWithMetadata Saves an entry by key with timeout along metadata then caches its value, returns error if it fails
+func (s *readThroughStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	if err := s.Storage.PutWithMetadata(key, value, metadata, expiration); err != nil {
+		s.invalidate(key)
+		return err
+	}
+
+	if err := s.cache.Put(key, value, s.cacheExpiration(expiration)); err != nil {
+		logger.Warnf("read-through cache write of %s failed: %s", key, err)
+		s.invalidate(key)
+	}
+
+	return nil
+}
+
+// readThroughStorage.GetAndTouch Returns io.Reader for a key sliding its expiration invalidating its cached value, or error if it fails
+func (s *readThroughStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.GetAndTouch(key, window, threshold)
+}
+
+// readThroughStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold invalidating its cached value, returns whether it was saved or error if it fails
+func (s *readThroughStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+}
+
+// readThroughStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue invalidating its cached value, returns whether it was saved or error if it fails
+func (s *readThroughStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+}
+
+// readThroughStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists invalidating its cached value, returns whether it was saved or error if it fails
+func (s *readThroughStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfEmpty(prefix, key, value, expiration)
+}
+
+// readThroughStorage.PutIfVersion Saves an entry by key with timeout if its version is version invalidating its cached value, returns the new version or error if it fails
+func (s *readThroughStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.PutIfVersion(key, value, version, expiration)
+}
+
+// readThroughStorage.DeleteIfVersion Deletes an entry by key if its version is version invalidating its cached value, returns error if it fails
+func (s *readThroughStorage) DeleteIfVersion(key string, version int64) error {
+	defer s.invalidate(key)
+
+	return s.Storage.DeleteIfVersion(key, version)
+}
+
+// readThroughStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing invalidating its cached value, and whether it was created or error if it fails
+func (s *readThroughStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.GetOrCreate(key, defaultValue, expiration)
+}
+
+// readThroughStorage.ExpirePattern Updates expiration of entries matching a pattern expiring their cached values, returns count updated or error if it fails
+func (s *readThroughStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	defer func() {
+		if _, err := s.cache.ExpirePattern(pattern, 0); err != nil {
+			logger.Warnf("read-through cache invalidation of %s failed: %s", pattern, err)
+		}
+	}()
+
+	return s.Storage.ExpirePattern(pattern, expiration)
+}
+
+// readThroughStorage.Delete Deletes an entry by key and its cached value, returns error if it fails
+func (s *readThroughStorage) Delete(key string) error {
+	defer s.invalidate(key)
+
+	return s.Storage.Delete(key)
+}
+
+// readThroughStorage.Push Appends an element to the JSON array stored by key invalidating its cached value, returns error if it fails
+func (s *readThroughStorage) Push(key string, element string) error {
+	defer s.invalidate(key)
+
+	return s.Storage.Push(key, element)
+}
+
+// readThroughStorage.Pop Removes and returns the last element of the JSON array stored by key invalidating its cached value or error if it fails
+func (s *readThroughStorage) Pop(key string) (string, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.Pop(key)
+}
+
+// readThroughStorage.Increment Adds delta to the integer stored by key invalidating its cached value, returns the new value or error if it fails
+func (s *readThroughStorage) Increment(key string, delta int64) (int64, error) {
+	defer s.invalidate(key)
+
+	return s.Storage.Increment(key, delta)
+}
+
+// readThroughStorage.Decrement Subtracts delta from the integer stored by key invalidating its cached value, returns the new value or error if it fails
+func (s *readThroughStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// readThroughStorage.DeleteAll Deletes all entries and cached values, returns error if it fails
+func (s *readThroughStorage) DeleteAll() error {
+	defer func() {
+		if err := s.cache.DeleteAll(); err != nil {
+			logger.Warnf("read-through cache invalidation failed: %s", err)
+		}
+	}()
+
+	return s.Storage.DeleteAll()
+}
+
+// readThroughStorage.PurgeExpired Deletes all expired entries and cached values, returns the keys purged from storage or error if it fails
+func (s *readThroughStorage) PurgeExpired() ([]string, error) {
+	if _, err := s.cache.PurgeExpired(); err != nil {
+		logger.Warnf("read-through cache purge failed: %s", err)
+	}
+
+	return s.Storage.PurgeExpired()
+}
+
+// readThroughStorage.Flush Flushes storage then the cache
+func (s *readThroughStorage) Flush() {
+	s.Storage.Flush()
+	s.cache.Flush()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadThroughStorage(t *testing.T) {
+	var storages []Storage
+	for range []string{"authoritative", "cache"} {
+		tmpDir, err := ioutil.TempDir("", "keyvaluestorage-readthrough")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		defer os.RemoveAll(tmpDir)
+
+		memory, err := NewMemoryStorage(tmpDir)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		storages = append(storages, memory)
+	}
+
+	authoritative, cache := storages[0], storages[1]
+	storage := NewReadThroughStorage(authoritative, cache, time.Minute)
+
+	err := authoritative.Put("a key", "a value", 10*time.Second)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := cache.Get("a key"); !cache.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	for _, expected := range []bool{false, true} {
+		r, hit, err := storage.GetCached("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if hit != expected {
+			t.Fatalf("expected: %v, found : %v", expected, hit)
+		}
+
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(value) != "a value" {
+			t.Fatalf("expected: %s, found : %s", "a value", value)
+		}
+	}
+
+	// the cached value expires along the entry rather than after the cache ttl
+	ttl, err := cache.TTL("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl > 10*time.Second {
+		t.Fatalf("expected: at most %s, found : %s", 10*time.Second, ttl)
+	}
+
+	err = storage.Put("a key", "another value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for _, s := range []Storage{authoritative, cache} {
+		r, err := s.Get("a key")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(value) != "another value" {
+			t.Fatalf("expected: %s, found : %s", "another value", value)
+		}
+	}
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := cache.Get("a key"); !cache.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
@@ -0,0 +1,714 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keys asked to redis at every SCAN iteration
+const redisScanCount = 1000
+
+var errRedisMetadata = errors.New("metadata is not supported by the redis provider")
+
+var errRedisVersions = errors.New("conditional writes on versions are not supported by the redis provider")
+
+// redisPutIfEmpty sets KEYS[1] to ARGV[2] expiring in ARGV[3] milliseconds (none if negative)
+// unless a key matches ARGV[1], atomically as scripts run alone
+var redisPutIfEmpty = redis.NewScript(`
+if #redis.call('KEYS', ARGV[1]) > 0 then
+	return 0
+end
+
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+elseif ttl < 0 then
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+
+return 1
+`)
+
+// RedisOption Functional option type of the redis storage
+type RedisOption func(*redisStorage)
+
+// RedisKeyPrefix Set prefix of the keys the storage owns on the redis server, DeleteAll deleting only those
+func RedisKeyPrefix(prefix string) RedisOption {
+	return func(s *redisStorage) {
+		s.prefix = prefix
+	}
+
+}
+
+type redisStorage struct {
+	client *redis.Client
+	prefix string
+
+	// commands to redis are aborted once ctx is done
+	ctx context.Context
+}
+
+// NewRedisStorage Factory for redis storage
+// stores values as plain strings of the redis server listening at addr, which expires them itself
+func NewRedisStorage(addr string, options ...RedisOption) (*redisStorage, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("redis addr not set")
+	}
+
+	s := &redisStorage{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		ctx:    context.Background(),
+	}
+
+	for _, optionFn := range options {
+		optionFn(s)
+	}
+
+	return s, nil
+}
+
+// redisStorage.WithContext Returns the storage sending its commands to redis bound to ctx
+func (s *redisStorage) WithContext(ctx context.Context) Storage {
+	return &redisStorage{
+		client: s.client,
+		prefix: s.prefix,
+		ctx:    ctx,
+	}
+}
+
+// escapeGlob Returns s with the characters special to redis patterns escaped
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\^`, r) {
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// set Saves value by key with timeout through c, an expired timeout deleting key
+func (s *redisStorage) set(c redis.Cmdable, key string, value interface{}, expiration time.Duration) error {
+	if expiration == noExpiration {
+		return c.Set(s.ctx, s.prefix+key, value, 0).Err()
+	}
+
+	if expiration <= 0 {
+		return c.Del(s.ctx, s.prefix+key).Err()
+	}
+
+	// redis expires in whole milliseconds
+	if expiration < time.Millisecond {
+		expiration = time.Millisecond
+	}
+
+	return c.Set(s.ctx, s.prefix+key, value, expiration).Err()
+}
+
+// scan Returns the keys matching pattern as filepath.Match does, without prefix
+func (s *redisStorage) scan(pattern string) ([]string, error) {
+	seen := map[string]bool{}
+	keys := []string{}
+
+	iter := s.client.Scan(s.ctx, 0, escapeGlob(s.prefix)+pattern, redisScanCount).Iterator()
+	for iter.Next(s.ctx) {
+		key := strings.TrimPrefix(iter.Val(), s.prefix)
+
+		// SCAN may return a key more than once
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		if ok, err := filepath.Match(pattern, key); ok && err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, iter.Err()
+}
+
+// update Rewrites the value of key with fn keeping its TTL, in a transaction retried while key changes concurrently
+func (s *redisStorage) update(key string, fn func(value []byte, exists bool) ([]byte, error)) error {
+	for {
+		err := s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+			value, err := tx.Get(s.ctx, s.prefix+key).Bytes()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+
+			if value, err = fn(value, err == nil); err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+				return pipe.Set(s.ctx, s.prefix+key, value, redis.KeepTTL).Err()
+			})
+
+			return err
+		}, s.prefix+key)
+
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// redisStorage.Type Returns type of the storage
+func (s *redisStorage) Type() string {
+	return "redis"
+}
+
+// redisStorage.IsNotExist Returns if err is for a key missing on redis
+func (s *redisStorage) IsNotExist(err error) bool {
+	return err == redis.Nil || err == errNotExists
+}
+
+// redisStorage.IsConflict Returns if err is for an operation incompatible with the value stored
+func (s *redisStorage) IsConflict(err error) bool {
+	return isConflict(err)
+}
+
+// redisStorage.IsVersionMismatch Returns if err is for a conditional write against another version of the entry, never as redis has no versions
+func (s *redisStorage) IsVersionMismatch(err error) bool {
+	return err == errVersionMismatch
+}
+
+// redisStorage.IsForbidden Returns if err is for metadata or versions, which are not stored on redis
+func (s *redisStorage) IsForbidden(err error) bool {
+	return err == errRedisMetadata || err == errRedisVersions
+}
+
+// redisStorage.Rejected Returns the operations on versions, which are not stored on redis
+func (s *redisStorage) Rejected() []string {
+	return []string{"if_version_match"}
+}
+
+// redisStorage.IsUnavailable Returns if err is for redis not answering, or not before the context is done
+func (s *redisStorage) IsUnavailable(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) || err == context.DeadlineExceeded || err == context.Canceled
+}
+
+// redisStorage.HeldLocks Returns no locks, keys are never locked but watched
+func (s *redisStorage) HeldLocks() map[string]time.Duration {
+	return map[string]time.Duration{}
+}
+
+// redisStorage.Health Reports degraded while redis does not answer a PING
+func (s *redisStorage) Health() Health {
+	if err := s.client.Ping(s.ctx).Err(); err != nil {
+		return Health{Degraded: true, Reason: fmt.Sprintf("redis not answering: %s", err)}
+	}
+
+	return Health{}
+}
+
+// redisStorage.Get Returns io.Reader for a key or error if it fails
+func (s *redisStorage) Get(key string) (io.Reader, error) {
+	value, err := s.client.Get(s.ctx, s.prefix+key).Bytes()
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	return bytes.NewReader(value), nil
+}
+
+// redisStorage.GetMany Returns the values of the existing keys with a single MGET or error if it fails
+func (s *redisStorage) GetMany(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+
+	found, err := s.client.MGet(s.ctx, prefixed...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range found {
+		// missing keys are answered nil
+		if value, ok := value.(string); ok {
+			values[keys[i]] = []byte(value)
+		}
+	}
+
+	return values, nil
+}
+
+// redisStorage.GetStale Returns io.Reader for a key, never stale as redis deletes keys once expired, or error if it fails
+func (s *redisStorage) GetStale(key string) (io.Reader, bool, error) {
+	r, err := s.Get(key)
+
+	return r, false, err
+}
+
+// redisStorage.GetMetadata Returns no metadata for an existing key, it is not stored on redis, or error if it fails
+func (s *redisStorage) GetMetadata(key string) (Metadata, error) {
+	exists, err := s.Exists(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, errNotExists
+	}
+
+	return nil, nil
+}
+
+// redisStorage.GetAndTouch Returns io.Reader for a key sliding its expiration window from now if less than threshold is left, or error if it fails
+func (s *redisStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error) {
+	ttl, err := s.TTL(key)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	if ttl >= 0 {
+		if expiration, slid := slideExpiration(now().Add(ttl).UnixNano(), window, threshold); slid {
+			if err := s.client.PExpireAt(s.ctx, s.prefix+key, time.Unix(0, expiration)).Err(); err != nil {
+				return bytes.NewReader(nil), err
+			}
+		}
+	}
+
+	return s.Get(key)
+}
+
+// redisStorage.GetOrCreate Returns the value of a key, saving defaultValue with timeout if missing, whether it was created or error if it fails
+func (s *redisStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	// created already expired, there is nothing to save
+	if expiration != noExpiration && expiration <= 0 {
+		return []byte(defaultValue), true, nil
+	}
+
+	if expiration == noExpiration {
+		expiration = 0
+	} else if expiration < time.Millisecond {
+		expiration = time.Millisecond
+	}
+
+	for {
+		created, err := s.client.SetNX(s.ctx, s.prefix+key, defaultValue, expiration).Result()
+		if err != nil {
+			return nil, false, err
+		}
+
+		if created {
+			return []byte(defaultValue), true, nil
+		}
+
+		// the key expired between SETNX and GET, it is created again
+		value, err := s.client.Get(s.ctx, s.prefix+key).Bytes()
+		if err != redis.Nil {
+			return value, false, err
+		}
+	}
+}
+
+// redisStorage.PutIfTTLBelow Saves an entry by key with timeout only if missing or its remaining TTL is under threshold, returns whether it was saved or error if it fails
+func (s *redisStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	for {
+		saved := false
+		err := s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+			ttl, err := tx.PTTL(s.ctx, s.prefix+key).Result()
+			if err != nil {
+				return err
+			}
+
+			// PTTL answers -2 for missing keys and -1 for keys without expiration, which never go below
+			if ttl == -1 || (ttl >= 0 && !ttlBelow(now().Add(ttl).UnixNano(), threshold)) {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+				return s.set(pipe, key, value, expiration)
+			})
+
+			saved = err == nil
+
+			return err
+		}, s.prefix+key)
+
+		if err != redis.TxFailedErr {
+			return saved, err
+		}
+	}
+}
+
+// redisStorage.CompareAndSwap Saves an entry by key with timeout only if its value is oldValue, returns whether it was saved or error if it fails
+func (s *redisStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	for {
+		saved := false
+		err := s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+			current, err := tx.Get(s.ctx, s.prefix+key).Result()
+			if err == redis.Nil || (err == nil && current != oldValue) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+				return s.set(pipe, key, newValue, expiration)
+			})
+
+			saved = err == nil
+
+			return err
+		}, s.prefix+key)
+
+		if err != redis.TxFailedErr {
+			return saved, err
+		}
+	}
+}
+
+// redisStorage.PutIfEmpty Saves an entry by key with timeout only if no key starting with prefix exists, atomically,
+// returns whether it was saved or error if it fails
+func (s *redisStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	ttl := int64(-1)
+	if expiration != noExpiration {
+		ttl = int64(expiration / time.Millisecond)
+		if expiration > 0 && ttl == 0 {
+			ttl = 1
+		}
+	}
+
+	created, err := redisPutIfEmpty.Run(s.ctx, s.client, []string{s.prefix + key}, escapeGlob(s.prefix+prefix)+"*", value, ttl).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return created == 1, nil
+}
+
+// redisStorage.GetVersioned Returns io.Reader for a key with version 0, redis has no versions, or error if it fails
+func (s *redisStorage) GetVersioned(key string) (io.Reader, int64, error) {
+	r, err := s.Get(key)
+
+	return r, 0, err
+}
+
+// redisStorage.PutIfVersion Fails, redis has no versions
+func (s *redisStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	return 0, errRedisVersions
+}
+
+// redisStorage.DeleteIfVersion Fails, redis has no versions
+func (s *redisStorage) DeleteIfVersion(key string, version int64) error {
+	return errRedisVersions
+}
+
+// redisStorage.Exists Returns whether a key exists or error if it fails
+func (s *redisStorage) Exists(key string) (bool, error) {
+	n, err := s.client.Exists(s.ctx, s.prefix+key).Result()
+
+	return n == 1, err
+}
+
+// redisStorage.TTL Returns the time left before a key expires, -1 if it never does, or error if it fails
+func (s *redisStorage) TTL(key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(s.ctx, s.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL answers -2 for missing keys and -1 for keys without expiration
+	switch ttl {
+	case -2:
+		return 0, errNotExists
+	case -1:
+		return noExpiration, nil
+	}
+
+	return ttl, nil
+}
+
+// redisStorage.ExistsMany Returns whether each key exists or error if it fails
+func (s *redisStorage) ExistsMany(keys []string) (map[string]bool, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := s.client.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(s.ctx, s.prefix+key)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		exists[key] = cmds[i].Val() == 1
+	}
+
+	return exists, nil
+}
+
+// redisStorage.GetPattern Returns io.Reader for a page of a pattern by key or error if it fails
+func (s *redisStorage) GetPattern(pattern string, limit int, offset int) (io.Reader, error) {
+	keys, err := s.scan(pattern)
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	keys = pageKeys(keys, limit, offset)
+
+	ret := make([]entry, 0, len(keys))
+	if len(keys) == 0 {
+		return patternReader(ret)
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+
+	values, err := s.client.MGet(s.ctx, prefixed...).Result()
+	if err != nil {
+		return bytes.NewReader(nil), err
+	}
+
+	for i, value := range values {
+		// keys expired since the scan are answered nil
+		if value, ok := value.(string); ok {
+			ret = append(ret, entry{Key: keys[i], Value: []byte(value)})
+		}
+	}
+
+	return patternReader(ret)
+}
+
+// redisStorage.Keys Returns the sorted keys matching a pattern or error if it fails
+func (s *redisStorage) Keys(pattern string) ([]string, error) {
+	keys, err := s.scan(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// redisStorage.CountPattern Returns count of entries matching a pattern or error if it fails
+func (s *redisStorage) CountPattern(pattern string) (int, error) {
+	keys, err := s.scan(pattern)
+
+	return len(keys), err
+}
+
+// redisStorage.ExpirePattern Sets timeout on all entries matching a pattern, returns count of entries updated or error if it fails
+func (s *redisStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	keys, err := s.scan(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	cmds := make([]redis.Cmder, len(keys))
+	_, err = s.client.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			switch {
+			case expiration == noExpiration:
+				cmds[i] = pipe.Persist(s.ctx, s.prefix+key)
+			case expiration <= 0:
+				cmds[i] = pipe.Del(s.ctx, s.prefix+key)
+			default:
+				cmds[i] = pipe.PExpire(s.ctx, s.prefix+key, expiration)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	// PERSIST answers false for keys already without expiration, every key scanned is counted
+	if expiration == noExpiration {
+		return len(keys), nil
+	}
+
+	updated := 0
+	for _, cmd := range cmds {
+		switch cmd := cmd.(type) {
+		case *redis.BoolCmd:
+			if cmd.Val() {
+				updated++
+			}
+		case *redis.IntCmd:
+			updated += int(cmd.Val())
+		}
+	}
+
+	return updated, nil
+}
+
+// redisStorage.Delete Deletes an entry by key, returns error if it fails
+func (s *redisStorage) Delete(key string) error {
+	n, err := s.client.Del(s.ctx, s.prefix+key).Result()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errNotExists
+	}
+
+	return nil
+}
+
+// redisStorage.DeleteAll Deletes all entries, the whole database without prefix, returns error if it fails
+func (s *redisStorage) DeleteAll() error {
+	if s.prefix == "" {
+		return s.client.FlushDB(s.ctx).Err()
+	}
+
+	iter := s.client.Scan(s.ctx, 0, escapeGlob(s.prefix)+"*", redisScanCount).Iterator()
+	for iter.Next(s.ctx) {
+		if err := s.client.Del(s.ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+// redisStorage.PurgeExpired Purges nothing, redis deletes keys itself once expired
+func (s *redisStorage) PurgeExpired() ([]string, error) {
+	return []string{}, nil
+}
+
+// redisStorage.Put Saves an entry by key with timeout, returns error if it fails
+func (s *redisStorage) Put(key string, value string, expiration time.Duration) error {
+	return s.set(s.client, key, value, expiration)
+}
+
+// redisStorage.PutMany Saves every entry by key with timeout in a single pipeline, returns a PutManyError of the keys that failed
+func (s *redisStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pipe := s.client.Pipeline()
+	for _, key := range keys {
+		if err := s.set(pipe, key, entries[key], expiration); err != nil {
+			return err
+		}
+	}
+
+	// every key queues a single command, answered in the same order
+	cmds, err := pipe.Exec(s.ctx)
+	if len(cmds) != len(keys) {
+		return err
+	}
+
+	failed := PutManyError{}
+	for i, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed[keys[i]] = cmd.Err()
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// redisStorage.PutWithMetadata Saves an entry by key with timeout, failing along metadata which is not stored on redis
+// but for its content type, dropped as values are served as application/octet-stream
+func (s *redisStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	for name := range metadata {
+		if name != "Content-Type" {
+			return errRedisMetadata
+		}
+	}
+
+	return s.Put(key, value, expiration)
+}
+
+// redisStorage.Push Appends an element to the JSON array stored by key, returns error if it fails
+func (s *redisStorage) Push(key string, element string) error {
+	return s.update(key, func(value []byte, exists bool) ([]byte, error) {
+		return pushElement(value, element)
+	})
+}
+
+// redisStorage.Pop Removes and returns the last element of the JSON array stored by key or error if it fails
+func (s *redisStorage) Pop(key string) (string, error) {
+	var element string
+	err := s.update(key, func(value []byte, exists bool) ([]byte, error) {
+		if !exists {
+			return nil, errNotExists
+		}
+
+		var err error
+		value, element, err = popElement(value)
+
+		return value, err
+	})
+
+	return element, err
+}
+
+// redisStorage.Increment Adds delta to the integer stored by key keeping its expiration, a missing key counting as 0,
+// returns the new value or error if it fails
+func (s *redisStorage) Increment(key string, delta int64) (int64, error) {
+	var counter int64
+	err := s.update(key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, counter, err = incrementValue(value, delta)
+
+		return value, err
+	})
+
+	return counter, err
+}
+
+// redisStorage.Decrement Subtracts delta from the integer stored by key, returns the new value or error if it fails
+func (s *redisStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// redisStorage.Flush Closes the connections to redis
+func (s *redisStorage) Flush() {
+	if err := s.client.Close(); err != nil {
+		logger.Warnf("closing redis connections failed: %s", err)
+	}
+}
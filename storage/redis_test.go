@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func boostrapRedis(t *testing.T, prefix string) (*miniredis.Miniredis, *redisStorage) {
+	server := miniredis.RunT(t)
+
+	storage, err := NewRedisStorage(server.Addr(), RedisKeyPrefix(prefix))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	t.Cleanup(storage.Flush)
+
+	return server, storage
+}
+
+func TestRedisStorage_Type(t *testing.T) {
+	_, storage := boostrapRedis(t, "")
+
+	if storage.Type() != "redis" {
+		t.Fatalf("expected: %s, found : %s", "redis", storage.Type())
+	}
+}
+
+func TestRedisStorage_PutGetDelete(t *testing.T) {
+	server, storage := boostrapRedis(t, "kvs:")
+
+	err := storage.Put("a key", "a value", time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if value, err := server.Get("kvs:a key"); err != nil || value != "a value" {
+		t.Fatalf("expected: %s, found : %s (%v)", "a value", value, err)
+	}
+
+	r, err := storage.Get("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(value) != "a value" {
+		t.Fatalf("expected: %s, found : %s", "a value", value)
+	}
+
+	err = storage.Delete("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Get("a key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	if err := storage.Delete("a key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
+
+func TestRedisStorage_PutWithExpiration(t *testing.T) {
+	server, storage := boostrapRedis(t, "")
+
+	err := storage.Put("a key", "a value", time.Minute)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	ttl, err := storage.TTL("a key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if ttl != time.Minute {
+		t.Fatalf("expected: %s, found : %s", time.Minute, ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	if exists, err := storage.Exists("a key"); err != nil || exists {
+		t.Fatalf("expected: %v, found : %v (%v)", false, exists, err)
+	}
+
+	err = storage.Put("an expired key", "a value", 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Get("an expired key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+}
+
+func TestRedisStorage_GetPattern(t *testing.T) {
+	server, storage := boostrapRedis(t, "kvs:")
+
+	if err := server.Set("another app key", "not ours"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, value := range map[string]string{"a key": "a value", "another key": "another value", "a/nested key": "a nested value"} {
+		if err := storage.Put(key, value, time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	r, err := storage.GetPattern("a*", 0, 0)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// as with the other providers * does not match /
+	expected := `[{"a key":"a value"},{"another key":"another value"}]`
+	if string(value) != expected {
+		t.Fatalf("expected: %s, found : %s", expected, value)
+	}
+
+	count, err := storage.CountPattern("*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if count != 2 {
+		t.Fatalf("expected: %d, found : %d", 2, count)
+	}
+
+	err = storage.DeleteAll()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if keys := server.Keys(); !reflect.DeepEqual(keys, []string{"another app key"}) {
+		t.Fatalf("expected: %v, found : %v", []string{"another app key"}, keys)
+	}
+}
+
+func TestRedisStorage_PushPop(t *testing.T) {
+	_, storage := boostrapRedis(t, "")
+
+	for _, element := range []string{"1", `"two"`} {
+		if err := storage.Push("a list", element); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	for _, expected := range []string{`"two"`, "1"} {
+		element, err := storage.Pop("a list")
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if element != expected {
+			t.Fatalf("expected: %s, found : %s", expected, element)
+		}
+	}
+
+	if _, err := storage.Pop("a list"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Push("a key", "1"); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
+
+func TestRedisStorage_PutIfEmpty(t *testing.T) {
+	_, storage := boostrapRedis(t, "kvs:")
+
+	tests := []struct {
+		prefix  string
+		key     string
+		created bool
+	}{
+		{"jobs/", "jobs/leader", true},
+		{"jobs/", "jobs/another leader", false},
+		{"other/", "other/leader", true},
+	}
+
+	for _, test := range tests {
+		created, err := storage.PutIfEmpty(test.prefix, test.key, "me", time.Duration(-1))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if created != test.created {
+			t.Fatalf("expected: %v, found : %v", test.created, created)
+		}
+	}
+}
+
+func TestRedisStorage_Unsupported(t *testing.T) {
+	_, storage := boostrapRedis(t, "")
+
+	err := storage.PutWithMetadata("a key", "a value", Metadata{"Content-Disposition": "attachment"}, time.Duration(-1))
+	if !storage.IsForbidden(err) {
+		t.Fatalf("expected forbidden, found : %v", err)
+	}
+
+	if _, err := storage.PutIfVersion("a key", "a value", 1, time.Duration(-1)); !storage.IsForbidden(err) {
+		t.Fatalf("expected forbidden, found : %v", err)
+	}
+}
+
+func TestRedisStorage_PutMany(t *testing.T) {
+	server, storage := boostrapRedis(t, "kvs:")
+
+	if err := storage.PutMany(map[string]string{"a key": "a value", "another key": "another value"}, time.Minute); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for key, expected := range map[string]string{"a key": "a value", "another key": "another value"} {
+		value, err := server.Get("kvs:" + key)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if value != expected {
+			t.Fatalf("expected: %s, found : %s", expected, value)
+		}
+
+		if ttl := server.TTL("kvs:" + key); ttl != time.Minute {
+			t.Fatalf("expected: %s, found : %s", time.Minute, ttl)
+		}
+	}
+}
+
+func TestRedisStorage_GetMany(t *testing.T) {
+	_, storage := boostrapRedis(t, "kvs:")
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	values, err := storage.GetMany([]string{"a key", "a missing key"})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := map[string][]byte{"a key": []byte("a value")}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, values)
+	}
+}
+
+func TestRedisStorage_PutWithMetadata(t *testing.T) {
+	_, storage := boostrapRedis(t, "kvs:")
+
+	err := storage.PutWithMetadata("a key", "a value", Metadata{"Content-Type": "text/plain"}, time.Duration(-1))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	err = storage.PutWithMetadata("a key", "a value", Metadata{"Content-Disposition": "inline"}, time.Duration(-1))
+	if err != errRedisMetadata {
+		t.Fatalf("expected: %s, found : %v", errRedisMetadata, err)
+	}
+}
+
+func TestRedisStorage_Keys(t *testing.T) {
+	_, storage := boostrapRedis(t, "kvs:")
+
+	for _, key := range []string{"b key", "a key", "another key"} {
+		if err := storage.Put(key, "a value", time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	keys, err := storage.Keys("a*")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	expected := []string{"a key", "another key"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, keys)
+	}
+}
+
+func TestRedisStorage_Increment(t *testing.T) {
+	server, storage := boostrapRedis(t, "kvs:")
+
+	if err := storage.Put("a counter", "40", time.Minute); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	counter, err := storage.Increment("a counter", 2)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if counter != 42 {
+		t.Fatalf("expected: %d, found : %d", 42, counter)
+	}
+
+	// the expiration is kept
+	if ttl := server.TTL("kvs:a counter"); ttl != time.Minute {
+		t.Fatalf("expected: %s, found : %s", time.Minute, ttl)
+	}
+
+	if counter, err := storage.Decrement("another counter", 1); err != nil || counter != -1 {
+		t.Fatalf("expected: %d, found : %d (%v)", -1, counter, err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, err := storage.Increment("a key", 1); !storage.IsConflict(err) {
+		t.Fatalf("expected conflict, found : %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// replicationOp is a write of the primary to apply on the secondary
+type replicationOp struct {
+	name     string
+	key      string
+	enqueued time.Time
+	apply    func(secondary Storage) error
+}
+
+type replicatingStorage struct {
+	Storage
+
+	secondary Storage
+	queue     chan replicationOp
+	done      chan struct{}
+	flush     *sync.Once
+}
+
+// NewReplicatingStorage Decorator for storage
+// mirrors every successful write of storage to secondary in background through a queue of queueSize operations,
+// dropping them when the queue is full so a slow secondary never delays the primary, reads are served by storage only
+func NewReplicatingStorage(storage Storage, secondary Storage, queueSize int) *replicatingStorage {
+	s := &replicatingStorage{
+		Storage:   storage,
+		secondary: secondary,
+		queue:     make(chan replicationOp, queueSize),
+		done:      make(chan struct{}),
+		flush:     &sync.Once{},
+	}
+
+	go s.replicate()
+
+	return s
+}
+
+// replicatingStorage.WithContext Returns the storage bound to ctx replicating through this one queue,
+// the secondary is not bound as operations are replicated after the request
+func (s *replicatingStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// replicatingStorage.Rejected Returns the operations the decorated storage rejects
+func (s *replicatingStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+// replicate Applies queued operations on the secondary in order until the queue is closed
+func (s *replicatingStorage) replicate() {
+	defer close(s.done)
+
+	for op := range s.queue {
+		if err := op.apply(s.secondary); err != nil && !s.secondary.IsNotExist(err) {
+			logger.Warnf("replication of %s (%s) failed after %s: %s", op.name, op.key, time.Since(op.enqueued), err)
+			continue
+		}
+
+		logger.Debugf("replicated %s (%s) with lag %s", op.name, op.key, time.Since(op.enqueued))
+	}
+}
+
+// enqueue Queues an operation for the secondary, dropping it if the queue is full
+func (s *replicatingStorage) enqueue(name string, key string, apply func(secondary Storage) error) {
+	select {
+	case s.queue <- replicationOp{name: name, key: key, enqueued: time.Now(), apply: apply}:
+	default:
+		logger.Warnf("replication queue full, dropped %s (%s)", name, key)
+	}
+}
+
+// replicatingStorage.Put Saves an entry by key with timeout and replicates it, returns error if it fails
+func (s *replicatingStorage) Put(key string, value string, expiration time.Duration) error {
+	if err := s.Storage.Put(key, value, expiration); err != nil {
+		return err
+	}
+
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, value, expiration)
+	})
+
+	return nil
+}
+
+// replicatingStorage.PutMany Saves every entry by key with timeout replicating each, returns a PutManyError of the keys that failed
+func (s *replicatingStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return putMany(s, entries, expiration)
+}
+
+// replicatingStorage.PutWithMetadata Saves an entry by key with timeout along metadata and replicates it, returns error if it fails
+func (s *replicatingStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	if err := s.Storage.PutWithMetadata(key, value, metadata, expiration); err != nil {
+		return err
+	}
+
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.PutWithMetadata(key, value, metadata, expiration)
+	})
+
+	return nil
+}
+
+// replicatingStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing and replicating the creation, or error if it fails
+func (s *replicatingStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error) {
+	value, created, err := s.Storage.GetOrCreate(key, defaultValue, expiration)
+	if err != nil || !created {
+		return value, created, err
+	}
+
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, defaultValue, expiration)
+	})
+
+	return value, created, nil
+}
+
+// replicatingStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold and replicates it, returns whether it was saved or error if it fails
+func (s *replicatingStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, value, expiration)
+	})
+
+	return saved, nil
+}
+
+// replicatingStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue and replicates it, returns whether it was saved or error if it fails
+func (s *replicatingStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, newValue, expiration)
+	})
+
+	return saved, nil
+}
+
+// replicatingStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists and replicates it, returns whether it was saved or error if it fails
+func (s *replicatingStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error) {
+	saved, err := s.Storage.PutIfEmpty(prefix, key, value, expiration)
+	if err != nil || !saved {
+		return saved, err
+	}
+
+	// emptiness is decided on the primary, the secondary gets the value as it is
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, value, expiration)
+	})
+
+	return saved, nil
+}
+
+// replicatingStorage.PutIfVersion Saves an entry by key with timeout if its version is version and replicates it, returns the new version or error if it fails
+func (s *replicatingStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error) {
+	newVersion, err := s.Storage.PutIfVersion(key, value, version, expiration)
+	if err != nil {
+		return newVersion, err
+	}
+
+	// versions are local to every storage, the secondary gets the value as it is
+	s.enqueue("put", key, func(secondary Storage) error {
+		return secondary.Put(key, value, expiration)
+	})
+
+	return newVersion, nil
+}
+
+// replicatingStorage.ExpirePattern Updates expiration of entries matching a pattern and replicates it, returns count updated or error if it fails
+func (s *replicatingStorage) ExpirePattern(pattern string, expiration time.Duration) (int, error) {
+	updated, err := s.Storage.ExpirePattern(pattern, expiration)
+	if err != nil {
+		return updated, err
+	}
+
+	s.enqueue("expire", pattern, func(secondary Storage) error {
+		_, err := secondary.ExpirePattern(pattern, expiration)
+		return err
+	})
+
+	return updated, nil
+}
+
+// replicatingStorage.Delete Deletes an entry by key and replicates it, returns error if it fails
+func (s *replicatingStorage) Delete(key string) error {
+	if err := s.Storage.Delete(key); err != nil {
+		return err
+	}
+
+	s.enqueue("delete", key, func(secondary Storage) error {
+		return secondary.Delete(key)
+	})
+
+	return nil
+}
+
+// replicatingStorage.DeleteIfVersion Deletes an entry by key if its version is version and replicates it, returns error if it fails
+func (s *replicatingStorage) DeleteIfVersion(key string, version int64) error {
+	if err := s.Storage.DeleteIfVersion(key, version); err != nil {
+		return err
+	}
+
+	s.enqueue("delete", key, func(secondary Storage) error {
+		return secondary.Delete(key)
+	})
+
+	return nil
+}
+
+// replicatingStorage.DeleteAll Deletes all entries and replicates it, returns error if it fails
+func (s *replicatingStorage) DeleteAll() error {
+	if err := s.Storage.DeleteAll(); err != nil {
+		return err
+	}
+
+	s.enqueue("delete_all", "*", func(secondary Storage) error {
+		return secondary.DeleteAll()
+	})
+
+	return nil
+}
+
+// replicatingStorage.PurgeExpired Deletes all expired entries and replicates it, returns the keys purged or error if it fails
+func (s *replicatingStorage) PurgeExpired() ([]string, error) {
+	purged, err := s.Storage.PurgeExpired()
+	if err != nil {
+		return purged, err
+	}
+
+	s.enqueue("purge_expired", "*", func(secondary Storage) error {
+		_, err := secondary.PurgeExpired()
+		return err
+	})
+
+	return purged, nil
+}
+
+// replicatingStorage.Push Appends an element to the JSON array stored by key and replicates it, returns error if it fails
+func (s *replicatingStorage) Push(key string, element string) error {
+	if err := s.Storage.Push(key, element); err != nil {
+		return err
+	}
+
+	s.enqueue("push", key, func(secondary Storage) error {
+		return secondary.Push(key, element)
+	})
+
+	return nil
+}
+
+// replicatingStorage.Pop Removes and returns the last element of the JSON array stored by key and replicates it or error if it fails
+func (s *replicatingStorage) Pop(key string) (string, error) {
+	element, err := s.Storage.Pop(key)
+	if err != nil {
+		return element, err
+	}
+
+	s.enqueue("pop", key, func(secondary Storage) error {
+		_, err := secondary.Pop(key)
+		return err
+	})
+
+	return element, nil
+}
+
+// replicatingStorage.Increment Adds delta to the integer stored by key and replicates it, returns the new value or error if it fails
+func (s *replicatingStorage) Increment(key string, delta int64) (int64, error) {
+	counter, err := s.Storage.Increment(key, delta)
+	if err != nil {
+		return counter, err
+	}
+
+	s.enqueue("increment", key, func(secondary Storage) error {
+		_, err := secondary.Increment(key, delta)
+		return err
+	})
+
+	return counter, nil
+}
+
+// replicatingStorage.Decrement Subtracts delta from the integer stored by key and replicates it, returns the new value or error if it fails
+func (s *replicatingStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
+
+// replicatingStorage.Flush Drains the replication queue then flushes the secondary and storage
+func (s *replicatingStorage) Flush() {
+	s.flush.Do(func() {
+		close(s.queue)
+		<-s.done
+
+		s.secondary.Flush()
+	})
+
+	s.Storage.Flush()
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplicatingStorage(t *testing.T) {
+	tmpDir := boostrapMemory(t)
+
+	primary, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	secondaryDir, err := ioutil.TempDir("", "keyvaluestorage-secondary")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(secondaryDir)
+
+	secondary, err := NewMemoryStorage(secondaryDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage := NewReplicatingStorage(primary, NewLatencyStorage(secondary, 100*time.Millisecond, 0), 10)
+
+	start := time.Now()
+	for _, key := range []string{"a key", "another key", "a third key"} {
+		if err := storage.Put(key, "a value", time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	if err := storage.Delete("another key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected primary writes not to wait for the secondary, took : %s", elapsed)
+	}
+
+	expected := `[{"a key":"a value"},{"a third key":"a value"}]`
+
+	var chk []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		r, err := secondary.GetPattern("*", 0, 0)
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if chk, err = ioutil.ReadAll(r); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if string(chk) == expected {
+			return
+		}
+	}
+
+	t.Fatalf("expected: %s, found : %s", expected, chk)
+}
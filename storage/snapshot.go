@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// interval between progress logs while loading a memory storage snapshot
+var snapshotProgressInterval = 5 * time.Second
+
+var errSnapshotLoadCanceled = errors.New("snapshot load canceled")
+
+// writeSnapshotData Writes data to the temporary snapshot file, a failing disk being simulated by tests through it
+var writeSnapshotData = func(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+
+	return err
+}
+
+// progressReader counts bytes read from r, failing reads once canceled
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	canceled int32
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if atomic.LoadInt32(&p.canceled) == 1 {
+		return 0, errSnapshotLoadCanceled
+	}
+
+	n, err := p.r.Read(b)
+	atomic.AddInt64(&p.n, int64(n))
+
+	return n, err
+}
+
+type snapshotLoad struct {
+	data map[string]entry
+	err  error
+}
+
+// loadSnapshot Decodes the memory storage snapshot of size bytes read from r logging progress,
+// failing once timeout, if any, is elapsed
+func loadSnapshot(r io.Reader, size int64, timeout time.Duration) (map[string]entry, error) {
+	progress := &progressReader{r: r}
+
+	done := make(chan snapshotLoad, 1)
+	go func() {
+		var data map[string]entry
+		err := json.NewDecoder(progress).Decode(&data)
+		if err == io.EOF {
+			err = nil
+		}
+
+		if data == nil {
+			data = map[string]entry{}
+		}
+
+		done <- snapshotLoad{data: data, err: err}
+	}()
+
+	ticker := time.NewTicker(snapshotProgressInterval)
+	defer ticker.Stop()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case load := <-done:
+			return load.data, load.err
+		case <-ticker.C:
+			logger.Infof("loading memory storage snapshot: %d of %d bytes read", atomic.LoadInt64(&progress.n), size)
+		case <-expired:
+			atomic.StoreInt32(&progress.canceled, 1)
+			return nil, fmt.Errorf("loading memory storage snapshot (%d bytes) timed out after %s", size, timeout)
+		}
+	}
+}
+
+// replaceSnapshot Replaces fileName in storageDir with data atomically: data is synced to a temporary file
+// renamed over fileName, then storageDir is synced, so a crash leaves either the previous snapshot or the new one
+func replaceSnapshot(storageDir string, fileName string, data []byte) error {
+	if err := os.Mkdir(storageDir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("cannot access storageDir (%s): %s", storageDir, err)
+	}
+
+	f, err := ioutil.TempFile(storageDir, fileName+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := f.Name()
+	if err := writeSnapshotData(f, data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(storageDir, fileName)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dir, err := os.Open(storageDir)
+	if err != nil {
+		return err
+	}
+
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// entryMark identifies the write an entry comes from, a key deleted and then set again starting over with its version
+type entryMark struct {
+	version int64
+	created int64
+}
+
+// snapshotState The snapshot the memory storage last wrote or loaded, to tell changes of other processes from its own
+type snapshotState struct {
+	modTime time.Time
+	size    int64
+	synced  map[string]entryMark
+}
+
+func newSnapshotState(data map[string]entry, info os.FileInfo) snapshotState {
+	synced := make(map[string]entryMark, len(data))
+	for key, entry := range data {
+		synced[key] = entryMark{version: entry.Version, created: entry.Created}
+	}
+
+	return snapshotState{modTime: info.ModTime(), size: info.Size(), synced: synced}
+}
+
+// snapshotState.replaced Returns whether info is of a snapshot file other than the one of the state
+func (st snapshotState) replaced(info os.FileInfo) bool {
+	return !info.ModTime().Equal(st.modTime) || info.Size() != st.size
+}
+
+// snapshotState.changedLocally Returns whether key was set or deleted since the snapshot of the state, current being its entry if found
+func (st snapshotState) changedLocally(key string, current entry, found bool) bool {
+	mark, synced := st.synced[key]
+	if !found || !synced {
+		return found != synced
+	}
+
+	return mark != entryMark{version: current.Version, created: current.Created}
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLargeSnapshot Returns a dir of its own holding a large memory storage snapshot
+func writeLargeSnapshot(t *testing.T) string {
+	storageDir, err := ioutil.TempDir("", "keyvaluestorage-snapshot")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	data := make(map[string]entry, 200000)
+	for i := 0; i < 200000; i++ {
+		key := fmt.Sprintf("key %d", i)
+		data[key] = entry{Key: key, Value: []byte("a value"), Expiration: -1}
+	}
+
+	snapshot, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(storageDir, memoryCacheFile), snapshot, 0600); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	return storageDir
+}
+
+func TestMemoryStorage_SnapshotLoadTimeout(t *testing.T) {
+	tmpDir := writeLargeSnapshot(t)
+	defer os.RemoveAll(tmpDir)
+
+	_, err := NewMemoryStorage(tmpDir, SnapshotLoadTimeout(time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, found : %v", err)
+	}
+}
+
+func TestMemoryStorage_SnapshotLoadProgress(t *testing.T) {
+	tmpDir := writeLargeSnapshot(t)
+	defer os.RemoveAll(tmpDir)
+
+	defer func(interval time.Duration) {
+		snapshotProgressInterval = interval
+	}(snapshotProgressInterval)
+	snapshotProgressInterval = time.Millisecond
+
+	hook := test.NewLocal(logger)
+
+	storage, err := NewMemoryStorage(tmpDir, SnapshotLoadTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(storage.data) != 200000 {
+		t.Fatalf("expected: %d, found : %d", 200000, len(storage.data))
+	}
+
+	progress := 0
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "loading memory storage snapshot") {
+			progress++
+		}
+	}
+
+	if progress == 0 {
+		t.Fatalf("expected progress of the snapshot load to be logged")
+	}
+}
+
+func TestMemoryStorage_FlushFailures(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-flush")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewMemoryStorage(tmpDir, MaxFlushFailures(2))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// memory.db turning into a dir makes every flush fail as a full disk would
+	snapshotPath := filepath.Join(tmpDir, memoryCacheFile)
+	if err := os.Remove(snapshotPath); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := os.Mkdir(snapshotPath, 0700); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := storage.Put("a key", "a value", time.Hour); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if err := storage.flush(); err == nil {
+			t.Fatalf("expected flush %d to fail", i)
+		}
+
+		health := storage.Health()
+		if !health.Degraded || health.FlushFailures != i || health.FlushFailuresTotal != int64(i) {
+			t.Fatalf("expected degraded after %d failures, found : %+v", i, health)
+		}
+	}
+
+	// every write is refused, deletes and expirations included
+	for name, write := range map[string]func() error{
+		"Put": func() error {
+			return storage.Put("a key", "a value", time.Duration(-1))
+		},
+		"Delete": func() error {
+			return storage.Delete("a key")
+		},
+		"DeleteIfVersion": func() error {
+			return storage.DeleteIfVersion("a key", 2)
+		},
+		"DeleteAll": storage.DeleteAll,
+		"ExpirePattern": func() error {
+			_, err := storage.ExpirePattern("*", time.Minute)
+			return err
+		},
+		"PurgeExpired": func() error {
+			_, err := storage.PurgeExpired()
+			return err
+		},
+		"GetAndTouch": func() error {
+			_, err := storage.GetAndTouch("a key", 2*time.Hour, 2*time.Hour)
+			return err
+		},
+	} {
+		if err := write(); !storage.IsUnavailable(err) {
+			t.Fatalf("expected %s unavailable, found : %v", name, err)
+		}
+	}
+
+	if _, err := storage.Get("a key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := os.Remove(snapshotPath); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.flush(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if health := storage.Health(); health.Degraded || health.FlushFailures != 0 || health.FlushFailuresTotal != 2 {
+		t.Fatalf("expected healthy, found : %+v", health)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+}
+
+func TestMemoryStorage_FlushAtomic(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-flush")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.flush(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	snapshotPath := filepath.Join(tmpDir, memoryCacheFile)
+	previous, err := ioutil.ReadFile(snapshotPath)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// the disk fills up halfway through the next snapshot
+	defer func(write func(*os.File, []byte) error) {
+		writeSnapshotData = write
+	}(writeSnapshotData)
+	writeSnapshotData = func(f *os.File, data []byte) error {
+		if _, err := f.Write(data[:len(data)/2]); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("no space left on device")
+	}
+
+	if err := storage.Put("another key", "another value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.flush(); err == nil {
+		t.Fatalf("expected flush to fail")
+	}
+
+	found, err := ioutil.ReadFile(snapshotPath)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if string(found) != string(previous) {
+		t.Fatalf("expected: %s, found : %s", previous, found)
+	}
+
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != memoryCacheFile {
+		t.Fatalf("expected only %s, found : %d files", memoryCacheFile, len(files))
+	}
+
+	restored, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if _, ok := restored.data["a key"]; !ok || len(restored.data) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(restored.data))
+	}
+}
+
+func TestMemoryStorage_SnapshotReload(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keyvaluestorage-reload")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewMemoryStorage(tmpDir, SnapshotReload(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	defer storage.Flush()
+
+	if err := storage.Put("a key", "a value", -1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.flush(); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Put("a local key", "a local value", -1); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	// another process replaces the snapshot, "a key" deleted and "another key" set
+	external, err := json.Marshal(map[string]entry{
+		"another key": {Key: "another key", Value: []byte("another value"), Expiration: -1, Type: typeString, Created: time.Now().Unix(), Version: 1},
+	})
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := replaceSnapshot(tmpDir, memoryCacheFile, external); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpDir, memoryCacheFile), modTime, modTime); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := storage.Get("another key"); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected: %s, found : %s", "another key reloaded", "not found")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := storage.Get("a key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected: %s, found : %v", errNotExists, err)
+	}
+
+	reader, err := storage.Get("a local key")
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	value, _ := ioutil.ReadAll(reader)
+	if string(value) != "a local value" {
+		t.Fatalf("expected: %s, found : %s", "a local value", value)
+	}
+}
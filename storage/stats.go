@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OperationStats are the latency percentiles, in milliseconds, and error rate over the recent calls of an operation
+type OperationStats struct {
+	Count     int64   `json:"count"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+}
+
+// opSample is the outcome of a call
+type opSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// opStats keeps totals and the last samples of an operation
+type opStats struct {
+	count   int64
+	errors  int64
+	samples []opSample
+	next    int
+}
+
+// StorageStats collects latencies and errors of storage operations over a window of recent calls per operation
+type StorageStats struct {
+	window int
+
+	mu  sync.Mutex
+	ops map[string]*opStats
+}
+
+// NewStorageStats Returns stats keeping the last window calls of every operation
+func NewStorageStats(window int) *StorageStats {
+	return &StorageStats{
+		window: window,
+		ops:    map[string]*opStats{},
+	}
+}
+
+// StorageStats.record Adds the outcome of a call of operation
+func (s *StorageStats) record(operation string, latency time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.ops[operation]
+	if !ok {
+		op = &opStats{samples: make([]opSample, 0, s.window)}
+		s.ops[operation] = op
+	}
+
+	op.count++
+	if failed {
+		op.errors++
+	}
+
+	sample := opSample{latency: latency, failed: failed}
+	if len(op.samples) < s.window {
+		op.samples = append(op.samples, sample)
+		return
+	}
+
+	op.samples[op.next] = sample
+	op.next = (op.next + 1) % s.window
+}
+
+// StorageStats.Reset Discards everything collected
+func (s *StorageStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops = map[string]*opStats{}
+}
+
+// StorageStats.Snapshot Returns the stats of every operation called so far
+func (s *StorageStats) Snapshot() map[string]OperationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]OperationStats, len(s.ops))
+	for operation, op := range s.ops {
+		latencies := make([]time.Duration, len(op.samples))
+		failed := 0
+		for i, sample := range op.samples {
+			latencies[i] = sample.latency
+			if sample.failed {
+				failed++
+			}
+		}
+
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+
+		snapshot[operation] = OperationStats{
+			Count:     op.count,
+			Errors:    op.errors,
+			ErrorRate: float64(failed) / float64(len(op.samples)),
+			P50:       percentile(latencies, 0.5),
+			P90:       percentile(latencies, 0.9),
+			P99:       percentile(latencies, 0.99),
+		}
+	}
+
+	return snapshot
+}
+
+// percentile Returns the p-th of sorted latencies in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(p * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// OperationTracer Starts tracing an operation of a storage, returning the func ending it
+// with the error the operation failed with, nil if it did not fail
+type OperationTracer func(operation string) func(failure error)
+
+type statsStorage struct {
+	Storage
+
+	stats  *StorageStats
+	tracer OperationTracer
+}
+
+// NewStatsStorage Decorator for storage
+// times every operation of storage recording it in stats, errors about the stored values not counting as failures
+func NewStatsStorage(storage Storage, stats *StorageStats) *statsStorage {
+	return &statsStorage{
+		Storage: storage,
+		stats:   stats,
+	}
+}
+
+// NewTracedStatsStorage Decorator for storage
+// as NewStatsStorage, also tracing every operation with tracer
+func NewTracedStatsStorage(storage Storage, stats *StorageStats, tracer OperationTracer) *statsStorage {
+	return &statsStorage{
+		Storage: storage,
+		stats:   stats,
+		tracer:  tracer,
+	}
+}
+
+// observe Runs op recording its latency and outcome as operation
+func (s *statsStorage) observe(operation string, op func() error) error {
+	var end func(error)
+	if s.tracer != nil {
+		end = s.tracer(operation)
+	}
+
+	start := time.Now()
+	err := op()
+	failed := isFailure(s.Storage, err)
+	s.stats.record(operation, time.Since(start), failed)
+
+	if end != nil && failed {
+		end(err)
+	} else if end != nil {
+		end(nil)
+	}
+
+	return err
+}
+
+// statsStorage.Put Saves an entry by key with timeout timing it, returns error if it fails
+func (s *statsStorage) Put(key string, value string, expiration time.Duration) error {
+	return s.observe("put", func() error {
+		return s.Storage.Put(key, value, expiration)
+	})
+}
+
+// statsStorage.PutMany Saves every entry by key with timeout timing the whole batch, returns a PutManyError of the keys that failed
+func (s *statsStorage) PutMany(entries map[string]string, expiration time.Duration) error {
+	return s.observe("put_many", func() error {
+		return s.Storage.PutMany(entries, expiration)
+	})
+}
+
+// statsStorage.PutWithMetadata Saves an entry by key with timeout along metadata timing it, returns error if it fails
+func (s *statsStorage) PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error {
+	return s.observe("put", func() error {
+		return s.Storage.PutWithMetadata(key, value, metadata, expiration)
+	})
+}
+
+// statsStorage.GetMetadata Returns the metadata stored along the value of a key timing it or error if it fails
+func (s *statsStorage) GetMetadata(key string) (metadata Metadata, err error) {
+	err = s.observe("get_metadata", func() error {
+		metadata, err = s.Storage.GetMetadata(key)
+		return err
+	})
+
+	return metadata, err
+}
+
+// statsStorage.Get Returns io.Reader for a key timing it or error if it fails
+func (s *statsStorage) Get(key string) (r io.Reader, err error) {
+	err = s.observe("get", func() error {
+		r, err = s.Storage.Get(key)
+		return err
+	})
+
+	return r, err
+}
+
+// statsStorage.GetMany Returns the values of the existing keys timing the whole batch or error if it fails
+func (s *statsStorage) GetMany(keys []string) (values map[string][]byte, err error) {
+	err = s.observe("get_many", func() error {
+		values, err = s.Storage.GetMany(keys)
+		return err
+	})
+
+	return values, err
+}
+
+// statsStorage.GetStale Returns io.Reader for a key and whether it is stale timing it or error if it fails
+func (s *statsStorage) GetStale(key string) (r io.Reader, stale bool, err error) {
+	err = s.observe("get", func() error {
+		r, stale, err = s.Storage.GetStale(key)
+		return err
+	})
+
+	return r, stale, err
+}
+
+// statsStorage.GetVersioned Returns io.Reader for a key with its version timing it or error if it fails
+func (s *statsStorage) GetVersioned(key string) (r io.Reader, version int64, err error) {
+	err = s.observe("get", func() error {
+		r, version, err = s.Storage.GetVersioned(key)
+		return err
+	})
+
+	return r, version, err
+}
+
+// statsStorage.GetAndTouch Returns io.Reader for a key sliding its expiration timing it, or error if it fails
+func (s *statsStorage) GetAndTouch(key string, window time.Duration, threshold time.Duration) (r io.Reader, err error) {
+	err = s.observe("get_and_touch", func() error {
+		r, err = s.Storage.GetAndTouch(key, window, threshold)
+		return err
+	})
+
+	return r, err
+}
+
+// statsStorage.GetOrCreate Returns value for a key, creating it with defaultValue if missing, timing it or error if it fails
+func (s *statsStorage) GetOrCreate(key string, defaultValue string, expiration time.Duration) (value []byte, created bool, err error) {
+	err = s.observe("get_or_create", func() error {
+		value, created, err = s.Storage.GetOrCreate(key, defaultValue, expiration)
+		return err
+	})
+
+	return value, created, err
+}
+
+// statsStorage.PutIfTTLBelow Saves an entry by key with timeout if its remaining TTL is under threshold timing it, returns whether it was saved or error if it fails
+func (s *statsStorage) PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (saved bool, err error) {
+	err = s.observe("put", func() error {
+		saved, err = s.Storage.PutIfTTLBelow(key, value, threshold, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// statsStorage.CompareAndSwap Saves an entry by key with timeout if its value is oldValue timing it, returns whether it was saved or error if it fails
+func (s *statsStorage) CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (saved bool, err error) {
+	err = s.observe("compare_and_swap", func() error {
+		saved, err = s.Storage.CompareAndSwap(key, oldValue, newValue, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// statsStorage.PutIfEmpty Saves an entry by key with timeout if no key starting with prefix exists timing it, returns whether it was saved or error if it fails
+func (s *statsStorage) PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (saved bool, err error) {
+	err = s.observe("put", func() error {
+		saved, err = s.Storage.PutIfEmpty(prefix, key, value, expiration)
+		return err
+	})
+
+	return saved, err
+}
+
+// statsStorage.PutIfVersion Saves an entry by key with timeout if its version is version timing it, returns the new version or error if it fails
+func (s *statsStorage) PutIfVersion(key string, value string, version int64, expiration time.Duration) (newVersion int64, err error) {
+	err = s.observe("put", func() error {
+		newVersion, err = s.Storage.PutIfVersion(key, value, version, expiration)
+		return err
+	})
+
+	return newVersion, err
+}
+
+// statsStorage.DeleteIfVersion Deletes an entry by key if its version is version timing it, returns error if it fails
+func (s *statsStorage) DeleteIfVersion(key string, version int64) error {
+	return s.observe("delete", func() error {
+		return s.Storage.DeleteIfVersion(key, version)
+	})
+}
+
+// statsStorage.Exists Returns whether a key exists timing it or error if it fails
+func (s *statsStorage) Exists(key string) (exists bool, err error) {
+	err = s.observe("exists", func() error {
+		exists, err = s.Storage.Exists(key)
+		return err
+	})
+
+	return exists, err
+}
+
+// statsStorage.TTL Returns the time left before a key expires timing it or error if it fails
+func (s *statsStorage) TTL(key string) (ttl time.Duration, err error) {
+	err = s.observe("ttl", func() error {
+		ttl, err = s.Storage.TTL(key)
+		return err
+	})
+
+	return ttl, err
+}
+
+// statsStorage.ExistsMany Returns whether each key exists timing it or error if it fails
+func (s *statsStorage) ExistsMany(keys []string) (exists map[string]bool, err error) {
+	err = s.observe("exists_many", func() error {
+		exists, err = s.Storage.ExistsMany(keys)
+		return err
+	})
+
+	return exists, err
+}
+
+// statsStorage.GetPattern Returns io.Reader for a pattern timing it or error if it fails
+func (s *statsStorage) GetPattern(pattern string, limit int, offset int) (r io.Reader, err error) {
+	err = s.observe("get_pattern", func() error {
+		r, err = s.Storage.GetPattern(pattern, limit, offset)
+		return err
+	})
+
+	return r, err
+}
+
+// statsStorage.Keys Returns the keys matching a pattern timing it or error if it fails
+func (s *statsStorage) Keys(pattern string) (keys []string, err error) {
+	err = s.observe("keys", func() error {
+		keys, err = s.Storage.Keys(pattern)
+		return err
+	})
+
+	return keys, err
+}
+
+// statsStorage.CountPattern Returns count of entries matching a pattern timing it or error if it fails
+func (s *statsStorage) CountPattern(pattern string) (count int, err error) {
+	err = s.observe("count_pattern", func() error {
+		count, err = s.Storage.CountPattern(pattern)
+		return err
+	})
+
+	return count, err
+}
+
+// statsStorage.ExpirePattern Updates expiration of entries matching a pattern timing it, returns count updated or error if it fails
+func (s *statsStorage) ExpirePattern(pattern string, expiration time.Duration) (updated int, err error) {
+	err = s.observe("expire_pattern", func() error {
+		updated, err = s.Storage.ExpirePattern(pattern, expiration)
+		return err
+	})
+
+	return updated, err
+}
+
+// statsStorage.Delete Deletes an entry by key timing it, returns error if it fails
+func (s *statsStorage) Delete(key string) error {
+	return s.observe("delete", func() error {
+		return s.Storage.Delete(key)
+	})
+}
+
+// statsStorage.DeleteAll Deletes all entries timing it, returns error if it fails
+func (s *statsStorage) DeleteAll() error {
+	return s.observe("delete_all", func() error {
+		return s.Storage.DeleteAll()
+	})
+}
+
+// statsStorage.PurgeExpired Deletes all expired entries timing it, returns the keys purged or error if it fails
+func (s *statsStorage) PurgeExpired() (purged []string, err error) {
+	err = s.observe("purge_expired", func() error {
+		purged, err = s.Storage.PurgeExpired()
+		return err
+	})
+
+	return purged, err
+}
+
+// statsStorage.Push Appends an element to the JSON array stored by key timing it, returns error if it fails
+func (s *statsStorage) Push(key string, element string) error {
+	return s.observe("push", func() error {
+		return s.Storage.Push(key, element)
+	})
+}
+
+// statsStorage.Pop Removes and returns the last element of the JSON array stored by key timing it or error if it fails
+func (s *statsStorage) Pop(key string) (element string, err error) {
+	err = s.observe("pop", func() error {
+		element, err = s.Storage.Pop(key)
+		return err
+	})
+
+	return element, err
+}
+
+// statsStorage.Increment Adds delta to the integer stored by key timing it, returns the new value or error if it fails
+func (s *statsStorage) Increment(key string, delta int64) (counter int64, err error) {
+	err = s.observe("increment", func() error {
+		counter, err = s.Storage.Increment(key, delta)
+		return err
+	})
+
+	return counter, err
+}
+
+// statsStorage.Decrement Subtracts delta from the integer stored by key timing it, returns the new value or error if it fails
+func (s *statsStorage) Decrement(key string, delta int64) (int64, error) {
+	delta, err := negate(delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Increment(key, delta)
+}
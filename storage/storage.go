@@ -1,38 +1,572 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
 )
 
 const noExpiration time.Duration = -1
 
+var logger = logrus.New()
+
+func init() {
+	logger.Out = os.Stdout
+}
+
 var errNotExists = fmt.Errorf("entry does not exists")
 
+var errNotArray = fmt.Errorf("entry is not a JSON array")
+
+var errWrongType = fmt.Errorf("wrong type: operation against a key holding another kind of value")
+
+var errVersionMismatch = fmt.Errorf("entry version does not match")
+
+var errNotInteger = fmt.Errorf("entry is not an integer")
+
+var errIntegerOverflow = fmt.Errorf("entry would overflow a 64-bit integer")
+
+// inconsistentReader is a GetPattern listing a concurrent write may have torn, scanned with RelaxedScans
+type inconsistentReader struct {
+	io.Reader
+}
+
+// IsConsistent Returns whether r, a GetPattern listing, is known not to be torn by a write concurrent with the scan
+func IsConsistent(r io.Reader) bool {
+	_, torn := r.(inconsistentReader)
+
+	return !torn
+}
+
+// consistentAs Returns listing, marked as inconsistent if scanned, the listing it is made from, is
+func consistentAs(scanned io.Reader, listing io.Reader) io.Reader {
+	if IsConsistent(scanned) {
+		return listing
+	}
+
+	return inconsistentReader{listing}
+}
+
+// PutManyError Reports the keys a PutMany failed to save, by the error each failed with
+type PutManyError map[string]error
+
+func (e PutManyError) Error() string {
+	return fmt.Sprintf("%d keys failed to be saved", len(e))
+}
+
+// value types an entry is tagged with at its first write, enforced with TypedKeys
+const (
+	typeString  = "string"
+	typeCounter = "counter"
+	typeList    = "list"
+)
+
+// reservedFileNames are never treated as entries when scanning a storageDir
+var reservedFileNames = map[string]bool{
+	memoryCacheFile: true,
+}
+
 type entry struct {
+	Key        string   `json:"key"`
+	Value      []byte   `json:"value"`
+	Expiration int64    `json:"expiration"`
+	Type       string   `json:"type,omitempty"`
+	Created    int64    `json:"created,omitempty"`
+	Version    int64    `json:"version,omitempty"`
+	Metadata   Metadata `json:"metadata,omitempty"`
+}
+
+// Metadata Response headers stored along a value by their canonical name, e.g. `Content-Disposition`
+type Metadata map[string]string
+
+// MetadataHeaders are the headers that can be stored as Metadata
+var MetadataHeaders = []string{"Content-Type", "Content-Disposition", LastWriterHeader}
+
+// CreatedAtHeader is the metadata answering when a key was first written, RFC 3339, with CreationTimes
+const CreatedAtHeader = "X-Created-At"
+
+// entryMetadata decodes an entry skipping its value
+type entryMetadata struct {
 	Key        string `json:"key"`
-	Value      []byte `json:"value"`
 	Expiration int64  `json:"expiration"`
+	Created    int64  `json:"created,omitempty"`
+}
+
+// Health Reports whether a storage persists values normally, with the failures of its snapshot flushes if it takes any
+type Health struct {
+	Degraded           bool   `json:"degraded"`
+	Reason             string `json:"reason,omitempty"`
+	FlushFailures      int    `json:"flush_failures"`
+	FlushFailuresTotal int64  `json:"flush_failures_total"`
 }
 
 // Storage Interface for storage operations
 type Storage interface {
 	Put(key string, value string, expiration time.Duration) error
+	PutMany(entries map[string]string, expiration time.Duration) error
+	PutWithMetadata(key string, value string, metadata Metadata, expiration time.Duration) error
 	Get(key string) (io.Reader, error)
-	GetPattern(pattern string) (io.Reader, error)
+	GetMany(keys []string) (map[string][]byte, error)
+	GetStale(key string) (io.Reader, bool, error)
+	GetMetadata(key string) (Metadata, error)
+	GetAndTouch(key string, window time.Duration, threshold time.Duration) (io.Reader, error)
+	GetOrCreate(key string, defaultValue string, expiration time.Duration) ([]byte, bool, error)
+	PutIfTTLBelow(key string, value string, threshold time.Duration, expiration time.Duration) (bool, error)
+	CompareAndSwap(key string, oldValue string, newValue string, expiration time.Duration) (bool, error)
+	PutIfEmpty(prefix string, key string, value string, expiration time.Duration) (bool, error)
+	GetVersioned(key string) (io.Reader, int64, error)
+	PutIfVersion(key string, value string, version int64, expiration time.Duration) (int64, error)
+	DeleteIfVersion(key string, version int64) error
+	Exists(key string) (bool, error)
+	TTL(key string) (time.Duration, error)
+	ExistsMany(keys []string) (map[string]bool, error)
+	GetPattern(pattern string, limit int, offset int) (io.Reader, error)
+	Keys(pattern string) ([]string, error)
+	CountPattern(pattern string) (int, error)
+	ExpirePattern(pattern string, expiration time.Duration) (int, error)
 	Delete(key string) error
 	DeleteAll() error
+	PurgeExpired() ([]string, error)
+	Push(key string, element string) error
+	Pop(key string) (string, error)
+	Increment(key string, delta int64) (int64, error)
+	Decrement(key string, delta int64) (int64, error)
 
 	Type() string
 	IsNotExist(err error) bool
+	IsConflict(err error) bool
+	IsVersionMismatch(err error) bool
+	IsForbidden(err error) bool
+	IsUnavailable(err error) bool
+	HeldLocks() map[string]time.Duration
+	Health() Health
 
 	Flush()
 }
 
+// ContextBinder is implemented by storages able to bind their operations to a context
+type ContextBinder interface {
+	// WithContext Returns the storage with its operations aborted once ctx is done
+	WithContext(ctx context.Context) Storage
+}
+
+// URLSigner is implemented by storages able to serve a value from a URL of their own, such as object stores
+type URLSigner interface {
+	// SignedURL Returns a URL the value of key can be downloaded from until expiry passes, or error if it fails
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// CountedPatternGetter is implemented by storages counting the entries matching a pattern in the scan listing a page of them
+type CountedPatternGetter interface {
+	// GetPatternCounted Returns the page of the listing of a pattern along how many entries match it, or error if it fails
+	GetPatternCounted(pattern string, limit int, offset int) (io.Reader, int, error)
+}
+
+// Restricter is implemented by storages rejecting some operations whatever they are asked, as the redis provider
+// does with versions, and by the decorators passing on the ones of the storage they decorate
+type Restricter interface {
+	// Rejected Returns the operations rejected, named as listed by /capabilities
+	Rejected() []string
+}
+
+// Rejected Returns the operations storage rejects if it is a Restricter, none otherwise
+func Rejected(storage Storage) []string {
+	if restricter, ok := storage.(Restricter); ok {
+		return restricter.Rejected()
+	}
+
+	return nil
+}
+
+// WithContext Returns storage with its operations bound to ctx if it is a ContextBinder, storage as it is otherwise
+func WithContext(ctx context.Context, storage Storage) Storage {
+	if binder, ok := storage.(ContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+
+	return storage
+}
+
+// OptionFn Functional option type
+type OptionFn func(*config)
+
+type config struct {
+	fifoWrites bool
+
+	keyIndex          bool
+	reconcileInterval time.Duration
+
+	patternReaders int
+	relaxedScans   bool
+
+	encryptionKey []byte
+
+	fallbackDir string
+
+	typedKeys     bool
+	creationTimes bool
+
+	maxAge time.Duration
+
+	expiryGrace time.Duration
+
+	proxyURL string
+
+	redisAddr   string
+	redisPrefix string
+
+	readThroughProvider string
+	readThroughDir      string
+	readThroughTTL      time.Duration
+
+	maxMaintenance int
+
+	evictionInterval time.Duration
+
+	opLog string
+
+	appendOnly bool
+
+	compressionThreshold int
+
+	replicaURL       string
+	replicationQueue int
+
+	evictionWebhook string
+
+	snapshotLoadTimeout time.Duration
+
+	snapshotReload time.Duration
+
+	maxFlushFailures int
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+// FIFOWrites Serialize reads and writes of the same key strictly in arrival order
+func FIFOWrites() OptionFn {
+	return func(c *config) {
+		c.fifoWrites = true
+	}
+
+}
+
+// KeyIndex Keep an in-memory index of fs storage keys used by pattern queries,
+// reconciled with the storage dir every reconcileInterval
+func KeyIndex(reconcileInterval time.Duration) OptionFn {
+	return func(c *config) {
+		c.keyIndex = true
+		c.reconcileInterval = reconcileInterval
+	}
+
+}
+
+// Encryption Store values encrypted with keys derived from masterKey, see NewEncryptedStorage
+func Encryption(masterKey []byte) OptionFn {
+	return func(c *config) {
+		c.encryptionKey = masterKey
+	}
+
+}
+
+// FallbackDir Switch fs storage to dir once writes to the storage dir keep failing
+func FallbackDir(dir string) OptionFn {
+	return func(c *config) {
+		c.fallbackDir = dir
+	}
+
+}
+
+// TypedKeys Reject operations against keys tagged at their first write with another value type
+func TypedKeys() OptionFn {
+	return func(c *config) {
+		c.typedKeys = true
+	}
+
+}
+
+// CreationTimes Answer when every key was first written, kept by its updates until it is deleted or expires,
+// as the CreatedAtHeader metadata
+func CreationTimes() OptionFn {
+	return func(c *config) {
+		c.creationTimes = true
+	}
+
+}
+
+// MaxAge Treat entries created more than maxAge ago as expired, regardless of their expiration
+func MaxAge(maxAge time.Duration) OptionFn {
+	return func(c *config) {
+		c.maxAge = maxAge
+	}
+
+}
+
+// ExpiryGrace Set how long after expiring an entry is still served by GetStale and kept from being purged
+func ExpiryGrace(grace time.Duration) OptionFn {
+	return func(c *config) {
+		c.expiryGrace = grace
+	}
+
+}
+
+// ProxyURL Set base url of the keyvaluestorage instance the proxy provider forwards to
+func ProxyURL(baseURL string) OptionFn {
+	return func(c *config) {
+		c.proxyURL = baseURL
+	}
+
+}
+
+// Redis Set address of the redis server the redis provider stores keys in, under prefix
+func Redis(addr string, prefix string) OptionFn {
+	return func(c *config) {
+		c.redisAddr = addr
+		c.redisPrefix = prefix
+	}
+
+}
+
+// ReadThrough Set provider, with its storageDir, caching for at most ttl the values read from the storage, see NewReadThroughStorage
+func ReadThrough(provider string, storageDir string, ttl time.Duration) OptionFn {
+	return func(c *config) {
+		c.readThroughProvider = provider
+		c.readThroughDir = storageDir
+		c.readThroughTTL = ttl
+	}
+
+}
+
+// MaxMaintenance Set how many background maintenance tasks of a storage may run at once, 1 by default
+func MaxMaintenance(max int) OptionFn {
+	return func(c *config) {
+		c.maxMaintenance = max
+	}
+
+}
+
+// PatternReaders Set how many files the fs storage reads at once to answer GetPattern, 1 by default
+func PatternReaders(readers int) OptionFn {
+	return func(c *config) {
+		c.patternReaders = readers
+	}
+
+}
+
+// EvictionInterval Set memory and fs storages to purge expired entries in background every interval,
+// instead of only hiding them until PurgeExpired is called; built by NewStorage, they purge through
+// the storages decorating them
+func EvictionInterval(interval time.Duration) OptionFn {
+	return func(c *config) {
+		c.evictionInterval = interval
+	}
+
+}
+
+// OperationLog Set file the fs storage appends every put and delete to, for Replay
+func OperationLog(path string) OptionFn {
+	return func(c *config) {
+		c.opLog = path
+	}
+
+}
+
+// AppendOnly Set keys to be only created and read, never overwritten or deleted
+func AppendOnly() OptionFn {
+	return func(c *config) {
+		c.appendOnly = true
+	}
+
+}
+
+// Compression Set size in bytes from which values are stored compressed, when it makes them smaller
+func Compression(threshold int) OptionFn {
+	return func(c *config) {
+		c.compressionThreshold = threshold
+	}
+
+}
+
+// ReplicateTo Set keyvaluestorage instance at url every write is mirrored to in background,
+// through a queue of queueSize operations
+func ReplicateTo(url string, queueSize int) OptionFn {
+	return func(c *config) {
+		c.replicaURL = url
+		c.replicationQueue = queueSize
+	}
+
+}
+
+// EvictionWebhook Set url an event is posted to in background for every key deleted or purged once expired
+func EvictionWebhook(url string) OptionFn {
+	return func(c *config) {
+		c.evictionWebhook = url
+	}
+
+}
+
+// RelaxedScans Set the filesystem storage to scan patterns without locking every key against writes,
+// listings a write overlapped being told apart by IsConsistent
+func RelaxedScans() OptionFn {
+	return func(c *config) {
+		c.relaxedScans = true
+	}
+
+}
+
+// SnapshotReload Set the memory storage to check every interval whether another process replaced its snapshot, reloading it then
+func SnapshotReload(interval time.Duration) OptionFn {
+	return func(c *config) {
+		c.snapshotReload = interval
+	}
+
+}
+
+// SnapshotLoadTimeout Set how long the memory storage may take loading its snapshot at startup before failing
+func SnapshotLoadTimeout(timeout time.Duration) OptionFn {
+	return func(c *config) {
+		c.snapshotLoadTimeout = timeout
+	}
+
+}
+
+// MaxFlushFailures Set consecutive failed memory storage snapshot flushes after which writes are refused as unavailable,
+// until a flush succeeds again
+func MaxFlushFailures(max int) OptionFn {
+	return func(c *config) {
+		c.maxFlushFailures = max
+	}
+
+}
+
+// CircuitBreaker Set consecutive failures after which requests to remote storages fail for cooldown
+func CircuitBreaker(threshold int, cooldown time.Duration) OptionFn {
+	return func(c *config) {
+		c.breakerThreshold = threshold
+		c.breakerCooldown = cooldown
+	}
+
+}
+
+func newConfig(options []OptionFn) *config {
+	c := &config{}
+	for _, optionFn := range options {
+		optionFn(c)
+	}
+
+	return c
+}
+
+// NewStorage Factory for storage by provider name (fs|memory|proxy|redis)
+func NewStorage(provider string, storageDir string, options ...OptionFn) (Storage, error) {
+	c := newConfig(options)
+	if storageDir == "" && provider != "proxy" && provider != "redis" {
+		return nil, fmt.Errorf("basedir not set")
+	}
+
+	var storage Storage
+	var err error
+
+	switch provider {
+	case "proxy":
+		storage, err = NewHTTPProxyStorage(c.proxyURL)
+	case "redis":
+		storage, err = NewRedisStorage(c.redisAddr, RedisKeyPrefix(c.redisPrefix))
+	case "fs":
+		storage, err = NewFileSystemStorage(storageDir, options...)
+	case "memory":
+		storage, err = NewMemoryStorage(storageDir, options...)
+	default:
+		return nil, fmt.Errorf("provider not set or invalid: %s", provider)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	provided := storage
+
+	if (provider == "proxy" || provider == "redis") && c.breakerThreshold > 0 {
+		storage = NewBreakerStorage(storage, c.breakerThreshold, c.breakerCooldown)
+	}
+
+	if c.readThroughProvider != "" {
+		var cache Storage
+		if cache, err = NewStorage(c.readThroughProvider, c.readThroughDir); err != nil {
+			return nil, fmt.Errorf("read-through cache: %s", err)
+		}
+
+		storage = NewReadThroughStorage(storage, cache, c.readThroughTTL)
+	}
+
+	if c.encryptionKey != nil {
+		if storage, err = NewEncryptedStorage(storage, c.encryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// values are compressed before being encrypted
+	if c.compressionThreshold > 0 {
+		storage = NewCompressedStorage(storage, c.compressionThreshold)
+	}
+
+	if c.appendOnly {
+		storage = NewAppendOnlyStorage(storage)
+	}
+
+	if c.replicaURL != "" {
+		var secondary Storage
+		if secondary, err = NewHTTPProxyStorage(c.replicaURL); err != nil {
+			return nil, err
+		}
+
+		if c.breakerThreshold > 0 {
+			secondary = NewBreakerStorage(secondary, c.breakerThreshold, c.breakerCooldown)
+		}
+
+		storage = NewReplicatingStorage(storage, secondary, c.replicationQueue)
+	}
+
+	if c.evictionWebhook != "" {
+		storage = NewWebhookStorage(storage, c.evictionWebhook)
+	}
+
+	// background evictions purge through every decorator, the eviction webhook notifying them
+	if router, ok := provided.(evictionRouter); ok {
+		router.routeEvictions(storage)
+	}
+
+	return storage, nil
+}
+
+// checkStorageDir Returns error if storageDir exists and is not a directory
+func checkStorageDir(storageDir string) error {
+	info, err := os.Stat(storageDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot access storageDir (%s): %s", storageDir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("storageDir (%s) is not a directory", storageDir)
+	}
+
+	return nil
+}
+
 func getWriter(storageDir string, fileName string) (*os.File, error) {
 	if err := os.Mkdir(storageDir, 0700); err != nil && !os.IsExist(err) {
 		return nil, fmt.Errorf("cannot access storageDir (%s): %s", storageDir, err)
@@ -67,10 +601,282 @@ func getReader(storageDir string, fileName string) (*os.File, error) {
 	return f, nil
 }
 
+// patternReader Returns io.Reader for the JSON listing of entries sorted by key, HTML characters escaped
+func patternReader(entries []entry) (io.Reader, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	listing := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		listing = append(listing, map[string]string{entry.Key: string(entry.Value)})
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(listing); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+// createdTime Returns the creation time of an entry replacing current, kept from current unless it is new
+func createdTime(current entry) int64 {
+	if current.Created > 0 {
+		return current.Created
+	}
+
+	return now().UnixNano()
+}
+
+// withCreatedAt Returns the metadata of current along its creation time when enabled
+func withCreatedAt(enabled bool, current entry) Metadata {
+	if !enabled || current.Created == 0 {
+		return current.Metadata
+	}
+
+	metadata := make(Metadata, len(current.Metadata)+1)
+	for name, value := range current.Metadata {
+		metadata[name] = value
+	}
+
+	metadata[CreatedAtHeader] = time.Unix(0, current.Created).UTC().Format(time.RFC3339Nano)
+
+	return metadata
+}
+
+// pageKeys Returns keys sorted, from the offset-th on and at most limit of them, all of them if limit is 0
+func pageKeys(keys []string, limit int, offset int) []string {
+	sort.Strings(keys)
+
+	if offset >= len(keys) {
+		return []string{}
+	}
+
+	keys = keys[offset:]
+	if limit > 0 && limit < len(keys) {
+		keys = keys[:limit]
+	}
+
+	return keys
+}
+
+// compareAndSwapStored Saves newStored by key with timeout only if the value stored by key decodes to oldValue,
+// for decorators storing values encoded, returns whether it was saved or error if it fails
+func compareAndSwapStored(storage Storage, key string, oldValue string, newStored string, expiration time.Duration, decode func(string, []byte) ([]byte, error)) (bool, error) {
+	r, err := storage.Get(key)
+	if storage.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	stored, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+
+	value, err := decode(key, stored)
+	if err != nil {
+		return false, err
+	}
+
+	if string(value) != oldValue {
+		return false, nil
+	}
+
+	// the stored value changing meanwhile fails the swap
+	return storage.CompareAndSwap(key, string(stored), newStored, expiration)
+}
+
+// isFailure Returns whether err is a failure of storage rather than about the stored values
+// or a caller giving up on the operation
+func isFailure(storage Storage, err error) bool {
+	return err != nil && err != context.Canceled && !storage.IsNotExist(err) && !storage.IsConflict(err) &&
+		!storage.IsVersionMismatch(err) && !storage.IsForbidden(err)
+}
+
+func isConflict(err error) bool {
+	return err == errNotArray || err == errWrongType || err == errNotInteger || err == errIntegerOverflow
+}
+
+// checkType Returns errWrongType if current is tagged with another type than valueType
+func checkType(current entry, valueType string) error {
+	if len(current.Type) > 0 && current.Type != valueType {
+		return errWrongType
+	}
+
+	return nil
+}
+
+// pushElement Returns the JSON array value with element appended, an empty value is an empty array
+func pushElement(value []byte, element string) ([]byte, error) {
+	var elements []json.RawMessage
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return value, errNotArray
+		}
+	}
+
+	return json.Marshal(append(elements, json.RawMessage(element)))
+}
+
+// incrementValue Returns the integer value incremented by delta, as stored and as an integer, a missing value counting as 0
+func incrementValue(value []byte, delta int64) ([]byte, int64, error) {
+	var counter int64
+	if len(value) > 0 {
+		var err error
+		if counter, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return value, 0, errNotInteger
+		}
+	}
+
+	if (delta > 0 && counter > math.MaxInt64-delta) || (delta < 0 && counter < math.MinInt64-delta) {
+		return value, 0, errIntegerOverflow
+	}
+
+	counter += delta
+
+	return []byte(strconv.FormatInt(counter, 10)), counter, nil
+}
+
+// putMany Saves every entry by key with timeout one Put after the other, in key order,
+// for storages with no batched write, returns a PutManyError of the keys that failed
+func putMany(storage Storage, entries map[string]string, expiration time.Duration) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	failed := PutManyError{}
+	for _, key := range keys {
+		if err := storage.Put(key, entries[key], expiration); err != nil {
+			failed[key] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// getMany Returns the values of the existing keys one Get after the other, for storages with no batched read,
+// or error if any Get fails but for a missing key
+func getMany(storage Storage, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		r, err := storage.Get(key)
+		if storage.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// negate Returns the delta incrementing by as much as decrementing by delta does
+func negate(delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, errIntegerOverflow
+	}
+
+	return -delta, nil
+}
+
+// popElement Returns the JSON array value without its last element, and the element
+func popElement(value []byte) ([]byte, string, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(value, &elements); err != nil {
+		return value, "", errNotArray
+	}
+
+	if len(elements) == 0 {
+		return value, "", errNotExists
+	}
+
+	last := elements[len(elements)-1]
+
+	popped, err := json.Marshal(elements[:len(elements)-1])
+	if err != nil {
+		return value, "", err
+	}
+
+	return popped, string(last), nil
+}
+
 func md5Hash(s string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
 }
 
+func expirationTime(expiration time.Duration) int64 {
+	if expiration == noExpiration {
+		return 0
+	}
+
+	return now().Add(expiration).UnixNano()
+}
+
+// ttlBelow Returns whether an entry expiring at expirationTime has less than threshold left, never for entries without expiration
+func ttlBelow(expirationTime int64, threshold time.Duration) bool {
+	return expirationTime > 0 && time.Unix(0, expirationTime).Sub(now()) < threshold
+}
+
+// remainingTTL Returns the time left before an entry expiring at expirationTime and created at createdTime expires,
+// the max age bounding it, noExpiration for entries expiring never
+func remainingTTL(expirationTime int64, createdTime int64, maxAge time.Duration) time.Duration {
+	if maxAge > 0 && createdTime > 0 {
+		if tooOld := time.Unix(0, createdTime).Add(maxAge).UnixNano(); expirationTime == 0 || tooOld < expirationTime {
+			expirationTime = tooOld
+		}
+	}
+
+	if expirationTime == 0 {
+		return noExpiration
+	}
+
+	if ttl := time.Unix(0, expirationTime).Sub(now()); ttl > 0 {
+		return ttl
+	}
+
+	return 0
+}
+
+// isStale Returns whether an entry expiring at expirationTime expired less than grace ago
+func isStale(expirationTime int64, grace time.Duration) bool {
+	return grace > 0 && isExpired(expirationTime) && now().UnixNano() <= expirationTime+int64(grace)
+}
+
+// isTooOld Returns whether an entry created at createdTime is older than maxAge, never for entries without creation time
+func isTooOld(createdTime int64, maxAge time.Duration) bool {
+	return maxAge > 0 && createdTime > 0 && now().Sub(time.Unix(0, createdTime)) > maxAge
+}
+
+// slideExpiration Returns the expiration pushed window from now when less than threshold is left, never shortened
+func slideExpiration(expirationTime int64, window time.Duration, threshold time.Duration) (int64, bool) {
+	if !ttlBelow(expirationTime, threshold) {
+		return expirationTime, false
+	}
+
+	if slid := now().Add(window).UnixNano(); slid > expirationTime {
+		return slid, true
+	}
+
+	return expirationTime, false
+}
+
 func isExpired(expirationTime int64) bool {
-	return expirationTime > 0 && time.Now().UnixNano() > expirationTime
+	return expirationTime > 0 && now().UnixNano() > expirationTime
 }
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// events queued for the eviction webhook before new ones are dropped
+const evictionWebhookQueue = 1000
+
+// attempts of posting an event to the eviction webhook after the first one failed
+const evictionWebhookRetries = 3
+
+// delay before the first retry of an event, doubled on every following one
+var evictionWebhookBackoff = time.Second
+
+// time Flush leaves the events still queued to be posted, the ones left past it being dropped
+var evictionWebhookDrain = 5 * time.Second
+
+// evictionEvent is posted to the eviction webhook for a key expired or deleted, `*` for every key
+type evictionEvent struct {
+	Key   string `json:"key"`
+	Event string `json:"event"`
+}
+
+type webhookStorage struct {
+	Storage
+
+	url      string
+	client   *http.Client
+	queue    chan evictionEvent
+	done     chan struct{}
+	stopping chan struct{}
+	flush    *sync.Once
+
+	// posts are aborted once Flush gave up waiting for the queue to drain
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhookStorage Decorator for storage
+// posts an event to url for every key deleted or purged once expired, in background retrying failed posts,
+// dropping events when the queue is full so a slow webhook never delays the storage
+func NewWebhookStorage(storage Storage, url string) *webhookStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &webhookStorage{
+		Storage:  storage,
+		url:      url,
+		client:   &http.Client{Timeout: proxyTimeout},
+		queue:    make(chan evictionEvent, evictionWebhookQueue),
+		done:     make(chan struct{}),
+		stopping: make(chan struct{}),
+		flush:    &sync.Once{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	go s.notify()
+
+	return s
+}
+
+// webhookStorage.WithContext Returns the storage bound to ctx notifying through this one queue,
+// events are posted after the request
+func (s *webhookStorage) WithContext(ctx context.Context) Storage {
+	bound := *s
+	bound.Storage = WithContext(ctx, s.Storage)
+
+	return &bound
+}
+
+// webhookStorage.Rejected Returns the operations the decorated storage rejects
+func (s *webhookStorage) Rejected() []string {
+	return Rejected(s.Storage)
+}
+
+// notify Posts queued events to the webhook in order until Flush started, then the ones still queued
+// once each until Flush gives up on them
+func (s *webhookStorage) notify() {
+	defer close(s.done)
+
+	for {
+		select {
+		case event := <-s.queue:
+			s.deliver(event)
+		case <-s.stopping:
+			for {
+				select {
+				case event := <-s.queue:
+					s.deliver(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver Posts event to the webhook, retrying it with backoff until Flush started
+func (s *webhookStorage) deliver(event evictionEvent) {
+	if s.ctx.Err() != nil {
+		logger.Warnf("eviction webhook for %s (%s) not posted before stopping, dropped", event.Event, event.Key)
+		return
+	}
+
+	backoff := evictionWebhookBackoff
+
+	err := s.post(event)
+	for retry := 0; err != nil && retry < evictionWebhookRetries && s.wait(backoff); retry++ {
+		backoff *= 2
+
+		err = s.post(event)
+	}
+
+	if err != nil {
+		logger.Warnf("eviction webhook for %s (%s) failed, dropped: %s", event.Event, event.Key, err)
+		return
+	}
+
+	logger.Debugf("eviction webhook notified %s (%s)", event.Event, event.Key)
+}
+
+// wait Returns true after delay, false right away once Flush started
+func (s *webhookStorage) wait(delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-s.stopping:
+		return false
+	}
+}
+
+// post Sends event to the webhook, returns error unless it answers 2xx
+func (s *webhookStorage) post(event evictionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+
+	return nil
+}
+
+// enqueue Queues an event for the webhook, dropping it if the queue is full or Flush started
+func (s *webhookStorage) enqueue(event string, key string) {
+	select {
+	case <-s.stopping:
+		logger.Warnf("eviction webhook stopping, dropped %s (%s)", event, key)
+		return
+	default:
+	}
+
+	select {
+	case s.queue <- evictionEvent{Key: key, Event: event}:
+	default:
+		logger.Warnf("eviction webhook queue full, dropped %s (%s)", event, key)
+	}
+}
+
+// webhookStorage.Delete Deletes an entry by key and notifies it, returns error if it fails
+func (s *webhookStorage) Delete(key string) error {
+	if err := s.Storage.Delete(key); err != nil {
+		return err
+	}
+
+	s.enqueue("delete", key)
+
+	return nil
+}
+
+// webhookStorage.DeleteIfVersion Deletes an entry by key if its version is version and notifies it, returns error if it fails
+func (s *webhookStorage) DeleteIfVersion(key string, version int64) error {
+	if err := s.Storage.DeleteIfVersion(key, version); err != nil {
+		return err
+	}
+
+	s.enqueue("delete", key)
+
+	return nil
+}
+
+// webhookStorage.DeleteAll Deletes all entries and notifies it, returns error if it fails
+func (s *webhookStorage) DeleteAll() error {
+	if err := s.Storage.DeleteAll(); err != nil {
+		return err
+	}
+
+	s.enqueue("delete", "*")
+
+	return nil
+}
+
+// webhookStorage.PurgeExpired Deletes all expired entries and notifies every key expired, returns the keys purged or error if it fails
+func (s *webhookStorage) PurgeExpired() ([]string, error) {
+	purged, err := s.Storage.PurgeExpired()
+	for _, key := range purged {
+		s.enqueue("expire", key)
+	}
+
+	return purged, err
+}
+
+// webhookStorage.Flush Flushes storage, then drains the event queue posting the events still queued
+// without retrying them, for evictionWebhookDrain at most
+func (s *webhookStorage) Flush() {
+	s.Storage.Flush()
+
+	s.flush.Do(func() {
+		close(s.stopping)
+
+		select {
+		case <-s.done:
+		case <-time.After(evictionWebhookDrain):
+			s.cancel()
+			<-s.done
+		}
+	})
+}
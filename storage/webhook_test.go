@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookStorage(t *testing.T) {
+	defer func(backoff time.Duration) {
+		evictionWebhookBackoff = backoff
+	}(evictionWebhookBackoff)
+	evictionWebhookBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	var events []evictionEvent
+	posts := 0
+
+	// the first post fails, to be retried
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		posts++
+		if posts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var event evictionEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("err not expected: %s", err)
+		}
+
+		events = append(events, event)
+	}))
+	defer webhook.Close()
+
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	storage := NewWebhookStorage(memory, webhook.URL)
+
+	for key, expiration := range map[string]time.Duration{"an expired key": 0, "a key": time.Duration(-1)} {
+		if err := storage.Put(key, "a value", expiration); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	purged, err := storage.PurgeExpired()
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if len(purged) != 1 {
+		t.Fatalf("expected: %d, found : %d", 1, len(purged))
+	}
+
+	if err := storage.Delete("a key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Delete("a key"); !storage.IsNotExist(err) {
+		t.Fatalf("expected not exists, found : %v", err)
+	}
+
+	expected := []evictionEvent{{Key: "an expired key", Event: "expire"}, {Key: "a key", Event: "delete"}}
+
+	var found []evictionEvent
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		mu.Lock()
+		found = append([]evictionEvent{}, events...)
+		mu.Unlock()
+
+		if reflect.DeepEqual(found, expected) {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected: %v, found : %v", expected, found)
+	}
+
+	storage.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if posts != 3 {
+		t.Fatalf("expected: %d, found : %d", 3, posts)
+	}
+}
+
+// flushRecorder Records whether the storage it decorates was flushed
+type flushRecorder struct {
+	Storage
+
+	flushed chan struct{}
+}
+
+func (s *flushRecorder) Flush() {
+	s.Storage.Flush()
+	close(s.flushed)
+}
+
+func TestWebhookStorage_Flush(t *testing.T) {
+	defer func(drain time.Duration) {
+		evictionWebhookDrain = drain
+	}(evictionWebhookDrain)
+	evictionWebhookDrain = 100 * time.Millisecond
+
+	// a webhook never answering
+	unblock := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-unblock:
+		case <-req.Context().Done():
+		}
+	}))
+	defer webhook.Close()
+	defer close(unblock)
+
+	tmpDir := boostrapMemory(t)
+
+	memory, err := NewMemoryStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	recorder := &flushRecorder{Storage: memory, flushed: make(chan struct{})}
+	storage := NewWebhookStorage(recorder, webhook.URL)
+
+	for i := 0; i < evictionWebhookQueue; i++ {
+		key := fmt.Sprintf("key %d", i)
+		if err := storage.Put(key, "a value", time.Duration(-1)); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		if err := storage.Delete(key); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// deletes racing with the shutdown are dropped rather than panicking
+		for i := 0; i < 100; i++ {
+			if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+
+			if err := storage.Delete("a key"); err != nil {
+				t.Errorf("err not expected: %s", err)
+			}
+		}
+	}()
+
+	start := time.Now()
+	storage.Flush()
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected Flush within %s, found : %s", 5*time.Second, elapsed)
+	}
+
+	select {
+	case <-recorder.flushed:
+	default:
+		t.Fatalf("expected the storage flushed")
+	}
+
+	wg.Wait()
+
+	if err := storage.Put("a key", "a value", time.Duration(-1)); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+
+	if err := storage.Delete("a key"); err != nil {
+		t.Fatalf("err not expected: %s", err)
+	}
+}
+
+func TestWebhookStorage_EvictionInterval(t *testing.T) {
+	events := make(chan evictionEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event evictionEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("err not expected: %s", err)
+		}
+
+		select {
+		case events <- event:
+		default:
+		}
+	}))
+	defer webhook.Close()
+
+	for _, provider := range []string{"memory", "fs"} {
+		tmpDir := boostrapFilesystem(t)
+
+		storage, err := NewStorage(provider, tmpDir, EvictionInterval(50*time.Millisecond), EvictionWebhook(webhook.URL))
+		if err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		// the key expires in background, PurgeExpired is never called
+		if err := storage.Put("an expired key", "a value", time.Millisecond); err != nil {
+			t.Fatalf("err not expected: %s", err)
+		}
+
+		select {
+		case event := <-events:
+			expected := evictionEvent{Key: "an expired key", Event: "expire"}
+			if event != expected {
+				t.Fatalf("expected: %v, found : %v", expected, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the %s eviction notified", provider)
+		}
+
+		storage.Flush()
+	}
+}